@@ -0,0 +1,75 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/gwijnja/harvester"
+)
+
+// armorPrefix is the start of an ASCII armored file.
+var armorPrefix = []byte(armor.Header)
+
+// Decryptor decrypts an age file, armored or binary, and presents it to the next processor in the chain.
+// The decrypted data is streamed to the next processor while it is being decrypted, so it is never held in memory.
+// Every chunk of the file is authenticated before it is passed on, and a truncated file makes the transfer fail.
+type Decryptor struct {
+	harvester.NextProcessor
+	Identities harvester.Secret // Private keys, one per line as in an identity file, like harvester.FileSecret("/etc/harvester/keys.txt")
+	Passphrase harvester.Secret // Passphrase of files that were encrypted with a passphrase, nil if there are none
+	Logger     *slog.Logger     // nil for the logger of the job
+}
+
+// Process reads an encrypted file and writes the decrypted contents to the next processor
+func (d *Decryptor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Get the identities before anything is read
+	identities, err := identities(ctx, d.Identities, d.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	// Remove the armor, if there is one
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if head, _ := br.Peek(len(armorPrefix)); bytes.Equal(head, armorPrefix) {
+		in = armor.NewReader(br)
+		logger.Debug("age: File is armored", slog.String("filename", filename))
+	}
+
+	// Read the header, and find the identity that can decrypt it
+	plaintext, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return fmt.Errorf("age: Failed to decrypt %s: %w", filename, err)
+	}
+
+	// Remove the .age suffix from the filename
+	newname := filename
+	if strings.HasSuffix(filename, ".age") {
+		newname = strings.TrimSuffix(filename, ".age")
+		logger.Info("age: Removed .age suffix", slog.String("newname", newname))
+	}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			written, err := harvester.AuditCopy(ctx, "age.Decryptor", w, plaintext)
+			if err != nil {
+				return fmt.Errorf("age: Failed to decrypt %s: %w", filename, err)
+			}
+			logger.Info("age: Decrypted file", slog.String("filename", filename), slog.Int64("bytes", written))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("age: Calling the next processor")
+			return d.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
@@ -0,0 +1,85 @@
+package age
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/gwijnja/harvester"
+)
+
+// Encryptor encrypts a file with age and presents it to the next processor in the chain.
+// The encrypted data is streamed to the next processor while it is being encrypted, so it is never held in memory.
+type Encryptor struct {
+	harvester.NextProcessor
+	Recipients []string         // Public keys of the recipients, like "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	Passphrase harvester.Secret // Encrypt with a passphrase instead of Recipients, nil to use Recipients
+	Armor      bool             // Write an ASCII armored file, instead of binary
+	Logger     *slog.Logger     // nil for the logger of the job
+}
+
+// Process reads a file and writes the encrypted contents to the next processor
+func (e *Encryptor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, e.Logger)
+
+	// Get the recipients before anything is read
+	recipients, err := recipients(ctx, e.Recipients, e.Passphrase)
+	if err != nil {
+		return err
+	}
+	if len(e.Recipients) > 0 {
+		harvester.SetAuditDetail(ctx, "age_recipients", strings.Join(e.Recipients, ","))
+	}
+
+	newname := filename + ".age"
+	logger.Info("age: Renamed context filename", slog.String("newname", newname))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Armor the file if needed
+			var out io.WriteCloser = nopWriteCloser{w}
+			if e.Armor {
+				out = armor.NewWriter(w)
+			}
+
+			// Encrypt the input into the pipe
+			plaintext, err := age.Encrypt(out, recipients...)
+			if err != nil {
+				return fmt.Errorf("age: Failed to create age writer: %w", err)
+			}
+			written, err := harvester.AuditCopy(ctx, "age.Encryptor", plaintext, r)
+			if err != nil {
+				plaintext.Close()
+				return fmt.Errorf("age: Failed to copy input to age writer: %w", err)
+			}
+			logger.Info("age: Encrypted file", slog.String("filename", filename), slog.Int64("bytes", written))
+
+			// Write the last chunk
+			if err := plaintext.Close(); err != nil {
+				return fmt.Errorf("age: Failed to close age writer: %w", err)
+			}
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("age: Failed to close armor writer: %w", err)
+			}
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("age: Calling the next processor")
+			return e.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
+
+// nopWriteCloser adds a Close method that does nothing to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
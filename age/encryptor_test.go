@@ -0,0 +1,170 @@
+package age
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, string(b)
+	return nil
+}
+
+// secret returns the value as a harvester.Secret
+func secret(value string) harvester.Secret {
+	return harvester.SecretFunc(func(ctx context.Context) (string, error) { return value, nil })
+}
+
+// encrypt returns the file encrypted by the encryptor
+func encrypt(t *testing.T, e *Encryptor, input string) *recorder {
+	t.Helper()
+	encrypted := &recorder{}
+	e.SetNext(encrypted)
+	if err := e.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	return encrypted
+}
+
+func TestRoundTrip(t *testing.T) {
+	ours, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Repeat("order;customer;amount\n", 5000)
+
+	tests := []struct {
+		name      string
+		encryptor Encryptor
+		decryptor Decryptor
+		wantErr   bool
+	}{
+		{
+			name:      "binary",
+			encryptor: Encryptor{Recipients: []string{ours.Recipient().String()}},
+			decryptor: Decryptor{Identities: secret(ours.String())},
+		},
+		{
+			name:      "armored",
+			encryptor: Encryptor{Recipients: []string{ours.Recipient().String()}, Armor: true},
+			decryptor: Decryptor{Identities: secret(ours.String())},
+		},
+		{
+			name:      "one of several recipients",
+			encryptor: Encryptor{Recipients: []string{theirs.Recipient().String(), ours.Recipient().String()}},
+			decryptor: Decryptor{Identities: secret("# our key\n" + ours.String() + "\n")},
+		},
+		{
+			name:      "passphrase",
+			encryptor: Encryptor{Passphrase: secret("correct horse battery staple")},
+			decryptor: Decryptor{Passphrase: secret("correct horse battery staple")},
+		},
+		{
+			name:      "other key",
+			encryptor: Encryptor{Recipients: []string{theirs.Recipient().String()}},
+			decryptor: Decryptor{Identities: secret(ours.String())},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := encrypt(t, &tt.encryptor, input)
+			if encrypted.filename != "orders.csv.age" {
+				t.Errorf("encrypted file is named %s, want orders.csv.age", encrypted.filename)
+			}
+			if tt.encryptor.Armor != strings.HasPrefix(encrypted.data, armor.Header) {
+				t.Errorf("armor is %v, but the file starts with %q", tt.encryptor.Armor, encrypted.data[:10])
+			}
+
+			files := &recorder{}
+			tt.decryptor.SetNext(files)
+			err := tt.decryptor.Process(context.Background(), encrypted.filename, strings.NewReader(encrypted.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt returned %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (files.filename != "orders.csv" || files.data != input) {
+				t.Errorf("got %s with %d bytes, want orders.csv with %d bytes", files.filename, len(files.data), len(input))
+			}
+		})
+	}
+}
+
+func TestDecryptDamagedFile(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Repeat("order;customer;amount\n", 5000)
+	encrypted := encrypt(t, &Encryptor{Recipients: []string{identity.Recipient().String()}}, input).data
+
+	changed := []byte(encrypted)
+	changed[len(changed)-100] ^= 0x01
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"changed", string(changed)},
+		{"truncated", encrypted[:len(encrypted)-100]},
+		{"not age", input},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decryptor{Identities: secret(identity.String())}
+			d.SetNext(&recorder{})
+			if err := d.Process(context.Background(), "orders.csv.age", strings.NewReader(tt.data)); err == nil {
+				t.Errorf("decrypting succeeded")
+			}
+		})
+	}
+}
+
+func TestConfigurationErrors(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		encryptor Encryptor
+	}{
+		{"nothing", Encryptor{}},
+		{"both", Encryptor{Recipients: []string{identity.Recipient().String()}, Passphrase: secret("s3cret")}},
+		{"invalid recipient", Encryptor{Recipients: []string{"age1notakey"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.encryptor.SetNext(&recorder{})
+			if err := tt.encryptor.Process(context.Background(), "orders.csv", strings.NewReader("x")); err == nil {
+				t.Errorf("Process succeeded, want an error")
+			}
+		})
+	}
+
+	d := &Decryptor{}
+	d.SetNext(&recorder{})
+	if err := d.Process(context.Background(), "orders.csv.age", strings.NewReader("x")); err == nil {
+		t.Errorf("Decryptor without identities succeeded, want an error")
+	}
+}
@@ -0,0 +1,76 @@
+package age
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/gwijnja/harvester"
+)
+
+// recipients returns the recipients to encrypt for: the public keys, or the passphrase
+func recipients(ctx context.Context, keys []string, passphrase harvester.Secret) ([]age.Recipient, error) {
+	if len(keys) > 0 && passphrase != nil {
+		return nil, fmt.Errorf("age: Set either Recipients or Passphrase, not both")
+	}
+
+	if passphrase != nil {
+		secret, err := passphrase.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("age: Failed to get passphrase: %s", err)
+		}
+		recipient, err := age.NewScryptRecipient(secret)
+		if err != nil {
+			return nil, fmt.Errorf("age: Invalid passphrase: %s", err)
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("age: No Recipients or Passphrase")
+	}
+	result := []age.Recipient{}
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("age: Invalid recipient %s: %s", key, err)
+		}
+		result = append(result, recipient)
+	}
+	return result, nil
+}
+
+// identities returns the identities to decrypt with: the private keys, and the passphrase
+func identities(ctx context.Context, keys harvester.Secret, passphrase harvester.Secret) ([]age.Identity, error) {
+	result := []age.Identity{}
+
+	if keys != nil {
+		secret, err := keys.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("age: Failed to get identities: %s", err)
+		}
+		parsed, err := age.ParseIdentities(strings.NewReader(secret))
+		if err != nil {
+			return nil, fmt.Errorf("age: Failed to parse identities: %s", err)
+		}
+		result = append(result, parsed...)
+	}
+
+	if passphrase != nil {
+		secret, err := passphrase.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("age: Failed to get passphrase: %s", err)
+		}
+		identity, err := age.NewScryptIdentity(secret)
+		if err != nil {
+			return nil, fmt.Errorf("age: Invalid passphrase: %s", err)
+		}
+		result = append(result, identity)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("age: No Identities or Passphrase")
+	}
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package harvester
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"time"
+)
+
+// Statuses of a transfer in the audit trail.
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// AuditStore persists a record of every transfer.
+type AuditStore interface {
+	Save(ctx context.Context, record AuditRecord) error
+}
+
+// AuditRecord describes a single transfer from source to destination.
+type AuditRecord struct {
+	JobName           string            `json:"job,omitempty"`
+	RunID             string            `json:"run_id"`
+	TransferID        string            `json:"transfer_id"`
+	ParentID          string            `json:"parent_id,omitempty"` // Transfer of the archive for a file extracted from it, or of the file for its sidecar
+	SourceSystem      string            `json:"source_system"`
+	SourcePath        string            `json:"source_path"`
+	SourceEntry       string            `json:"source_entry,omitempty"` // Name of the file within the archive
+	DestinationSystem string            `json:"destination_system,omitempty"`
+	DestinationPath   string            `json:"destination_path,omitempty"`
+	SourceHashes      map[string]string `json:"source_hashes,omitempty"`      // Hashes of the first hop
+	DestinationHashes map[string]string `json:"destination_hashes,omitempty"` // Hashes of the last hop
+	Hops              []AuditHop        `json:"hops"`
+	Details           map[string]string `json:"details,omitempty"` // Set by processors, like the keys a file was encrypted for
+	Status            string            `json:"status"`
+	Error             string            `json:"error,omitempty"`
+	Started           time.Time         `json:"started"`
+	Finished          time.Time         `json:"finished"`
+}
+
+// AuditHop describes one copy of the data within a transfer, for example before and after compression.
+type AuditHop struct {
+	Name         string            `json:"name"`
+	Bytes        int64             `json:"bytes"`
+	Hashes       map[string]string `json:"hashes"`
+	Started      time.Time         `json:"started"`
+	Milliseconds int64             `json:"msec"`
+}
+
+// WithAuditStore returns a context that carries the audit store, which receives a record of every transfer.
+func WithAuditStore(ctx context.Context, store AuditStore) context.Context {
+	return context.WithValue(ctx, auditStoreKey, store)
+}
+
+// FinishTransfer saves the record of the transfer in the context to the audit store, if there is one.
+// The error is the result of the transfer, nil if it succeeded.
+func FinishTransfer(ctx context.Context, err error) {
+	logger := Logger(ctx)
+
+	t := TransferFromContext(ctx)
+	store, _ := ctx.Value(auditStoreKey).(AuditStore)
+	if t == nil || store == nil {
+		return
+	}
+
+	// Create the record
+	jobName, _ := ctx.Value(jobKey).(string)
+	t.mu.Lock()
+	record := AuditRecord{
+		JobName:           jobName,
+		RunID:             t.RunID,
+		TransferID:        t.TransferID,
+		ParentID:          t.ParentID,
+		SourceSystem:      t.SourceSystem,
+		SourcePath:        t.SourcePath,
+		SourceEntry:       t.SourceEntry,
+		DestinationSystem: t.destinationSystem,
+		DestinationPath:   t.destinationPath,
+		Hops:              append([]AuditHop{}, t.hops...),
+		Details:           maps.Clone(t.details),
+		Status:            StatusSuccess,
+		Started:           t.started,
+		Finished:          time.Now(),
+	}
+	t.mu.Unlock()
+	if len(record.Hops) > 0 {
+		record.SourceHashes = record.Hops[0].Hashes
+		record.DestinationHashes = record.Hops[len(record.Hops)-1].Hashes
+	}
+	if err != nil {
+		record.Status = StatusFailed
+		record.Error = err.Error()
+	}
+
+	// Save the record, without the cancellation of the context, so aborted transfers are recorded too
+	if err := store.Save(context.WithoutCancel(ctx), record); err != nil {
+		logger.Error("harvester: Failed to save audit record", slog.Any("error", err))
+		return
+	}
+	logger.Debug("harvester: Saved audit record", slog.String("status", record.Status))
+}
@@ -0,0 +1,101 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore is an audit store that keeps the records in memory
+type memoryStore struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (s *memoryStore) Save(ctx context.Context, record AuditRecord) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestFinishTransfer(t *testing.T) {
+	tests := []struct {
+		name       string
+		copies     []string // data of every copy in the transfer
+		err        error
+		cancel     bool
+		wantStatus string
+		wantSource string // sha1 of the first copy
+		wantDest   string // sha1 of the last copy
+	}{
+		{"success", []string{"id;amount\n"}, nil, false, StatusSuccess, "f2a5f1a10d662ef7402af78b4d3f37c3266aff76", "f2a5f1a10d662ef7402af78b4d3f37c3266aff76"},
+		{"two copies", []string{"id;amount\n", ""}, nil, false, StatusSuccess, "f2a5f1a10d662ef7402af78b4d3f37c3266aff76", "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+		{"no copies", nil, nil, false, StatusSuccess, "", ""},
+		{"failed", []string{"id;amount\n"}, errors.New("disk full"), false, StatusFailed, "f2a5f1a10d662ef7402af78b4d3f37c3266aff76", "f2a5f1a10d662ef7402af78b4d3f37c3266aff76"},
+		{"cancelled", nil, context.Canceled, true, StatusFailed, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryStore{}
+			ctx, cancel := context.WithCancel(WithAuditStore(WithJobName(StartRun(context.Background()), "orders"), store))
+			defer cancel()
+			ctx = StartTransfer(ctx, "local", "/in/orders.csv")
+			for i, data := range tt.copies {
+				if _, err := AuditCopy(ctx, "copy", &strings.Builder{}, strings.NewReader(data)); err != nil {
+					t.Fatalf("copy %d: %s", i, err)
+				}
+			}
+			SetAuditDetail(ctx, "pgp_signer", "B6293C6D239CC043")
+			SetDestination(ctx, "sftp://itsme@sftp.example.com:22", "/in/orders.csv")
+			if tt.cancel {
+				cancel()
+			}
+			FinishTransfer(ctx, tt.err)
+
+			// The record is saved once, also when the context is cancelled
+			if len(store.records) != 1 {
+				t.Fatalf("saved %d records, want 1", len(store.records))
+			}
+			record := store.records[0]
+			transfer := TransferFromContext(ctx)
+			if record.JobName != "orders" || record.RunID != RunID(ctx) || record.TransferID != transfer.TransferID {
+				t.Errorf("record has job %q, run %q and transfer %q", record.JobName, record.RunID, record.TransferID)
+			}
+			if record.SourcePath != "/in/orders.csv" || record.DestinationPath != "/in/orders.csv" || record.DestinationSystem != "sftp://itsme@sftp.example.com:22" {
+				t.Errorf("record has source %s and destination %s %s", record.SourcePath, record.DestinationSystem, record.DestinationPath)
+			}
+			if record.Status != tt.wantStatus || (tt.err == nil) != (record.Error == "") {
+				t.Errorf("record has status %q and error %q, want status %q for error %v", record.Status, record.Error, tt.wantStatus, tt.err)
+			}
+			if len(record.Hops) != len(tt.copies) {
+				t.Errorf("record has %d hops, want %d", len(record.Hops), len(tt.copies))
+			}
+			if record.SourceHashes["sha1"] != tt.wantSource || record.DestinationHashes["sha1"] != tt.wantDest {
+				t.Errorf("record has source hash %q and destination hash %q, want %q and %q", record.SourceHashes["sha1"], record.DestinationHashes["sha1"], tt.wantSource, tt.wantDest)
+			}
+			if want := map[string]string{"pgp_signer": "B6293C6D239CC043"}; !reflect.DeepEqual(record.Details, want) {
+				t.Errorf("record has details %v, want %v", record.Details, want)
+			}
+			if record.Finished.Before(record.Started) {
+				t.Errorf("record finished at %s, before it started at %s", record.Finished, record.Started)
+			}
+		})
+	}
+}
+
+func TestFinishTransferWithoutStore(t *testing.T) {
+	// Without a store or a transfer there is nothing to record, and nothing fails
+	FinishTransfer(StartTransfer(context.Background(), "local", "/in/orders.csv"), nil)
+	store := &memoryStore{}
+	FinishTransfer(WithAuditStore(context.Background(), store), nil)
+	if len(store.records) != 0 {
+		t.Errorf("saved %d records without a transfer, want none", len(store.records))
+	}
+}
@@ -1,40 +1,113 @@
 package harvester
 
 import (
-	"crypto/sha1"
+	"context"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"sort"
 	"time"
 )
 
-// AuditCopy copies data from src to dst, while calculating a SHA-1 hash of the data and logging statistics.
-func AuditCopy(dst io.Writer, src io.Reader) (written int64, err error) {
+// AuditCopy copies data from src to dst, while calculating hashes of the data and logging statistics.
+// The copy is aborted when the context is cancelled. The hop names the step in the audit trail, like "gzip.Compressor".
+func AuditCopy(ctx context.Context, hop string, dst io.Writer, src io.Reader) (written int64, err error) {
+	h, err := AuditCopyHop(ctx, hop, dst, src)
+	return h.Bytes, err
+}
+
+// AuditCopyHop works like AuditCopy, but returns the hop with the number of bytes and the hashes of the data.
+// The hash algorithms are taken from the context, see WithHashes.
+func AuditCopyHop(ctx context.Context, hop string, dst io.Writer, src io.Reader) (AuditHop, error) {
+	logger := Logger(ctx)
 
 	// Prepare copy
-	hasher := sha1.New()
-	writer := io.MultiWriter(dst, hasher)
+	hashers, err := newHashers(ctx)
+	if err != nil {
+		return AuditHop{}, err
+	}
+	writers := []io.Writer{dst}
+	for _, hasher := range hashers {
+		writers = append(writers, hasher)
+	}
+	writer := io.MultiWriter(writers...)
 	start := time.Now()
 
 	// Copy data
-	written, err = io.Copy(writer, src)
+	written, err := io.Copy(writer, NewContextReader(ctx, src))
 	if err != nil {
-		return written, fmt.Errorf("harvester: Failed copying data after %d bytes: %s", written, err)
+		return AuditHop{Name: hop, Bytes: written}, fmt.Errorf("harvester: Failed copying data after %d bytes: %w", written, err)
 	}
 
 	// Gather statistics
 	elapsed := time.Since(start)
-	sha1hash := hasher.Sum(nil)
+	hashes := sumHashes(hashers)
 	mebibytes := float64(written) / 1024 / 1024
 
 	// Log results
-	slog.Info(
-		"harvester: Copy complete",
+	attrs := []any{
+		slog.String("hop", hop),
 		slog.Int64("bytes", written),
 		slog.Int64("msec", elapsed.Milliseconds()),
 		slog.Float64("mibps", mebibytes/elapsed.Seconds()),
-		slog.String("sha1", fmt.Sprintf("%x", sha1hash)),
-	)
+	}
+	for _, algorithm := range sortedKeys(hashes) {
+		attrs = append(attrs, slog.String(algorithm, hashes[algorithm]))
+	}
+	logger.Info("harvester: Copy complete", attrs...)
+
+	// Add the hop to the audit trail of the transfer
+	h := AuditHop{
+		Name:         hop,
+		Bytes:        written,
+		Hashes:       hashes,
+		Started:      start,
+		Milliseconds: elapsed.Milliseconds(),
+	}
+	if t := TransferFromContext(ctx); t != nil {
+		t.addHop(h)
+	}
+
+	return h, nil
+}
 
-	return
+// sumHashes returns the hexadecimal digest of every hasher.
+func sumHashes(hashers map[string]hash.Hash) map[string]string {
+	hashes := make(map[string]string, len(hashers))
+	for algorithm, hasher := range hashers {
+		hashes[algorithm] = fmt.Sprintf("%x", hasher.Sum(nil))
+	}
+	return hashes
+}
+
+// sortedKeys returns the keys of the map in sorted order, for stable log output.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contextReader is a reader that stops reading once its context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader wraps r, so that a Read that starts after the context is cancelled fails with the context's error.
+// A Read that is already waiting for data is not interrupted, so connectors also close their connection when the
+// context is cancelled, to stop a transfer from a server that stalls.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+// Read reads from the underlying reader, unless the context is cancelled.
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
 }
@@ -0,0 +1,95 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestAuditCopyHashes(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithms []string // nil for the default
+		want       map[string]string
+	}{
+		{"default", nil, map[string]string{"sha1": "f2a5f1a10d662ef7402af78b4d3f37c3266aff76"}},
+		{"md5 and sha1", []string{"md5", "sha1"}, map[string]string{
+			"md5":  "41a0b69800bcc804955812fd00d346bc",
+			"sha1": "f2a5f1a10d662ef7402af78b4d3f37c3266aff76",
+		}},
+		{"sha256", []string{"sha256"}, map[string]string{"sha256": "8a07b01bafe14b57d8105aac3120ee8eaebaea0f83f1d119f02ba2ac009e52c8"}},
+		{"sha512", []string{"sha512"}, map[string]string{"sha512": "c8288413cdbd210f2b8e9e2f62879f2919f82e8e7b2ff27f5ecd8d1f34b65de73d4772673e297ea9dfbcd7968a36a3471c3ffd8ac435482552177f673b0421a5"}},
+		{"crc32", []string{"crc32"}, map[string]string{"crc32": "20b7806e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := StartTransfer(StartRun(context.Background()), "local", "/in/orders.csv")
+			if tt.algorithms != nil {
+				ctx = WithHashes(ctx, tt.algorithms)
+			}
+			var dst strings.Builder
+			hop, err := AuditCopyHop(ctx, "copy", &dst, strings.NewReader("id;amount\n"))
+			if err != nil {
+				t.Fatalf("AuditCopyHop: %s", err)
+			}
+			if dst.String() != "id;amount\n" || hop.Bytes != 10 || hop.Name != "copy" {
+				t.Errorf("copied %q as hop %s of %d bytes", dst.String(), hop.Name, hop.Bytes)
+			}
+			if !reflect.DeepEqual(hop.Hashes, tt.want) {
+				t.Errorf("hashes are %v, want %v", hop.Hashes, tt.want)
+			}
+			if hops := TransferFromContext(ctx).Hops(); len(hops) != 1 || !reflect.DeepEqual(hops[0].Hashes, tt.want) {
+				t.Errorf("transfer has hops %v, want one with the hashes", hops)
+			}
+		})
+	}
+}
+
+func TestAuditCopyFailures(t *testing.T) {
+	lost := errors.New("connection lost")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		src     io.Reader
+		wantErr error // nil for any error
+	}{
+		{"unsupported hash", WithHashes(context.Background(), []string{"sha1", "sha3"}), strings.NewReader("id;amount\n"), nil},
+		{"read error", context.Background(), io.MultiReader(strings.NewReader("id;"), iotest.ErrReader(lost)), lost},
+		{"cancelled", cancelled, strings.NewReader("id;amount\n"), context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := StartTransfer(tt.ctx, "local", "/in/orders.csv")
+			_, err := AuditCopy(ctx, "copy", io.Discard, tt.src)
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("AuditCopy returned %v, want %v", err, tt.wantErr)
+			}
+			if hops := TransferFromContext(ctx).Hops(); len(hops) != 0 {
+				t.Errorf("failed copy added %d hops, want none", len(hops))
+			}
+		})
+	}
+}
+
+func TestValidateHashes(t *testing.T) {
+	tests := []struct {
+		algorithms []string
+		wantErr    bool
+	}{
+		{nil, false},
+		{[]string{"md5", "sha1", "sha256", "sha512", "crc32"}, false},
+		{[]string{"SHA256"}, true},
+		{[]string{"sha1", "blake2b"}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateHashes(tt.algorithms); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateHashes(%v) returned %v, want error %v", tt.algorithms, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package autodetect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/bzip2"
+	"github.com/gwijnja/harvester/gzip"
+	"github.com/gwijnja/harvester/xz"
+	"github.com/gwijnja/harvester/zstd"
+)
+
+// format is a compression format, recognized by the first bytes of a file.
+type format struct {
+	name         string
+	magic        []byte
+	extension    string
+	decompressor func(logger *slog.Logger) harvester.FileWriter
+}
+
+// formats are the compression formats that are recognized.
+var formats = []format{
+	{"gzip", []byte{0x1f, 0x8b}, ".gz", func(logger *slog.Logger) harvester.FileWriter { return &gzip.Decompressor{Logger: logger} }},
+	{"bzip2", []byte("BZh"), ".bz2", func(logger *slog.Logger) harvester.FileWriter { return &bzip2.Decompressor{Logger: logger} }},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, ".xz", func(logger *slog.Logger) harvester.FileWriter { return &xz.Decompressor{Logger: logger} }},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst", func(logger *slog.Logger) harvester.FileWriter { return &zstd.Decompressor{Logger: logger} }},
+}
+
+// Decompressor recognizes the compression format of a file by its first bytes, and decompresses it with the
+// decompressor of that format. Gzip, bzip2, xz and zstd are recognized. The name of the file does not matter,
+// so a .gz file that is actually compressed with zstd is decompressed too. The extension of any of the formats
+// is removed, whatever the format of the contents, so that file loses its .gz extension.
+type Decompressor struct {
+	harvester.NextProcessor
+	PassThrough bool         // Present files that are not compressed to the next processor as they are, instead of failing
+	Logger      *slog.Logger // nil for the logger of the job
+}
+
+// Process reads a compressed file and writes the uncompressed contents to the next processor
+func (d *Decompressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Look at the first bytes, without consuming them
+	br := bufio.NewReader(r)
+	head, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("autodetect: Failed to read %s: %s", filename, err)
+	}
+
+	for _, f := range formats {
+		if bytes.HasPrefix(head, f.magic) {
+			newname := uncompressedName(filename)
+			logger.Info("autodetect: Detected compression format", slog.String("filename", filename), slog.String("format", f.name), slog.String("newname", newname))
+
+			// The decompressor only removes its own extension, so the next processor gets the name chosen here
+			decompressor := f.decompressor(d.Logger)
+			decompressor.SetNext(&renamer{next: &d.NextProcessor, filename: newname})
+			return decompressor.Process(ctx, filename, br)
+		}
+	}
+
+	if !d.PassThrough {
+		return fmt.Errorf("autodetect: Unknown compression format of %s", filename)
+	}
+	logger.Info("autodetect: File is not compressed, passing it through", slog.String("filename", filename))
+	return d.NextProcessor.Process(ctx, filename, br)
+}
+
+// uncompressedName returns the filename without the extension of a compression format, if it has one
+func uncompressedName(filename string) string {
+	for _, f := range formats {
+		if strings.HasSuffix(filename, f.extension) {
+			return strings.TrimSuffix(filename, f.extension)
+		}
+	}
+	return filename
+}
+
+// renamer presents the files of a decompressor to the next processor under a filename of its own
+type renamer struct {
+	next     harvester.FileWriter
+	filename string
+}
+
+// SetNext does nothing, the next processor is set when the renamer is created
+func (r *renamer) SetNext(harvester.FileWriter) {}
+
+// Process passes the file on under the filename of the renamer
+func (r *renamer) Process(ctx context.Context, _ string, rd io.Reader) error {
+	return r.next.Process(ctx, r.filename, rd)
+}
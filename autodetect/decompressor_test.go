@@ -0,0 +1,99 @@
+package autodetect
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/bzip2"
+	"github.com/gwijnja/harvester/gzip"
+	"github.com/gwijnja/harvester/xz"
+	"github.com/gwijnja/harvester/zstd"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     []byte
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, b
+	return nil
+}
+
+// compress returns the data compressed with the compressor
+func compress(t *testing.T, compressor harvester.FileWriter, data string) []byte {
+	t.Helper()
+	compressed := &recorder{}
+	compressor.SetNext(compressed)
+	if err := compressor.Process(context.Background(), "file", strings.NewReader(data)); err != nil {
+		t.Fatalf("compressing: %s", err)
+	}
+	return compressed.data
+}
+
+func TestDecompressorRemovesOriginalExtension(t *testing.T) {
+	const data = "order;customer;amount\n"
+	tests := []struct {
+		name       string
+		filename   string
+		compressor harvester.FileWriter
+		want       string
+	}{
+		{"gzip as .gz", "orders.csv.gz", &gzip.Compressor{}, "orders.csv"},
+		{"zstd as .gz", "orders.csv.gz", &zstd.Compressor{}, "orders.csv"},
+		{"gzip as .zst", "orders.csv.zst", &gzip.Compressor{}, "orders.csv"},
+		{"xz as .bz2", "orders.csv.bz2", &xz.Compressor{}, "orders.csv"},
+		{"bzip2 as .xz", "orders.csv.xz", &bzip2.Compressor{}, "orders.csv"},
+		{"bzip2 without extension", "orders.csv", &bzip2.Compressor{}, "orders.csv"},
+		{"only the last extension", "orders.csv.zst.gz", &zstd.Compressor{}, "orders.csv.zst"},
+		{"other extension", "orders.dat", &gzip.Compressor{}, "orders.dat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := &recorder{}
+			d := &Decompressor{}
+			d.SetNext(files)
+			if err := d.Process(context.Background(), tt.filename, bytes.NewReader(compress(t, tt.compressor, data))); err != nil {
+				t.Fatalf("Process: %s", err)
+			}
+			if files.filename != tt.want || string(files.data) != data {
+				t.Errorf("got %s with %q, want %s with %q", files.filename, files.data, tt.want, data)
+			}
+		})
+	}
+}
+
+func TestDecompressorPassThrough(t *testing.T) {
+	tests := []struct {
+		passThrough bool
+		wantErr     bool
+	}{
+		{false, true},
+		{true, false},
+	}
+	for _, tt := range tests {
+		files := &recorder{}
+		d := &Decompressor{PassThrough: tt.passThrough}
+		d.SetNext(files)
+		err := d.Process(context.Background(), "orders.csv.gz", strings.NewReader("not compressed"))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("PassThrough %v: error %v, want error %v", tt.passThrough, err, tt.wantErr)
+			continue
+		}
+		// A file that is passed through is not renamed, because it was not decompressed
+		if err == nil && (files.filename != "orders.csv.gz" || string(files.data) != "not compressed") {
+			t.Errorf("PassThrough %v: got %s with %q, want the file unchanged", tt.passThrough, files.filename, files.data)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bundleSuffix marks the directory of an archive that is still being collected.
+const bundleSuffix = ".part"
+
+// Bundler collects files in archives in a directory, and presents the archives to the next processor at the end of
+// a run. The zip and tar processors use it for their Bundle option, and only provide Build and Deliver.
+//
+// Every file is stored in a directory next to the archive, named after the archive with a .part suffix, and counts
+// as delivered once it is there. The archive is built from the stored files when it is full or when the run is
+// flushed, and the directory is removed once the archive is complete. A file that fails to be stored fails on its
+// own, and an archive that could not be built or delivered is tried again on the next flush, so a file that counts
+// as delivered is never lost, also not when the program stops during a run.
+type Bundler struct {
+	Dir       string       // Directory where archives are collected and kept until they are delivered, required
+	Name      string       // Filename of the archive as a Go time layout, formatted with the time it is started
+	Extension string       // Extension of the archives, like ".zip", to find the archives that are ready in Dir
+	MaxFiles  int          // Start a new archive after this many files, 0 for no limit
+	MaxBytes  int64        // Start a new archive after this many uncompressed bytes, 0 for no limit
+	Hop       string       // Name of the processor in the audit trail, like "zip.Compressor"
+	Logger    *slog.Logger // nil for the logger of the job
+
+	// Build writes an archive with the files to w, in the order they were added.
+	Build func(ctx context.Context, w io.Writer, entries []BundleEntry) error
+
+	// Deliver presents a finished archive to the next processor.
+	Deliver func(ctx context.Context, filename string, r io.Reader) error
+
+	mu   sync.Mutex
+	open *openBundle
+}
+
+// BundleEntry is a file that was added to a bundle.
+type BundleEntry struct {
+	Name    string      `json:"name"`               // Filename that was presented to the processor
+	Size    int64       `json:"size"`               // Size of the data in bytes
+	Added   time.Time   `json:"added"`              // Time the file was added to the bundle
+	ModTime time.Time   `json:"mod_time,omitempty"` // Modification time of the source file, zero if the reader did not record it
+	Mode    fs.FileMode `json:"mode,omitempty"`     // Permissions of the source file, 0 if the reader did not record them
+
+	path string
+}
+
+// Open returns the data of the file.
+func (e BundleEntry) Open() (io.ReadCloser, error) {
+	f, err := os.Open(e.path)
+	if err != nil {
+		return nil, fmt.Errorf("harvester: Failed to open bundled file %s: %s", e.Name, err)
+	}
+	return f, nil
+}
+
+// openBundle is the archive that files are currently added to.
+type openBundle struct {
+	path  string // Path of the finished archive, the files are stored in path + bundleSuffix
+	files int
+	bytes int64
+}
+
+// Add stores the file in the open archive, and starts a new archive if there is none. The file is written to a
+// temporary file first, so a file that fails halfway does not end up in the archive. When the archive is full,
+// it is built right away.
+func (b *Bundler) Add(ctx context.Context, filename string, r io.Reader) error {
+	logger := ContextLogger(ctx, b.Logger)
+
+	// Store the data in a temporary file, outside the lock, so files are received in parallel
+	tmp, err := os.CreateTemp(b.Dir, ".harvester-*")
+	if err != nil {
+		return fmt.Errorf("harvester: Failed to create temporary file in bundle directory %s: %s", b.Dir, err)
+	}
+	written, err := AuditCopy(ctx, b.Hop, tmp, r)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("harvester: Failed to write temporary file %s: %s", tmp.Name(), closeErr)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("harvester: Failed to store %s for the bundle: %w", filename, err)
+	}
+
+	entry := BundleEntry{Name: filename, Size: written, Added: time.Now()}
+	if t := TransferFromContext(ctx); t != nil {
+		entry.ModTime = t.SourceModTime()
+		entry.Mode = t.SourceMode()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Start a new archive if needed
+	if b.open == nil {
+		if err := b.start(ctx); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	open := b.open
+
+	// Move the data into the directory of the archive, and describe it. The file is part of the archive once its
+	// description exists.
+	base := filepath.Join(open.path+bundleSuffix, fmt.Sprintf("%06d", open.files+1))
+	if err := os.Rename(tmp.Name(), base); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("harvester: Failed to add %s to bundle %s: %s", filename, open.path, err)
+	}
+	if err := writeBundleEntry(base+".json", entry); err != nil {
+		os.Remove(base)
+		return fmt.Errorf("harvester: Failed to add %s to bundle %s: %s", filename, open.path, err)
+	}
+	open.files++
+	open.bytes += written
+	logger.Info("harvester: Added file to bundle", slog.String("filename", filename), slog.String("archive", open.path), slog.Int("files", open.files))
+	SetDestination(ctx, "local", open.path)
+
+	// Build the archive when it is full
+	if (b.MaxFiles > 0 && open.files >= b.MaxFiles) || (b.MaxBytes > 0 && open.bytes >= b.MaxBytes) {
+		b.open = nil
+		if err := b.build(ctx, open.path); err != nil {
+			logger.Error("harvester: Failed to build bundle, trying again when the run is flushed", slog.String("archive", open.path), slog.Any("error", err))
+		}
+	}
+	return nil
+}
+
+// Flush builds the open archive and the archives that were left unfinished, and presents all archives in the
+// directory to the next processor. Archives that are accepted are removed, the others are tried again on the next
+// flush.
+func (b *Bundler) Flush(ctx context.Context) error {
+	logger := ContextLogger(ctx, b.Logger)
+
+	// Build the open archive, and the archives of earlier runs that could not be built, also when the run was
+	// cancelled, so no file is left behind
+	b.mu.Lock()
+	b.open = nil
+	b.mu.Unlock()
+
+	entries, err := os.ReadDir(b.Dir)
+	if err != nil {
+		return fmt.Errorf("harvester: Failed to list bundle directory %s: %s", b.Dir, err)
+	}
+	var firstErr error
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), b.Extension+bundleSuffix) {
+			path := filepath.Join(b.Dir, strings.TrimSuffix(entry.Name(), bundleSuffix))
+			if err := b.build(ctx, path); err != nil {
+				logger.Error("harvester: Failed to build bundle, keeping its files for the next run", slog.String("archive", path), slog.Any("error", err))
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+
+	// Find the archives that are ready, including those of earlier runs that could not be delivered
+	entries, err = os.ReadDir(b.Dir)
+	if err != nil {
+		return fmt.Errorf("harvester: Failed to list bundle directory %s: %s", b.Dir, err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), b.Extension) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	// Deliver the archives
+	for _, name := range names {
+		if ctx.Err() != nil {
+			logger.Info("harvester: Keeping bundles for the next run", slog.Any("reason", ctx.Err()))
+			return ctx.Err()
+		}
+		if err := b.deliver(ctx, filepath.Join(b.Dir, name)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// start creates the directory of a new archive. The caller must hold the lock.
+func (b *Bundler) start(ctx context.Context) error {
+	logger := ContextLogger(ctx, b.Logger)
+
+	name := time.Now().Format(b.Name)
+
+	// Do not reuse the name of an archive that is waiting to be built or delivered
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	path := filepath.Join(b.Dir, name)
+	for i := 2; exists(path) || exists(path+bundleSuffix); i++ {
+		path = filepath.Join(b.Dir, fmt.Sprintf("%s-%d%s", base, i, extension))
+	}
+
+	if err := os.Mkdir(path+bundleSuffix, 0755); err != nil {
+		return fmt.Errorf("harvester: Failed to create bundle %s: %s", path, err)
+	}
+	b.open = &openBundle{path: path}
+	logger.Info("harvester: Started bundle", slog.String("archive", path))
+	return nil
+}
+
+// build writes the archive from the files in its directory, and removes the directory. The archive is written to
+// a temporary file that is renamed when it is complete, so an archive in the directory is always complete.
+func (b *Bundler) build(ctx context.Context, path string) error {
+	logger := ContextLogger(ctx, b.Logger)
+	dir := path + bundleSuffix
+
+	// The archive was built before the program stopped, only the directory is left
+	if exists(path) {
+		logger.Info("harvester: Removing files of bundle that was already built", slog.String("archive", path))
+		return os.RemoveAll(dir)
+	}
+
+	entries, err := readBundleEntries(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		logger.Debug("harvester: Removing empty bundle", slog.String("archive", path))
+		return os.RemoveAll(dir)
+	}
+
+	// Write the archive
+	tmp, err := os.CreateTemp(b.Dir, ".harvester-*")
+	if err != nil {
+		return fmt.Errorf("harvester: Failed to create bundle %s: %s", path, err)
+	}
+	err = b.Build(ctx, tmp, entries)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("harvester: Failed to build bundle %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("harvester: Failed to rename bundle %s: %s", path, err)
+	}
+	logger.Info("harvester: Finished bundle", slog.String("archive", path), slog.Int("files", len(entries)))
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("harvester: Failed to remove files of bundle %s: %s", path, err)
+	}
+	return nil
+}
+
+// deliver presents an archive to the next processor as a transfer of its own, and removes it when it was accepted
+func (b *Bundler) deliver(ctx context.Context, path string) (err error) {
+	ctx = StartTransfer(ctx, "local", path)
+	logger := ContextLogger(ctx, b.Logger)
+	defer func() {
+		FinishTransfer(ctx, err)
+	}()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("harvester: Failed to open bundle %s: %s", path, err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil {
+		SetSourceModTime(ctx, info.ModTime())
+	}
+
+	err = Stream(
+		func(w io.Writer) error {
+			_, err := AuditCopy(ctx, b.Hop+" (bundle)", w, f)
+			return err
+		},
+		func(r io.Reader) error {
+			return b.Deliver(ctx, filepath.Base(path), r)
+		},
+	)
+	if err != nil {
+		logger.Error("harvester: Failed to deliver bundle, keeping it for the next run", slog.String("archive", path), slog.Any("error", err))
+		return err
+	}
+	logger.Info("harvester: Delivered bundle", slog.String("archive", path))
+
+	f.Close()
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("harvester: Failed to remove delivered bundle %s: %s", path, err)
+	}
+	return nil
+}
+
+// writeBundleEntry writes the description of a file in a bundle
+func writeBundleEntry(path string, entry BundleEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readBundleEntries returns the files in the directory of a bundle, in the order they were added. Data without a
+// description was not added completely, and is ignored.
+func readBundleEntries(dir string) ([]BundleEntry, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("harvester: Failed to list bundle %s: %s", dir, err)
+	}
+	sort.Strings(names)
+
+	entries := make([]BundleEntry, 0, len(names))
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("harvester: Failed to read bundled file %s: %s", name, err)
+		}
+		var entry BundleEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return nil, fmt.Errorf("harvester: Failed to parse bundled file %s: %s", name, err)
+		}
+		entry.path = strings.TrimSuffix(name, ".json")
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// exists reports whether the path exists
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
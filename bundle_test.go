@@ -0,0 +1,208 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testBundler returns a bundler that writes archives as lines of name=content, and records what it delivers
+func testBundler(dir string, delivered *[]string) *Bundler {
+	return &Bundler{
+		Dir:       dir,
+		Name:      "bundle-20060102-150405.000000000.txt",
+		Extension: ".txt",
+		Hop:       "test",
+		Build: func(ctx context.Context, w io.Writer, entries []BundleEntry) error {
+			for _, entry := range entries {
+				data, err := entry.Open()
+				if err != nil {
+					return err
+				}
+				b, err := io.ReadAll(data)
+				data.Close()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s=%s\n", entry.Name, b)
+			}
+			return nil
+		},
+		Deliver: func(ctx context.Context, filename string, r io.Reader) error {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			*delivered = append(*delivered, string(b))
+			return nil
+		},
+	}
+}
+
+func TestBundlerDelivers(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFiles int
+		files    []string
+		want     []string
+	}{
+		{"nothing", 0, nil, nil},
+		{"one archive", 0, []string{"a", "b", "c"}, []string{"a=a\nb=b\nc=c\n"}},
+		{"max files", 2, []string{"a", "b", "c"}, []string{"a=a\nb=b\n", "c=c\n"}},
+		{"exactly full", 3, []string{"a", "b", "c"}, []string{"a=a\nb=b\nc=c\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var delivered []string
+			b := testBundler(t.TempDir(), &delivered)
+			b.MaxFiles = tt.maxFiles
+
+			for _, name := range tt.files {
+				if err := b.Add(ctx, name, strings.NewReader(name)); err != nil {
+					t.Fatalf("Add(%s): %s", name, err)
+				}
+			}
+			if err := b.Flush(ctx); err != nil {
+				t.Fatalf("Flush: %s", err)
+			}
+			if !reflect.DeepEqual(delivered, tt.want) {
+				t.Errorf("delivered %q, want %q", delivered, tt.want)
+			}
+			assertEmpty(t, b.Dir)
+		})
+	}
+}
+
+func TestBundlerKeepsEarlierFilesWhenAFileFails(t *testing.T) {
+	ctx := context.Background()
+	var delivered []string
+	b := testBundler(t.TempDir(), &delivered)
+
+	if err := b.Add(ctx, "a", strings.NewReader("a")); err != nil {
+		t.Fatalf("Add(a): %s", err)
+	}
+	failing := io.MultiReader(strings.NewReader("half"), iotest.ErrReader(errors.New("connection lost")))
+	if err := b.Add(ctx, "b", failing); err == nil {
+		t.Fatalf("Add(b) succeeded with a failing reader")
+	}
+	if err := b.Add(ctx, "c", strings.NewReader("c")); err != nil {
+		t.Fatalf("Add(c): %s", err)
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+
+	want := []string{"a=a\nc=c\n"}
+	if !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered %q, want %q", delivered, want)
+	}
+	assertEmpty(t, b.Dir)
+}
+
+func TestBundlerRetriesOnNextFlush(t *testing.T) {
+	tests := []struct {
+		name   string
+		breaks func(b *Bundler) func()
+	}{
+		{"build fails", func(b *Bundler) func() {
+			build := b.Build
+			b.Build = func(ctx context.Context, w io.Writer, entries []BundleEntry) error {
+				return errors.New("disk full")
+			}
+			return func() { b.Build = build }
+		}},
+		{"delivery fails", func(b *Bundler) func() {
+			deliver := b.Deliver
+			b.Deliver = func(ctx context.Context, filename string, r io.Reader) error {
+				return errors.New("server down")
+			}
+			return func() { b.Deliver = deliver }
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var delivered []string
+			b := testBundler(t.TempDir(), &delivered)
+
+			repair := tt.breaks(b)
+			for _, name := range []string{"a", "b"} {
+				if err := b.Add(ctx, name, strings.NewReader(name)); err != nil {
+					t.Fatalf("Add(%s): %s", name, err)
+				}
+			}
+			if err := b.Flush(ctx); err == nil {
+				t.Fatalf("Flush succeeded while broken")
+			}
+			if len(delivered) != 0 {
+				t.Fatalf("delivered %q while broken", delivered)
+			}
+
+			// The next run has a new bundler, like after a restart of the program
+			repair()
+			next := testBundler(b.Dir, &delivered)
+			next.Build, next.Deliver = b.Build, b.Deliver
+			if err := next.Add(ctx, "c", strings.NewReader("c")); err != nil {
+				t.Fatalf("Add(c): %s", err)
+			}
+			if err := next.Flush(ctx); err != nil {
+				t.Fatalf("Flush: %s", err)
+			}
+
+			want := []string{"a=a\nb=b\n", "c=c\n"}
+			if !reflect.DeepEqual(delivered, want) {
+				t.Errorf("delivered %q, want %q", delivered, want)
+			}
+			assertEmpty(t, b.Dir)
+		})
+	}
+}
+
+func TestBundlerIgnoresIncompleteFiles(t *testing.T) {
+	ctx := context.Background()
+	var delivered []string
+	b := testBundler(t.TempDir(), &delivered)
+
+	// A crash after the data was moved into the archive, but before it was described
+	part := filepath.Join(b.Dir, "bundle-old.txt"+bundleSuffix)
+	if err := os.Mkdir(part, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBundleEntry(filepath.Join(part, "000001.json"), BundleEntry{Name: "a", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"000001": "a", "000002": "b"} {
+		if err := os.WriteFile(filepath.Join(part, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	want := []string{"a=a\n"}
+	if !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered %q, want %q", delivered, want)
+	}
+	assertEmpty(t, b.Dir)
+}
+
+// assertEmpty fails if anything is left in the directory
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("left behind in bundle directory: %s", entry.Name())
+	}
+}
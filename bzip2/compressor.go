@@ -0,0 +1,63 @@
+package bzip2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/gwijnja/harvester"
+)
+
+// Compressor compresses a file using bzip2 and presents it to the next processor in the chain.
+// The compressed data is streamed to the next processor while it is being compressed, so it is never held in memory.
+type Compressor struct {
+	harvester.NextProcessor
+	Level  int          // Block size from 1 (100 kB) to 9 (900 kB, smallest), 0 for 9
+	Logger *slog.Logger // nil for the logger of the job
+}
+
+// Process reads a file and writes the compressed contents to the next processor
+func (c *Compressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, c.Logger)
+
+	level := c.Level
+	if level == 0 {
+		level = 9
+	}
+	if level < 1 || level > 9 {
+		return fmt.Errorf("bzip2: Invalid compression level %d, use 1 to 9", c.Level)
+	}
+
+	newname := filename + ".bz2"
+	logger.Info("bzip2: Renamed context filename", slog.String("newname", newname))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Compress the input into the pipe
+			bzip2Writer, err := bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+			if err != nil {
+				return fmt.Errorf("bzip2: Failed to create bzip2 writer: %w", err)
+			}
+			written, err := harvester.AuditCopy(ctx, "bzip2.Compressor", bzip2Writer, r)
+			if err != nil {
+				bzip2Writer.Close()
+				return fmt.Errorf("bzip2: Failed to copy input to bzip2 writer: %w", err)
+			}
+			logger.Info("bzip2: Copied input to bzip2 writer", slog.String("filename", filename), slog.Int64("bytes", written))
+
+			// Write the last block and the end of the stream
+			err = bzip2Writer.Close()
+			if err != nil {
+				return fmt.Errorf("bzip2: Failed to close bzip2 writer: %w", err)
+			}
+			logger.Info("bzip2: Closed bzip2 writer", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("bzip2: Calling the next processor")
+			return c.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
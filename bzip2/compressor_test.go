@@ -0,0 +1,90 @@
+package bzip2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, string(b)
+	return nil
+}
+
+func TestCompressorLevels(t *testing.T) {
+	tests := []struct {
+		level   int
+		wantErr bool
+	}{
+		{-1, true},
+		{0, false},
+		{1, false},
+		{5, false},
+		{9, false},
+		{10, true},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		input := strings.Repeat("order;customer;amount\n", 1000)
+
+		// Compress, and decompress the result again
+		files := &recorder{}
+		d := &Decompressor{}
+		d.SetNext(files)
+		c := &Compressor{Level: tt.level}
+		c.SetNext(d)
+		err := c.Process(ctx, "orders.csv", strings.NewReader(input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("level %d: error %v, want error %v", tt.level, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (files.filename != "orders.csv" || files.data != input) {
+			t.Errorf("level %d: got %s with %d bytes, want orders.csv with the %d bytes that were compressed", tt.level, files.filename, len(files.data), len(input))
+		}
+	}
+}
+
+func TestBzip2Tool(t *testing.T) {
+	if _, err := exec.LookPath("bzip2"); err != nil {
+		t.Skip("bzip2 is not installed")
+	}
+
+	// More than one block at level 1, with data that does not compress too well
+	random := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	for b.Len() < 300_000 {
+		fmt.Fprintf(&b, "%d;%d;%d.%02d\n", random.Intn(100000), random.Intn(1000), random.Intn(1000), random.Intn(100))
+	}
+	input := b.String()
+
+	compressed := &recorder{}
+	c := &Compressor{Level: 1}
+	c.SetNext(compressed)
+	if err := c.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	cmd := exec.Command("bzip2", "--decompress", "--stdout")
+	cmd.Stdin = strings.NewReader(compressed.data)
+	out, err := cmd.Output()
+	if err != nil || string(out) != input {
+		t.Errorf("bzip2 returned %d bytes and error %v, want the %d bytes that were compressed", len(out), err, len(input))
+	}
+}
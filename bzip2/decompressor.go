@@ -0,0 +1,47 @@
+package bzip2
+
+import (
+	"compress/bzip2"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/gwijnja/harvester"
+)
+
+// Decompressor decompresses a bzip2 file and presents it to the next processor in the chain.
+// The uncompressed data is streamed to the next processor while it is being decompressed, so it is never held in memory.
+type Decompressor struct {
+	harvester.NextProcessor
+	Logger *slog.Logger // nil for the logger of the job
+}
+
+// Process reads a bzip2 file and writes the uncompressed contents to the next processor
+func (d *Decompressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Remove the .bz2 suffix from the filename
+	newname := filename
+	if strings.HasSuffix(filename, ".bz2") {
+		newname = strings.TrimSuffix(filename, ".bz2")
+		logger.Info("bzip2: Removed .bz2 suffix", slog.String("newname", newname))
+	}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Decompress the input into the pipe, the bzip2 reader verifies the checksum of every block
+			_, err := harvester.AuditCopy(ctx, "bzip2.Decompressor", w, bzip2.NewReader(r))
+			if err != nil {
+				return fmt.Errorf("bzip2: Failed to decompress %s: %w", filename, err)
+			}
+			logger.Info("bzip2: Decompressed file", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("bzip2: Calling the next processor")
+			return d.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
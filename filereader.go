@@ -1,7 +1,9 @@
 package harvester
 
+import "context"
+
 type FileReader interface {
 	SetNext(FileWriter)
-	List() ([]string, error)
-	Process(filename string) error
+	List(ctx context.Context) ([]string, error)
+	Process(ctx context.Context, filename string) error
 }
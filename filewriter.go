@@ -1,8 +1,18 @@
 package harvester
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type FileWriter interface {
 	SetNext(next FileWriter)
-	Process(filename string, r io.Reader) error
+	Process(ctx context.Context, filename string, r io.Reader) error
+}
+
+// Flusher is implemented by processors that hold on to data across files, like a zip compressor that bundles files.
+// The job calls Flush on every processor that implements it after all files of a run were processed,
+// also when the run was cancelled or failed, so the processor can deliver or save what it is holding.
+type Flusher interface {
+	Flush(ctx context.Context) error
 }
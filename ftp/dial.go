@@ -0,0 +1,192 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// PassiveAddress selects the address that passive data connections are opened to.
+type PassiveAddress int
+
+const (
+	// PassiveAddressAuto uses the address in the reply of the server, unless it is a private address while the server
+	// itself was reached on a public address. That is the usual sign of a server behind NAT, which does not know its
+	// public address. The address of the server is used instead.
+	PassiveAddressAuto PassiveAddress = iota
+
+	// PassiveAddressServer always ignores the address in the reply, and uses the address of the server.
+	PassiveAddressServer
+
+	// PassiveAddressReply always uses the address in the reply, even if it cannot be reached.
+	PassiveAddressReply
+)
+
+// dialer opens the control connection and the data connections of a single FTP connection,
+// so the connector decides about timeouts, TLS and the address of passive data connections.
+type dialer struct {
+	ctx       context.Context
+	connector *Connector
+	tlsConfig *tls.Config // nil without TLS
+	logger    *slog.Logger
+	server    net.IP // Address of the control connection, nil until it is opened
+
+	mu      sync.Mutex
+	control net.Conn // The control connection, nil until it is opened
+	data    net.Conn // The last data connection, nil until one is opened
+}
+
+// dialOptions returns the options for ftp.Dial, and the dialer that opens the connections
+func (c *Connector) dialOptions(ctx context.Context, logger *slog.Logger) ([]ftp.DialOption, *dialer, error) {
+	d := &dialer{ctx: ctx, connector: c, logger: logger}
+	options := []ftp.DialOption{
+		ftp.DialWithDialFunc(d.dial),
+		ftp.DialWithDisabledEPSV(c.DisableEPSV),
+		ftp.DialWithDisabledMLSD(c.DisableMLSD),
+	}
+
+	// With a dial function, the client only upgrades the control connection after AUTH TLS, and leaves
+	// implicit TLS and the data connections to the dial function
+	if c.TLS != NoTLS {
+		config, err := c.tlsConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		d.tlsConfig = config
+		switch c.TLS {
+		case ExplicitTLS:
+			options = append(options, ftp.DialWithExplicitTLS(config))
+		case ImplicitTLS:
+			options = append(options, ftp.DialWithTLS(config))
+		default:
+			return nil, nil, fmt.Errorf("ftp: Invalid TLS mode %s", c.TLS)
+		}
+	}
+
+	return options, d, nil
+}
+
+// dial opens the control connection the first time it is called, and a data connection every next time
+func (d *dialer) dial(network string, address string) (net.Conn, error) {
+	timeout := d.connector.DialTimeout
+	if timeout == 0 {
+		timeout = ftp.DefaultDialTimeout
+	}
+	netDialer := net.Dialer{Timeout: timeout}
+
+	// The control connection
+	if d.server == nil {
+		conn, err := netDialer.DialContext(d.ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		d.server = conn.RemoteAddr().(*net.TCPAddr).IP
+		d.track(&d.control, conn)
+		conn = d.withTimeout(conn)
+		if d.connector.TLS == ImplicitTLS {
+			conn = tls.Client(conn, d.tlsConfig)
+		}
+		return conn, nil
+	}
+
+	// A data connection, which is protected with TLS whenever the control connection is
+	address = d.passiveAddress(address)
+	conn, err := netDialer.DialContext(d.ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("ftp: Failed to open data connection to %s: %w", address, err)
+	}
+	d.track(&d.data, conn)
+	conn = d.withTimeout(conn)
+	if d.tlsConfig != nil {
+		// The handshake starts with the first read or write, after the server has accepted the command
+		conn = tls.Client(conn, d.tlsConfig)
+	}
+	return conn, nil
+}
+
+// track remembers a connection that was opened, so closeAll can close it
+func (d *dialer) track(field *net.Conn, conn net.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*field = conn
+}
+
+// closeAll closes the control connection and the last data connection, so a read or write that waits for a stalled
+// server returns. The connection cannot be used anymore afterwards.
+func (d *dialer) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range []net.Conn{d.data, d.control} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// passiveAddress returns the address to open a passive data connection to, which is the address that the server
+// replied with, or the address of the server for servers behind NAT
+func (d *dialer) passiveAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.Equal(d.server) {
+		return address
+	}
+
+	switch d.connector.PassiveAddress {
+	case PassiveAddressReply:
+		return address
+	case PassiveAddressAuto:
+		if !ip.IsUnspecified() && !(isInternal(ip) && !isInternal(d.server)) {
+			return address
+		}
+	}
+
+	replaced := net.JoinHostPort(d.server.String(), port)
+	d.logger.Debug("ftp: Replaced the address in the passive reply", slog.String("reply", address), slog.String("address", replaced))
+	return replaced
+}
+
+// isInternal reports whether the address can only be reached from within a private network
+func isInternal(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// withTimeout returns the connection with the Timeout of the connector, if there is one
+func (d *dialer) withTimeout(conn net.Conn) net.Conn {
+	if d.connector.Timeout <= 0 {
+		return conn
+	}
+	return &timeoutConn{Conn: conn, timeout: d.connector.Timeout}
+}
+
+// timeoutConn fails a read or write that does not make progress within the timeout.
+// The deadline is set for every read and write, so an idle connection does not time out.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read reads from the connection, or fails after the timeout
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// Write writes to the connection, or fails after the timeout
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
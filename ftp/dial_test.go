@@ -0,0 +1,68 @@
+package ftp
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPassiveAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    PassiveAddress
+		server  string
+		address string
+		want    string
+	}{
+		{"auto, same address", PassiveAddressAuto, "203.0.113.10", "203.0.113.10:50000", "203.0.113.10:50000"},
+		{"auto, other public address", PassiveAddressAuto, "203.0.113.10", "198.51.100.20:50000", "198.51.100.20:50000"},
+		{"auto, private behind public", PassiveAddressAuto, "203.0.113.10", "10.0.0.5:50000", "203.0.113.10:50000"},
+		{"auto, loopback behind public", PassiveAddressAuto, "203.0.113.10", "127.0.0.1:50000", "203.0.113.10:50000"},
+		{"auto, unspecified", PassiveAddressAuto, "10.0.0.1", "0.0.0.0:50000", "10.0.0.1:50000"},
+		{"auto, private behind private", PassiveAddressAuto, "10.0.0.1", "10.0.0.5:50000", "10.0.0.5:50000"},
+		{"auto, ipv6", PassiveAddressAuto, "2001:db8::1", "[2001:db8::2]:50000", "[2001:db8::2]:50000"},
+		{"server", PassiveAddressServer, "10.0.0.1", "10.0.0.5:50000", "10.0.0.1:50000"},
+		{"server, ipv6", PassiveAddressServer, "2001:db8::1", "[2001:db8::2]:50000", "[2001:db8::1]:50000"},
+		{"reply, private behind public", PassiveAddressReply, "203.0.113.10", "10.0.0.5:50000", "10.0.0.5:50000"},
+		{"host name", PassiveAddressServer, "10.0.0.1", "ftp.example.com:50000", "ftp.example.com:50000"},
+		{"no port", PassiveAddressServer, "10.0.0.1", "10.0.0.5", "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dialer{connector: &Connector{PassiveAddress: tt.mode}, server: net.ParseIP(tt.server), logger: slog.Default()}
+			if got := d.passiveAddress(tt.address); got != tt.want {
+				t.Errorf("passiveAddress(%s) = %s, want %s", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	d := &dialer{connector: &Connector{Timeout: 50 * time.Millisecond}}
+	conn := d.withTimeout(client)
+	defer conn.Close()
+
+	// An idle connection does not time out, only a read or write that does not make progress
+	time.Sleep(100 * time.Millisecond)
+	go server.Write([]byte("220 Ready\r\n"))
+	b := make([]byte, 64)
+	if n, err := conn.Read(b); err != nil || string(b[:n]) != "220 Ready\r\n" {
+		t.Fatalf("Read returned %q, %v", b[:n], err)
+	}
+	if _, err := conn.Read(b); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read without data returned %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if _, err := conn.Write([]byte("NOOP\r\n")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write without a reader returned %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// Without a Timeout, the connection is used as it is
+	if conn := (&dialer{connector: &Connector{}}).withTimeout(client); conn != client {
+		t.Errorf("withTimeout wrapped the connection without a Timeout")
+	}
+}
@@ -1,6 +1,8 @@
 package ftp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log/slog"
 	"path/filepath"
@@ -15,9 +17,14 @@ type Downloader struct {
 	ToLoad              string
 	Loaded              string
 	DeleteAfterDownload bool
+	FollowSymlinks      bool // Process symlinks to files, instead of skipping all symlinks
 	Regex               string
-	MaxFiles            int // set to 0 for no limit
-	next                harvester.FileWriter
+	MaxFiles            int               // set to 0 for no limit
+	Sidecar             harvester.Sidecar // If set, a file is only processed with its sidecar, and must match it
+	TempDir             string            // Directory for staging files with a Sidecar larger than MaxMemory, empty for the default directory of the OS
+	MaxMemory           int64             // Files with a Sidecar up to this size are staged in memory, 0 for harvester.DefaultMaxMemory, -1 to always use a temporary file
+	harvester.Quarantine
+	next harvester.FileWriter
 }
 
 // SetNext sets the next FileWriter in the chain
@@ -26,16 +33,16 @@ func (d *Downloader) SetNext(next harvester.FileWriter) {
 }
 
 // List lists files in the ToLoad directory
-func (d *Downloader) List() ([]string, error) {
+func (d *Downloader) List(ctx context.Context) (filenames []string, err error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
 
-	// Connect
-	conn, err := d.connect()
+	// Connect, or reuse a connection of the job run
+	conn, err := d.open(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
-		conn.Quit()
-		slog.Info("ftp: Closed connection")
+		d.release(ctx, conn, err)
 	}()
 
 	// List files
@@ -43,28 +50,49 @@ func (d *Downloader) List() ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ftp: Failed to list files in %s: %s", d.ToLoad, err)
 	}
-	slog.Debug("ftp: Listed files", slog.Int("count", len(entries)))
+	logger.Debug("ftp: Listed files", slog.Int("count", len(entries)))
 
 	// Filter files
-	filtered, err := d.filterEntries(entries)
+	filtered, err := d.filterEntries(ctx, conn, entries)
 	if err != nil {
 		return nil, err
 	}
 
-	return harvester.SortAndLimit(filtered, d.MaxFiles), nil
+	// Only process files that have a sidecar, and skip the sidecars themselves
+	if d.Sidecar != nil {
+		all := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			all = append(all, entry.Name)
+		}
+		filtered = harvester.FilterSidecars(ctx, d.Sidecar, filtered, all)
+	}
+
+	return harvester.SortAndLimit(ctx, filtered, d.MaxFiles), nil
 }
 
 // Process downloads a file from the FTP server and processes it
-func (d *Downloader) Process(filename string) error {
+func (d *Downloader) Process(ctx context.Context, filename string) (err error) {
+
+	// Start the transfer, so all log lines can be correlated, and record the result in the audit trail
+	toLoadPath := filepath.Join(d.ToLoad, filename)
+	ctx = harvester.StartTransfer(ctx, d.system(), toLoadPath)
+	logger := harvester.ContextLogger(ctx, d.Logger)
+	defer func() {
+		if err != nil {
+			d.Failed(ctx, err, d.quarantine)
+		} else {
+			d.Succeeded(ctx)
+		}
+		harvester.FinishTransfer(ctx, err)
+	}()
 
-	// Connect
-	conn, err := d.connect()
+	// Connect, or reuse a connection of the job run
+	conn, err := d.open(ctx)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		conn.Quit()
-		slog.Info("ftp: Closed connection")
+		d.release(ctx, conn, err)
 	}()
 
 	// Set the transfer type to binary
@@ -72,51 +100,156 @@ func (d *Downloader) Process(filename string) error {
 	if err != nil {
 		return fmt.Errorf("ftp: Failed to set transfer type to binary: %s", err)
 	}
-	slog.Debug("ftp: Set transfer type to binary")
+	logger.Debug("ftp: Set transfer type to binary")
+
+	// Get the modification time, if the server supports it
+	if conn.IsGetTimeSupported() {
+		if modTime, err := conn.GetTime(toLoadPath); err == nil {
+			harvester.SetSourceModTime(ctx, modTime)
+		}
+	}
+
+	// Interrupt a read that waits for a stalled server by closing the connection when the job is cancelled
+	stop := conn.closeOnCancel(ctx)
+	defer func() {
+		if cancelErr := stop(); cancelErr != nil {
+			err = fmt.Errorf("ftp: Interrupted download of %s: %w", toLoadPath, cancelErr)
+		}
+	}()
+
+	// Read the sidecar first, because there can only be one data connection at a time
+	var sidecar []byte
+	if d.Sidecar != nil {
+		sidecar, err = d.readSidecar(ctx, conn, filename)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Retrieve the file
-	toLoadPath := filepath.Join(d.ToLoad, filename)
 	r, err := conn.Retr(toLoadPath)
 	if err != nil {
 		return fmt.Errorf("ftp: Failed to retrieve file %s: %s", toLoadPath, err)
 	}
 	defer func() {
 		r.Close() // just in case we exit early for some reason
-		slog.Info("ftp: Closed data connection")
+		logger.Info("ftp: Closed data connection")
 	}()
-	slog.Info("ftp: Retrieved file", slog.String("path", toLoadPath))
+	logger.Info("ftp: Retrieved file", slog.String("path", toLoadPath))
 
-	// Call the next processor
-	err = d.next.Process(filename, r)
+	// Call the next processor, after the file is staged and verified against its sidecar
+	if d.Sidecar == nil {
+		err = d.next.Process(ctx, filename, r)
+	} else {
+		staged := &harvester.SpillBuffer{TempDir: d.TempDir, MaxMemory: d.MaxMemory, Logger: logger}
+		defer staged.Close()
+		err = harvester.VerifySidecar(ctx, d.Sidecar, filename, toLoadPath+d.Sidecar.Suffix(), sidecar, r, staged)
+		if err == nil {
+			err = d.next.Process(ctx, filename, staged.Reader())
+		}
+	}
 	if err != nil {
 		return err
 	}
 	r.Close() // close implicitly, because we're going to delete or move the file
-	slog.Info("ftp: Closed data connection")
+	logger.Info("ftp: Closed data connection")
+
+	// The data is transferred, so moving or deleting the file is not interrupted anymore
+	if err := stop(); err != nil {
+		return err
+	}
+
+	// Move the file from ToLoad to Loaded, or delete it, with its sidecar
+	if err := d.done(ctx, &conn, filename); err != nil {
+		return err
+	}
+	if d.Sidecar != nil {
+		return d.done(ctx, &conn, filename+d.Sidecar.Suffix())
+	}
+	return nil
+}
 
-	// Move the file from ToLoad to Loaded, or delete it
+// readSidecar retrieves the sidecar of the file
+func (d *Downloader) readSidecar(ctx context.Context, conn *connection, filename string) ([]byte, error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	sidecarPath := filepath.Join(d.ToLoad, filename+d.Sidecar.Suffix())
+	r, err := conn.Retr(sidecarPath)
+	if err != nil {
+		return nil, fmt.Errorf("ftp: Failed to retrieve sidecar %s: %s", sidecarPath, err)
+	}
+	content, err := harvester.ReadSidecar(r)
+	if closeErr := r.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("ftp: Failed to retrieve sidecar %s: %s", sidecarPath, closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("ftp: Retrieved sidecar", slog.String("path", sidecarPath), slog.Int("bytes", len(content)))
+	return content, nil
+}
+
+// done deletes a file from ToLoad, or renames it to Loaded
+func (d *Downloader) done(ctx context.Context, conn **connection, filename string) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	toLoadPath := filepath.Join(d.ToLoad, filename)
 	if d.DeleteAfterDownload {
-		err = conn.Delete(toLoadPath)
-		if err != nil {
+		if err := d.delete(ctx, conn, toLoadPath); err != nil {
 			return fmt.Errorf("ftp: Failed to delete file %s: %s", toLoadPath, err)
 		}
-		slog.Info("ftp: Deleted file", slog.String("path", toLoadPath))
+		logger.Info("ftp: Deleted file", slog.String("path", toLoadPath))
 		return nil
 	}
 
 	// Move the file from toLoad to loaded
 	loadedPath := filepath.Join(d.Loaded, filename)
-	err = conn.Rename(toLoadPath, loadedPath)
-	if err != nil {
+	if err := d.rename(ctx, conn, toLoadPath, loadedPath); err != nil {
 		return fmt.Errorf("ftp: Failed to rename file %s to %s: %s", toLoadPath, loadedPath, err)
 	}
-	slog.Info("ftp: Renamed file", slog.String("from", toLoadPath), slog.String("to", loadedPath))
+	logger.Info("ftp: Renamed file", slog.String("from", toLoadPath), slog.String("to", loadedPath))
+	return nil
+}
+
+// quarantine moves a file that keeps failing to the Error directory, and stores the sidecar next to it.
+// The connection of the failed transfer may be broken and has been closed, so it uses another connection.
+func (d *Downloader) quarantine(ctx context.Context, errorPath string, sidecarPath string, sidecar []byte) (err error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
 
+	conn, err := d.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		d.release(ctx, conn, err)
+	}()
+
+	from := filepath.Join(d.ToLoad, filepath.Base(errorPath))
+	if err := d.rename(ctx, &conn, from, errorPath); err != nil {
+		return fmt.Errorf("ftp: Failed to rename file %s to %s: %s", from, errorPath, err)
+	}
+	logger.Info("ftp: Renamed file", slog.String("from", from), slog.String("to", errorPath))
+
+	// The sidecar of the sender goes along, so the file can be put back with it
+	if d.Sidecar != nil {
+		from, to := from+d.Sidecar.Suffix(), errorPath+d.Sidecar.Suffix()
+		if err := d.rename(ctx, &conn, from, to); err != nil {
+			return fmt.Errorf("ftp: Failed to rename sidecar %s to %s: %s", from, to, err)
+		}
+		logger.Info("ftp: Renamed sidecar", slog.String("from", from), slog.String("to", to))
+	}
+
+	if err := conn.Stor(sidecarPath, bytes.NewReader(sidecar)); err != nil {
+		return fmt.Errorf("ftp: Failed to store sidecar %s: %s", sidecarPath, err)
+	}
+	logger.Info("ftp: Stored sidecar", slog.String("path", sidecarPath))
 	return nil
 }
 
-// filterEntries filters the entries based on the regex and maxFiles
-func (d *Downloader) filterEntries(entries []*ftp.Entry) ([]string, error) {
+// filterEntries returns the names of the files that match the regex. Directories, entries of an unknown type,
+// and symlinks are skipped, unless FollowSymlinks is set and the symlink points to a file.
+func (d *Downloader) filterEntries(ctx context.Context, conn *connection, entries []*ftp.Entry) ([]string, error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
 
 	filenames := []string{}
 
@@ -129,20 +262,39 @@ func (d *Downloader) filterEntries(entries []*ftp.Entry) ([]string, error) {
 	// Loop over the entries and filter them
 	for _, entry := range entries {
 
-		// Skip non-file entries
-		if entry.Type != ftp.EntryTypeFile {
-			slog.Warn("ftp: Skipping non-file entry", slog.String("filename", entry.Name))
+		// Skip entries that are not files
+		switch entry.Type {
+		case ftp.EntryTypeFile:
+		case ftp.EntryTypeFolder:
+			logger.Debug("ftp: Skipping directory", slog.String("filename", entry.Name))
+			continue
+		case ftp.EntryTypeLink:
+			if !d.FollowSymlinks {
+				logger.Debug("ftp: Skipping symlink", slog.String("filename", entry.Name), slog.String("target", entry.Target))
+				continue
+			}
+		default:
+			logger.Warn("ftp: Skipping entry of unknown type", slog.String("filename", entry.Name), slog.String("type", entry.Type.String()))
 			continue
 		}
 
 		// Skip files that don't match the regex
 		if !re.MatchString(entry.Name) {
-			slog.Warn("ftp: Skipping non-matching file", slog.String("filename", entry.Name))
+			logger.Warn("ftp: Skipping non-matching file", slog.String("filename", entry.Name))
 			continue
 		}
 
+		// Only follow symlinks to files, which have a size
+		if entry.Type == ftp.EntryTypeLink {
+			if _, err := conn.FileSize(filepath.Join(d.ToLoad, entry.Name)); err != nil {
+				logger.Warn("ftp: Skipping symlink that does not point to a file", slog.String("filename", entry.Name), slog.String("target", entry.Target))
+				continue
+			}
+			logger.Debug("ftp: Following symlink", slog.String("filename", entry.Name), slog.String("target", entry.Target))
+		}
+
 		// Add the file to the list
-		slog.Info("ftp: Found file", slog.String("filename", entry.Name))
+		logger.Info("ftp: Found file", slog.String("filename", entry.Name))
 		filenames = append(filenames, entry.Name)
 	}
 
@@ -1,35 +1,242 @@
 package ftp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/textproto"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gwijnja/harvester"
 	"github.com/jlaffaye/ftp"
 )
 
 type Connector struct {
-	Host     string
-	Port     int
-	Username string
-	Password string
+	Host           string
+	Port           int
+	Username       string
+	Password       string
+	TLS            TLSMode                // NoTLS, ExplicitTLS or ImplicitTLS, with TLS the login fails unless the server accepts PROT P for the data connections
+	CAFile         string                 // PEM file with the CAs that sign the certificate of the server, empty for the CAs of the system
+	CertFile       string                 // PEM file with a client certificate, empty if the server does not require one
+	KeyFile        string                 // PEM file with the unencrypted private key of the client certificate
+	ServerName     string                 // Name in the certificate of the server, empty for Host
+	MinTLSVersion  uint16                 // Example: tls.VersionTLS13, 0 for tls.VersionTLS12
+	DialTimeout    time.Duration          // Maximum time to open a connection, 0 for 30 seconds
+	Timeout        time.Duration          // Maximum time to wait for the server to send or accept data, 0 for no limit
+	DisableEPSV    bool                   // Use PASV instead of EPSV, for servers or firewalls that do not support EPSV
+	DisableMLSD    bool                   // List directories with LIST instead of MLSD, for servers with a broken MLSD
+	PassiveAddress PassiveAddress         // Address of passive data connections, PassiveAddressAuto to detect servers behind NAT
+	Retry          *harvester.RetryPolicy // nil for the retry policy of the job
+	Logger         *slog.Logger           // nil for the logger of the job
 }
 
-// connect connects to the FTP server
-func (c *Connector) connect() (*ftp.ServerConn, error) {
+// system describes the FTP server, for identifying the source of a transfer
+func (c *Connector) system() string {
+	if c.TLS != NoTLS {
+		return fmt.Sprintf("ftps://%s@%s:%d", c.Username, c.Host, c.Port)
+	}
+	return fmt.Sprintf("ftp://%s@%s:%d", c.Username, c.Host, c.Port)
+}
+
+// connection is a connection to the FTP server that the transfers of a job run share
+type connection struct {
+	*ftp.ServerConn
+	dialer *dialer
+	logger *slog.Logger
+}
+
+// Check sends a NOOP, to find out if the server closed the connection while it was idle
+func (c *connection) Check() error {
+	return c.NoOp()
+}
+
+// Close logs out and closes the connection
+func (c *connection) Close() error {
+	err := c.Quit()
+	c.logger.Info("ftp: Closed connection")
+	return err
+}
+
+// makeDirs creates a directory and its parents. FTP servers reply 550 when a directory already exists, which is
+// the same reply as for a directory that cannot be created, so that failure shows when the file is stored.
+func (c *connection) makeDirs(dir string) error {
+	var parent string
+	for i, name := range strings.Split(filepath.ToSlash(dir), "/") {
+		if i == 0 && name == "" {
+			parent = "/"
+			continue
+		}
+		parent = path.Join(parent, name)
+		var protoErr *textproto.Error
+		if err := c.MakeDir(parent); err != nil && !(errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable) {
+			return err
+		}
+	}
+	return nil
+}
+
+// bind lets the connection log with the logger of the transfer that uses it, and open data connections with the
+// context of that transfer, which is not the transfer that connected once the connection is reused
+func (c *connection) bind(ctx context.Context, logger *slog.Logger) {
+	c.logger = logger
+	c.dialer.ctx = ctx
+	c.dialer.logger = logger
+}
+
+// closeOnCancel closes the connection when the context is cancelled, so a read or write that waits for a stalled
+// server returns. Closing the data connection is not enough, because the client then waits for the reply of the
+// server on the control connection. Call the returned function when the data is transferred, it returns the context's
+// error if the connection was closed.
+func (c *connection) closeOnCancel(ctx context.Context) func() error {
+	stop := context.AfterFunc(ctx, func() {
+		c.logger.Warn("ftp: Closing the connection to interrupt the transfer")
+		c.dialer.closeAll()
+	})
+	return sync.OnceValue(func() error {
+		if !stop() {
+			return ctx.Err()
+		}
+		return nil
+	})
+}
+
+// open returns an idle connection of the job run, or connects to the FTP server. Return it with release.
+func (c *Connector) open(ctx context.Context) (*connection, error) {
+	s, err := harvester.OpenSession(ctx, c, func() (harvester.Session, error) {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := s.(*connection)
+	conn.bind(ctx, harvester.ContextLogger(ctx, c.Logger))
+	return conn, nil
+}
+
+// release returns the connection to the job run, so the next transfer can use it. If err is not nil,
+// the connection may be in an unknown state, so it is closed instead.
+func (c *Connector) release(ctx context.Context, conn *connection, err error) {
+	harvester.ReleaseSession(ctx, c, conn, err == nil)
+}
+
+// connect connects to the FTP server, retrying transient failures according to the retry policy
+func (c *Connector) connect(ctx context.Context) (*connection, error) {
+	var conn *connection
+	err := harvester.Retry(ctx, c.Retry, "ftp: connect", isRetryable, func(int) error {
+		var err error
+		conn, err = c.connectOnce(ctx)
+		return err
+	})
+	return conn, err
+}
+
+// connectOnce connects to the FTP server
+func (c *Connector) connectOnce(ctx context.Context) (*connection, error) {
+	logger := harvester.ContextLogger(ctx, c.Logger)
+
+	// Prepare the options
+	options, dialer, err := c.dialOptions(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
 
 	// Dial
-	conn, err := ftp.Dial(fmt.Sprintf("%s:%d", c.Host, c.Port))
+	conn, err := ftp.Dial(fmt.Sprintf("%s:%d", c.Host, c.Port), options...)
 	if err != nil {
-		return nil, fmt.Errorf("ftp: Failed to dial %s:%d: %s", c.Host, c.Port, err)
+		return nil, fmt.Errorf("ftp: Failed to dial %s:%d: %w", c.Host, c.Port, err)
 	}
-	slog.Info("ftp: Connected", slog.String("host", c.Host), slog.Int("port", c.Port))
+	logger.Info("ftp: Connected", slog.String("host", c.Host), slog.Int("port", c.Port), slog.String("tls", c.TLS.String()))
 
 	// Login
 	err = conn.Login(c.Username, c.Password)
 	if err != nil {
-		return nil, fmt.Errorf("ftp: Failed to login as %s: %s", c.Username, err)
+		conn.Quit()
+		return nil, fmt.Errorf("ftp: Failed to login as %s: %w", c.Username, err)
 	}
-	slog.Info("ftp: Logged in", slog.String("username", c.Username))
+	logger.Info("ftp: Logged in", slog.String("username", c.Username))
 
-	return conn, nil
+	return &connection{ServerConn: conn, dialer: dialer, logger: logger}, nil
+}
+
+// rename moves a file on the server, retrying transient failures according to the retry policy.
+// Before every retry it reconnects, and checks whether the previous attempt succeeded after all,
+// so a file is never moved twice. The previous attempt succeeded if the file is gone, even if it is no longer
+// at the destination either, because the receiver may already have picked it up.
+// The connection is replaced when it reconnects.
+func (c *Connector) rename(ctx context.Context, conn **connection, from string, to string) error {
+	return harvester.Retry(ctx, c.Retry, "ftp: rename", isRetryable, func(attempt int) error {
+		if attempt > 1 {
+			if err := c.reconnect(ctx, conn); err != nil {
+				return err
+			}
+			missing, err := c.missing(*conn, from)
+			if err != nil {
+				return fmt.Errorf("ftp: Failed to get size of file %s: %w", from, err)
+			}
+			if missing {
+				harvester.ContextLogger(ctx, c.Logger).Info("ftp: Previous rename succeeded", slog.String("from", from), slog.String("to", to))
+				return nil
+			}
+		}
+		return (*conn).Rename(from, to)
+	})
+}
+
+// delete removes a file from the server, retrying transient failures according to the retry policy.
+// Before every retry it reconnects, and checks whether the file still exists.
+func (c *Connector) delete(ctx context.Context, conn **connection, path string) error {
+	return harvester.Retry(ctx, c.Retry, "ftp: delete", isRetryable, func(attempt int) error {
+		if attempt > 1 {
+			if err := c.reconnect(ctx, conn); err != nil {
+				return err
+			}
+			missing, err := c.missing(*conn, path)
+			if err != nil {
+				return fmt.Errorf("ftp: Failed to get size of file %s: %w", path, err)
+			}
+			if missing {
+				return nil
+			}
+		}
+		return (*conn).Delete(path)
+	})
+}
+
+// reconnect replaces the connection with a new one
+func (c *Connector) reconnect(ctx context.Context, conn **connection) error {
+	(*conn).Quit()
+	newConn, err := c.connectOnce(ctx)
+	if err != nil {
+		return err
+	}
+	*conn = newConn
+	return nil
+}
+
+// missing reports whether the file does not exist on the server, which the server tells with reply 550.
+// Other errors are returned, so a failure to look, or a server without the SIZE command, is not mistaken for
+// a missing file.
+func (c *Connector) missing(conn *connection, path string) (bool, error) {
+	_, err := conn.FileSize(path)
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable {
+		return true, nil
+	}
+	return false, err
+}
+
+// isRetryable reports whether the FTP error is transient. Replies in the 4xx range are transient by definition.
+func isRetryable(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 400 && protoErr.Code < 500
 }
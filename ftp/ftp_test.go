@@ -0,0 +1,130 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"service not available", &textproto.Error{Code: 421, Msg: "Service not available"}, true},
+		{"file busy", &textproto.Error{Code: 450, Msg: "File unavailable"}, true},
+		{"wrapped", fmt.Errorf("ftp: Failed to rename file: %w", &textproto.Error{Code: 451, Msg: "Local error"}), true},
+		{"file unavailable", &textproto.Error{Code: 550, Msg: "No such file"}, false},
+		{"not logged in", &textproto.Error{Code: 530, Msg: "Login incorrect"}, false},
+		{"not implemented", &textproto.Error{Code: 502, Msg: "Command not implemented"}, false},
+		{"other", errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindUsesContextOfTransfer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	address := listener.Addr().String()
+
+	// The transfer that opened the connection has finished, and its context is cancelled
+	first, finish := context.WithCancel(context.Background())
+	conn := &connection{dialer: &dialer{ctx: first, connector: &Connector{}, server: net.ParseIP("127.0.0.1")}}
+	finish()
+	if _, err := conn.dialer.dial("tcp", address); !errors.Is(err, context.Canceled) {
+		t.Fatalf("data connection with the finished context returned %v, want %v", err, context.Canceled)
+	}
+
+	// The next transfer that uses the connection opens data connections with its own context
+	conn.bind(context.Background(), slog.Default())
+	dataConn, err := conn.dialer.dial("tcp", address)
+	if err != nil {
+		t.Fatalf("data connection of the next transfer: %s", err)
+	}
+	dataConn.Close()
+}
+
+func TestCloseOnCancelInterruptsStalledServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	address := listener.Addr().String()
+
+	// The server accepts the connections, but never sends anything
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	conn := &connection{dialer: &dialer{ctx: context.Background(), connector: &Connector{}}, logger: slog.Default()}
+	control, err := conn.dialer.dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer control.Close()
+	data, err := conn.dialer.dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.Close()
+
+	// Cancel the job while the transfer waits for data
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := conn.closeOnCancel(ctx)
+	read := make(chan error, 1)
+	go func() {
+		_, err := data.Read(make([]byte, 1))
+		read <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Fatal("read from the stalled server succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read from the stalled server was not interrupted")
+	}
+	if _, err := control.Read(make([]byte, 1)); err == nil {
+		t.Error("control connection is still open")
+	}
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("stop returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCloseOnCancelAfterTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &connection{dialer: &dialer{ctx: ctx, connector: &Connector{}}, logger: slog.Default()}
+	stop := conn.closeOnCancel(ctx)
+	if err := stop(); err != nil {
+		t.Errorf("stop returned %v, want nil", err)
+	}
+	cancel()
+	if err := stop(); err != nil {
+		t.Errorf("stop after cancelling returned %v, want nil", err)
+	}
+}
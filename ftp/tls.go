@@ -0,0 +1,76 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSMode selects whether and how the connection to the FTP server is protected with TLS (FTPS).
+type TLSMode int
+
+const (
+	// NoTLS connects in plaintext. Only use it within a trusted network.
+	NoTLS TLSMode = iota
+
+	// ExplicitTLS connects in plaintext, usually on port 21, and upgrades the connection with AUTH TLS before logging in.
+	ExplicitTLS
+
+	// ImplicitTLS starts TLS as soon as it connects, usually on port 990.
+	ImplicitTLS
+)
+
+// String returns the name of the mode, for logging
+func (m TLSMode) String() string {
+	switch m {
+	case NoTLS:
+		return "none"
+	case ExplicitTLS:
+		return "explicit"
+	case ImplicitTLS:
+		return "implicit"
+	}
+	return fmt.Sprintf("TLSMode(%d)", int(m))
+}
+
+// tlsConfig returns the TLS configuration for the control and data connections
+func (c *Connector) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: c.MinTLSVersion,
+
+		// Many servers require the data connections to resume the TLS session of the control connection
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if config.ServerName == "" {
+		config.ServerName = c.Host
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	// Trust the CAs in the bundle instead of the CAs of the system
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ftp: Failed to read CA file %s: %s", c.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ftp: No certificates found in CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	// Authenticate with a client certificate, if the server asks for one
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ftp: Failed to load client certificate %s and key %s: %s", c.CertFile, c.KeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,114 @@
+package ftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key to the directory, and returns their paths
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "harvester"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		connector      Connector
+		wantServerName string
+		wantMinVersion uint16
+		wantRootCAs    bool
+		wantCerts      int
+		wantErr        bool
+	}{
+		{"defaults", Connector{Host: "ftp.example.com"}, "ftp.example.com", tls.VersionTLS12, false, 0, false},
+		{"server name", Connector{Host: "10.0.0.1", ServerName: "ftp.example.com"}, "ftp.example.com", tls.VersionTLS12, false, 0, false},
+		{"minimum version", Connector{Host: "ftp.example.com", MinTLSVersion: tls.VersionTLS13}, "ftp.example.com", tls.VersionTLS13, false, 0, false},
+		{"CA file", Connector{Host: "ftp.example.com", CAFile: certFile}, "ftp.example.com", tls.VersionTLS12, true, 0, false},
+		{"client certificate", Connector{Host: "ftp.example.com", CertFile: certFile, KeyFile: keyFile}, "ftp.example.com", tls.VersionTLS12, false, 1, false},
+		{"missing CA file", Connector{CAFile: filepath.Join(dir, "missing.pem")}, "", 0, false, 0, true},
+		{"CA file without certificates", Connector{CAFile: empty}, "", 0, false, 0, true},
+		{"certificate without key", Connector{CertFile: certFile}, "", 0, false, 0, true},
+		{"key without certificate", Connector{KeyFile: keyFile}, "", 0, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.connector.tlsConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tlsConfig returned %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.ServerName != tt.wantServerName || config.MinVersion != tt.wantMinVersion {
+				t.Errorf("config has server name %s and minimum version %x, want %s and %x", config.ServerName, config.MinVersion, tt.wantServerName, tt.wantMinVersion)
+			}
+			if (config.RootCAs != nil) != tt.wantRootCAs || len(config.Certificates) != tt.wantCerts {
+				t.Errorf("config has root CAs %v and %d certificates", config.RootCAs != nil, len(config.Certificates))
+			}
+			if config.ClientSessionCache == nil {
+				t.Errorf("config has no session cache, data connections cannot resume the TLS session")
+			}
+		})
+	}
+}
+
+func TestTLSModeString(t *testing.T) {
+	tests := []struct {
+		mode TLSMode
+		want string
+	}{
+		{NoTLS, "none"},
+		{ExplicitTLS, "explicit"},
+		{ImplicitTLS, "implicit"},
+		{TLSMode(7), "TLSMode(7)"},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.String(); got != tt.want {
+			t.Errorf("TLSMode(%d).String() = %s, want %s", int(tt.mode), got, tt.want)
+		}
+	}
+}
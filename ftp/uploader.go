@@ -1,6 +1,7 @@
 package ftp
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,25 +13,26 @@ import (
 
 type Uploader struct {
 	Connector
-	Transmit string
-	ToLoad   string
+	Transmit   string
+	ToLoad     string
+	VerifySize bool // Compare the size of the transmit file with the bytes sent, before moving it to ToLoad
+	VerifyHash bool // Read the transmit file back and compare its hashes with the data sent, before moving it to ToLoad
 }
 
 // SetNext is a no-op for the FileWriter
 func (u *Uploader) SetNext(next harvester.FileWriter) {}
 
 // Process writes the file to the FTP server and moves it to the ToLoad directory
-func (u *Uploader) Process(filename string, r io.Reader) error {
+func (u *Uploader) Process(ctx context.Context, filename string, r io.Reader) (err error) {
+	logger := harvester.ContextLogger(ctx, u.Logger)
 
-	// Connect
-	conn, err := u.connect()
+	// Connect, or reuse a connection of the job run
+	conn, err := u.open(ctx)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		// Close the connection
-		conn.Quit()
-		slog.Info("ftp: Closed connection")
+		u.release(ctx, conn, err)
 	}()
 
 	// Set the transfer type to binary
@@ -38,23 +40,100 @@ func (u *Uploader) Process(filename string, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("ftp: Failed to set transfer type to binary: %s", err)
 	}
-	slog.Debug("ftp: Set transfer type to binary")
+	logger.Debug("ftp: Set transfer type to binary")
 
-	// Store the file in the Transmit directory
+	// A file from an archive is named with the directories it is in, so create them in Transmit and ToLoad
+	if dir := filepath.Dir(filename); dir != "." {
+		for _, parent := range []string{filepath.Join(u.Transmit, dir), filepath.Join(u.ToLoad, dir)} {
+			if err := conn.makeDirs(parent); err != nil {
+				return fmt.Errorf("ftp: Failed to create directory %s: %s", parent, err)
+			}
+		}
+	}
+
+	// Interrupt a write or read that waits for a stalled server by closing the connection when the job is cancelled
 	transmitPath := filepath.Join(u.Transmit, filename)
-	err = conn.Stor(transmitPath, r)
+	stop := conn.closeOnCancel(ctx)
+	defer func() {
+		if cancelErr := stop(); cancelErr != nil {
+			err = fmt.Errorf("ftp: Interrupted upload of %s: %w", transmitPath, cancelErr)
+		}
+	}()
+
+	// Store the file in the Transmit directory, while AuditCopy feeds it through a pipe
+	var hop harvester.AuditHop
+	err = harvester.Stream(
+		func(w io.Writer) error {
+			var err error
+			hop, err = harvester.AuditCopyHop(ctx, "ftp.Uploader", w, r)
+			return err
+		},
+		func(r io.Reader) error {
+			return conn.Stor(transmitPath, r)
+		},
+	)
 	if err != nil {
-		return fmt.Errorf("ftp: Failed to store file %s: %s", transmitPath, err)
+		conn.Delete(transmitPath)
+		logger.Info("ftp: Removed file", slog.String("path", transmitPath))
+		return fmt.Errorf("ftp: Failed to store file %s: %w", transmitPath, err)
+	}
+	logger.Info("ftp: Stored file", slog.String("path", transmitPath))
+
+	// Verify the transmit file
+	err = u.verify(ctx, conn, transmitPath, hop)
+	if err != nil {
+		conn.Delete(transmitPath)
+		logger.Info("ftp: Removed file", slog.String("path", transmitPath))
+		return err
+	}
+
+	// The data is transferred, so the move is not interrupted anymore
+	if err := stop(); err != nil {
+		return err
 	}
-	slog.Info("ftp: Stored file", slog.String("path", transmitPath))
 
 	// Move the file from Transmit to ToLoad
 	toLoadPath := filepath.Join(u.ToLoad, filename)
-	err = conn.Rename(transmitPath, toLoadPath)
+	err = u.rename(ctx, &conn, transmitPath, toLoadPath)
 	if err != nil {
 		return fmt.Errorf("ftp: Failed to rename file %s to %s: %s", transmitPath, toLoadPath, err)
 	}
-	slog.Info("ftp: Renamed file", slog.String("from", transmitPath), slog.String("to", toLoadPath))
+	logger.Info("ftp: Renamed file", slog.String("from", transmitPath), slog.String("to", toLoadPath))
+	harvester.SetDestination(ctx, u.system(), toLoadPath)
+
+	return nil
+}
+
+// verify compares the size and hashes of the remote file with the data that was sent, if enabled.
+// The jlaffaye/ftp client does not support the XSHA256 and HASH commands, so hashes are verified by reading the file back.
+func (u *Uploader) verify(ctx context.Context, conn *connection, path string, sent harvester.AuditHop) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
+
+	// Compare the size
+	if u.VerifySize {
+		size, err := conn.FileSize(path)
+		if err != nil {
+			return fmt.Errorf("ftp: Failed to get size of file %s: %s", path, err)
+		}
+		if err := harvester.VerifySize(sent.Bytes, size); err != nil {
+			return fmt.Errorf("ftp: Size of file %s is wrong: %w", path, err)
+		}
+		logger.Info("ftp: Verified size", slog.String("path", path), slog.Int64("bytes", size))
+	}
+
+	// Compare the hashes
+	if u.VerifyHash {
+		r, err := conn.Retr(path)
+		if err != nil {
+			return fmt.Errorf("ftp: Failed to retrieve file %s for verification: %s", path, err)
+		}
+		err = harvester.VerifyHash(ctx, sent.Hashes, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("ftp: Failed to verify hash of file %s: %w", path, err)
+		}
+		logger.Info("ftp: Verified hashes", slog.String("path", path))
+	}
 
 	return nil
 }
@@ -1,8 +1,8 @@
 package gzip
 
 import (
-	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,36 +11,53 @@ import (
 )
 
 // Compressor compresses a file using gzip and presents it to the next processor in the chain.
+// The compressed data is streamed to the next processor while it is being compressed, so it is never held in memory.
 type Compressor struct {
 	harvester.NextProcessor
+	Level  int          // Compression level from 1 (fastest) to 9 (smallest), 0 for the default of gzip
+	Logger *slog.Logger // nil for the logger of the job
 }
 
 // Process reads a file and writes the compressed contents to the next processor
-func (c *Compressor) Process(filename string, r io.Reader) error {
+func (c *Compressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, c.Logger)
 
-	// Create a gzip writer
-	buf := new(bytes.Buffer)
-	gzipWriter := gzip.NewWriter(buf)
-
-	defer func() {
-		gzipWriter.Close()
-		slog.Info("gzip: Closed gzip writer", slog.String("filename", filename))
-	}()
-
-	// Copy the ctx.Reader to the gzip writer
-	written, err := harvester.AuditCopy(gzipWriter, r)
-	if err != nil {
-		return fmt.Errorf("gzip: Failed to copy input to gzip writer: %s", err)
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return fmt.Errorf("gzip: Invalid compression level %d, use 1 to 9", c.Level)
 	}
-	slog.Info("gzip: Copied input to gzip writer", slog.String("filename", filename), slog.Int64("bytes", written))
-
-	gzipWriter.Close()
-	slog.Info("gzip: Closed gzip writer", slog.Int("buffersize", buf.Len()))
-
-	r = bytes.NewReader(buf.Bytes())
-	filename = filename + ".gz"
-	slog.Info("gzip: Renamed context filename", slog.String("newname", filename+".gz"))
 
-	slog.Debug("Calling the next processor")
-	return c.NextProcessor.Process(filename, r)
+	newname := filename + ".gz"
+	logger.Info("gzip: Renamed context filename", slog.String("newname", newname))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Compress the input into the pipe
+			gzipWriter, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				return fmt.Errorf("gzip: Failed to create gzip writer: %w", err)
+			}
+			written, err := harvester.AuditCopy(ctx, "gzip.Compressor", gzipWriter, r)
+			if err != nil {
+				gzipWriter.Close()
+				return fmt.Errorf("gzip: Failed to copy input to gzip writer: %w", err)
+			}
+			logger.Info("gzip: Copied input to gzip writer", slog.String("filename", filename), slog.Int64("bytes", written))
+
+			// Write the gzip footer
+			err = gzipWriter.Close()
+			if err != nil {
+				return fmt.Errorf("gzip: Failed to close gzip writer: %w", err)
+			}
+			logger.Info("gzip: Closed gzip writer", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("Calling the next processor")
+			return c.NextProcessor.Process(ctx, newname, r)
+		},
+	)
 }
@@ -0,0 +1,107 @@
+package gzip
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, string(b)
+	return nil
+}
+
+func TestCompressorLevels(t *testing.T) {
+	tests := []struct {
+		level   int
+		wantErr bool
+	}{
+		{-2, true},
+		{-1, false},
+		{0, false},
+		{1, false},
+		{5, false},
+		{9, false},
+		{10, true},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		input := strings.Repeat("order;customer;amount\n", 1000)
+
+		// Compress, and decompress the result again
+		files := &recorder{}
+		d := &Decompressor{}
+		d.SetNext(files)
+		c := &Compressor{Level: tt.level}
+		c.SetNext(d)
+		err := c.Process(ctx, "orders.csv", strings.NewReader(input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("level %d: error %v, want error %v", tt.level, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (files.filename != "orders.csv" || files.data != input) {
+			t.Errorf("level %d: got %s with %d bytes, want orders.csv with the %d bytes that were compressed", tt.level, files.filename, len(files.data), len(input))
+		}
+	}
+}
+
+func TestDecompressor(t *testing.T) {
+	input := strings.Repeat("order;customer;amount\n", 1000)
+	compressed := &recorder{}
+	c := &Compressor{}
+	c.SetNext(compressed)
+	if err := c.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	if compressed.filename != "orders.csv.gz" {
+		t.Errorf("compressed file is named %s, want orders.csv.gz", compressed.filename)
+	}
+
+	// Change a byte of the checksum at the end
+	corrupt := []byte(compressed.data)
+	corrupt[len(corrupt)-5] ^= 0x01
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		wantName string
+		wantErr  bool
+	}{
+		{"gz", "orders.csv.gz", []byte(compressed.data), "orders.csv", false},
+		{"other extension", "orders.dat", []byte(compressed.data), "orders.dat", false},
+		{"truncated", "orders.csv.gz", []byte(compressed.data[:len(compressed.data)/2]), "", true},
+		{"wrong checksum", "orders.csv.gz", corrupt, "", true},
+		{"not gzip", "orders.csv.gz", []byte(input), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := &recorder{}
+			d := &Decompressor{}
+			d.SetNext(files)
+			err := d.Process(context.Background(), tt.filename, bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process returned %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (files.filename != tt.wantName || files.data != input) {
+				t.Errorf("got %s with %d bytes, want %s with %d bytes", files.filename, len(files.data), tt.wantName, len(input))
+			}
+		})
+	}
+}
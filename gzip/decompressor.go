@@ -1,8 +1,8 @@
 package gzip
 
 import (
-	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,40 +12,48 @@ import (
 )
 
 // Decompressor decompresses a gzip file and presents it to the next processor in the chain.
+// The uncompressed data is streamed to the next processor while it is being decompressed, so it is never held in memory.
 type Decompressor struct {
 	harvester.NextProcessor
+	Logger *slog.Logger // nil for the logger of the job
 }
 
 // Process reads a gzip file and writes the uncompressed contents to the next processor
-func (d *Decompressor) Process(filename string, r io.Reader) error {
+func (d *Decompressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
 
-	// Create a gzip reader
+	// Create a gzip reader, which reads the header right away
 	gzipReader, err := gzip.NewReader(r)
 	if err != nil {
 		return fmt.Errorf("gzip: Failed to create gzip reader for %s: %s", filename, err)
 	}
-	slog.Debug("Created gzip reader", slog.String("filename", filename))
+	logger.Debug("Created gzip reader", slog.String("filename", filename))
 
 	defer func() {
 		gzipReader.Close()
-		slog.Info("Closed gzip reader", slog.String("filename", filename))
+		logger.Info("Closed gzip reader", slog.String("filename", filename))
 	}()
 
-	// Copy the uncompressed contents to a buffer
-	buf := new(bytes.Buffer)
-	_, err = harvester.AuditCopy(buf, gzipReader)
-	if err != nil {
-		return fmt.Errorf("gzip: Failed to copy contents from gzip to buffer: %s", err)
-	}
-	slog.Info("gzip: Copied contents from gzip to buffer")
-
 	// Remove the .gz suffix from the filename
+	newname := filename
 	if strings.HasSuffix(filename, ".gz") {
-		filename = strings.TrimSuffix(filename, ".gz")
-		slog.Info("Removed .gz suffix", slog.String("newname", filename))
+		newname = strings.TrimSuffix(filename, ".gz")
+		logger.Info("Removed .gz suffix", slog.String("newname", newname))
 	}
 
-	r = bytes.NewReader(buf.Bytes())
-	slog.Debug("Calling the next processor")
-	return d.NextProcessor.Process(filename, r)
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Decompress the input into the pipe, the gzip reader verifies the checksum at the end
+			_, err := harvester.AuditCopy(ctx, "gzip.Decompressor", w, gzipReader)
+			if err != nil {
+				return fmt.Errorf("gzip: Failed to decompress %s: %w", filename, err)
+			}
+			logger.Info("gzip: Decompressed file", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("Calling the next processor")
+			return d.NextProcessor.Process(ctx, newname, r)
+		},
+	)
 }
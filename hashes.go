@@ -0,0 +1,82 @@
+package harvester
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+)
+
+// DefaultHashes are the hash algorithms that are used when a job does not configure any.
+var DefaultHashes = []string{"sha1"}
+
+// hashFactories creates a hasher for each supported algorithm.
+var hashFactories = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// WithHashes returns a context that carries the hash algorithms that AuditCopy calculates.
+func WithHashes(ctx context.Context, algorithms []string) context.Context {
+	return context.WithValue(ctx, hashesKey, algorithms)
+}
+
+// ValidateHashes returns an error if one of the hash algorithms is not supported.
+func ValidateHashes(algorithms []string) error {
+	for _, algorithm := range algorithms {
+		if _, ok := hashFactories[algorithm]; !ok {
+			return fmt.Errorf("harvester: Unsupported hash algorithm %s, supported are %v", algorithm, supportedHashes())
+		}
+	}
+	return nil
+}
+
+// NewHash returns a new hasher for the algorithm, which must be one of the algorithms that AuditCopy supports.
+func NewHash(algorithm string) (hash.Hash, error) {
+	factory, ok := hashFactories[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("harvester: Unsupported hash algorithm %s, supported are %v", algorithm, supportedHashes())
+	}
+	return factory(), nil
+}
+
+// Hashes returns the hash algorithms that AuditCopy calculates with the context, see WithHashes.
+func Hashes(ctx context.Context) []string {
+	algorithms, _ := ctx.Value(hashesKey).([]string)
+	if len(algorithms) == 0 {
+		return DefaultHashes
+	}
+	return algorithms
+}
+
+// newHashers creates a hasher for every algorithm in the context, or for the default algorithms.
+func newHashers(ctx context.Context) (map[string]hash.Hash, error) {
+	algorithms := Hashes(ctx)
+	if err := ValidateHashes(algorithms); err != nil {
+		return nil, err
+	}
+
+	hashers := make(map[string]hash.Hash, len(algorithms))
+	for _, algorithm := range algorithms {
+		hashers[algorithm] = hashFactories[algorithm]()
+	}
+	return hashers, nil
+}
+
+// supportedHashes returns the names of the supported hash algorithms, sorted.
+func supportedHashes() []string {
+	names := make([]string, 0, len(hashFactories))
+	for name := range hashFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
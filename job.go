@@ -1,19 +1,42 @@
 package harvester
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"regexp"
 	"runtime"
 	"sort"
+	"sync"
 	"time"
 )
 
 type job struct {
+	Name       string       // Added to every log line, to tell jobs in the same process apart
+	Logger     *slog.Logger // Used by the job and all processors, nil for the default logger
+	AuditStore AuditStore   // Receives a record of every transfer, nil to only log the transfers
+	Hashes     []string     // Hash algorithms calculated for every copy, example: {"sha256", "md5"}, nil for DefaultHashes
+	Retry      *RetryPolicy // Retries of connecting and renaming, for connectors without their own policy, nil for no retries
 	Reader     FileReader
 	Processors []FileWriter
 	Writer     FileWriter
 	Interval   time.Duration
+
+	// Concurrency is the maximum number of files processed in parallel. 0 or 1 processes files one by one.
+	Concurrency int
+
+	// OrderRegex groups files by the text it matches, example: "^[a-z]+". Files within a group are
+	// processed one by one in sorted order, and a failure skips the rest of the group until the next run.
+	// Files that do not match are not grouped. If OrderRegex is empty, there is no ordering between files.
+	OrderRegex string
+
+	running sync.Mutex // held while files are processed, to prevent overlapping runs
 }
 
+// ErrRunInProgress is returned when a job is started while a previous run has not finished yet.
+var ErrRunInProgress = errors.New("harvester: Previous run is still in progress")
+
 func NewJob(r FileReader, w FileWriter) *job {
 	return &job{
 		Reader:     r,
@@ -27,48 +50,282 @@ func (j *job) Insert(w FileWriter) {
 }
 
 func (j *job) RunOnce() error {
-	j.createChain()
-	return j.processFiles()
+	return j.RunOnceContext(context.Background())
+}
+
+// RunOnceContext processes all files once. When the context is cancelled, no new files
+// are started, and the file in progress is aborted and left in the source directory.
+func (j *job) RunOnceContext(ctx context.Context) error {
+	return j.run(j.context(ctx))
 }
 
 func (j *job) Run(interval time.Duration) error {
-	j.createChain()
+	return j.RunContext(context.Background(), interval)
+}
+
+// RunContext processes all files, sleeps for the interval, and repeats until the context is cancelled.
+// It returns the context's error after the current run has stopped.
+func (j *job) RunContext(ctx context.Context, interval time.Duration) error {
+	ctx = j.context(ctx)
+	logger := Logger(ctx)
 
 	for {
-		err := j.processFiles()
+		err := j.run(ctx)
+		if ctx.Err() != nil {
+			logger.Info("harvester: Stopped", slog.Any("reason", ctx.Err()))
+			return ctx.Err()
+		}
 		if err != nil {
-			slog.Error("harvester: Failed to process files", slog.Any("error", err))
+			logger.Error("harvester: Failed to process files", slog.Any("error", err))
 		}
 
-		j.logMemoryUsage()
-		slog.Info("harvester: Sleeping", slog.Duration("duration", interval))
-		time.Sleep(interval)
-		slog.Info("harvester: Waking up")
+		j.logMemoryUsage(ctx)
+		logger.Info("harvester: Sleeping", slog.Duration("duration", interval))
+		select {
+		case <-ctx.Done():
+			logger.Info("harvester: Stopped while sleeping", slog.Any("reason", ctx.Err()))
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		logger.Info("harvester: Waking up")
 	}
 }
 
-func (j *job) processFiles() error {
+// RunSchedule runs the job at the start times of the schedule, until the context is cancelled.
+// Runs never overlap: start times that pass while a run is still in progress are skipped.
+func (j *job) RunSchedule(ctx context.Context, schedule Schedule) error {
+	ctx = j.context(ctx)
+	logger := Logger(ctx)
 
-	// List files
-	filenames, err := j.Reader.List()
+	// Parse the schedule before the first run, so mistakes are reported immediately
+	s, err := schedule.compile()
 	if err != nil {
 		return err
 	}
 
-	// Process files
-	for _, filename := range filenames {
-		slog.Info("job: Processing file", slog.String("filename", filename))
-		err := j.Reader.Process(filename)
+	after := time.Now()
+	for {
+		// Wait for the next start time
+		next, err := s.next(after)
 		if err != nil {
-			slog.Error("job: Failed to process file", slog.String("filename", filename), slog.Any("error", err))
+			return err
+		}
+		logger.Info("harvester: Sleeping until next run", slog.Time("next", next))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("harvester: Stopped while sleeping", slog.Any("reason", ctx.Err()))
+			return ctx.Err()
+		case <-timer.C:
+		}
+		logger.Info("harvester: Waking up")
+
+		// Run the job
+		err = j.run(ctx)
+		if ctx.Err() != nil {
+			logger.Info("harvester: Stopped", slog.Any("reason", ctx.Err()))
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.Error("harvester: Failed to process files", slog.Any("error", err))
+		}
+		j.logMemoryUsage(ctx)
+
+		// Skip the start times that passed during the run
+		after = time.Now()
+		if missed, err := s.next(next); err == nil && missed.Before(after) {
+			logger.Warn("harvester: Run took longer than the schedule period, skipping missed start times", slog.Time("missed", missed))
+		}
+		if after.Before(next) {
+			after = next
+		}
+	}
+}
+
+// context adds the logger and name of the job to the context.
+func (j *job) context(ctx context.Context) context.Context {
+	if j.Logger != nil {
+		ctx = WithLogger(ctx, j.Logger)
+	}
+	if j.Name != "" {
+		ctx = WithJobName(ctx, j.Name)
+	}
+	if j.AuditStore != nil {
+		ctx = WithAuditStore(ctx, j.AuditStore)
+	}
+	if len(j.Hashes) > 0 {
+		ctx = WithHashes(ctx, j.Hashes)
+	}
+	if j.Retry != nil {
+		ctx = WithRetryPolicy(ctx, j.Retry)
+	}
+	return ctx
+}
+
+// run processes the files, unless a previous run of the job is still in progress.
+func (j *job) run(ctx context.Context) error {
+	if !j.running.TryLock() {
+		return ErrRunInProgress
+	}
+	defer j.running.Unlock()
+
+	// Check the hash algorithms before any file is touched
+	if err := ValidateHashes(j.Hashes); err != nil {
+		return err
+	}
+
+	// Share connections between the transfers of the run, and close them when the run is done
+	ctx = StartRun(ctx)
+	ctx, closeSessions := withSessions(ctx)
+	defer closeSessions()
+
+	j.createChain()
+	err := j.processFiles(ctx)
+
+	// Let processors that hold on to data deliver it, even if processing failed
+	if flushErr := j.flush(ctx); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// flush calls Flush on every processor and the writer that implement Flusher, in the order of the chain.
+func (j *job) flush(ctx context.Context) error {
+	logger := Logger(ctx)
+
+	var firstErr error
+	for _, w := range append(append([]FileWriter{}, j.Processors...), j.Writer) {
+		flusher, ok := w.(Flusher)
+		if !ok {
 			continue
 		}
+		if err := flusher.Flush(ctx); err != nil {
+			logger.Error("job: Failed to flush", slog.String("processor", fmt.Sprintf("%T", w)), slog.Any("error", err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (j *job) processFiles(ctx context.Context) error {
+	logger := Logger(ctx)
+
+	// List files
+	filenames, err := j.Reader.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Group files that must be processed in order
+	groups, err := j.groupFiles(ctx, filenames)
+	if err != nil {
+		return err
 	}
-	slog.Info("job: Done processing files")
+
+	// Start the workers
+	workers := min(max(j.Concurrency, 1), len(groups))
+	logger.Debug("job: Starting workers", slog.Int("workers", workers), slog.Int("groups", len(groups)))
+	queue := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				j.processGroup(ctx, group)
+			}
+		}()
+	}
+
+	// Hand out the groups, until the context is cancelled
+	remaining := len(groups)
+dispatch:
+	for _, group := range groups {
+		select {
+		case queue <- group:
+			remaining--
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		logger.Info("job: Cancelled, skipped remaining files", slog.Int("groups", remaining))
+		return err
+	}
+	logger.Info("job: Done processing files")
 
 	return nil
 }
 
+// processGroup processes the files of a group one by one. If a file fails, the rest of the group is skipped.
+func (j *job) processGroup(ctx context.Context, group []string) {
+	logger := Logger(ctx)
+
+	for i, filename := range group {
+
+		// Do not start a new file after the context has been cancelled
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Info("job: Processing file", slog.String("filename", filename))
+		err := j.Reader.Process(ctx, filename)
+		if err != nil {
+			logger.Error("job: Failed to process file", slog.String("filename", filename), slog.Any("error", err))
+			if skipped := len(group) - i - 1; skipped > 0 {
+				logger.Warn("job: Skipping the rest of the group to preserve order", slog.String("filename", filename), slog.Int("skipped", skipped))
+			}
+			return
+		}
+	}
+}
+
+// groupFiles splits the filenames into groups with the same OrderRegex match, keeping the order of the list.
+// Without an OrderRegex, every file is a group of its own.
+func (j *job) groupFiles(ctx context.Context, filenames []string) ([][]string, error) {
+	logger := Logger(ctx)
+
+	groups := make([][]string, 0, len(filenames))
+	if j.OrderRegex == "" {
+		for _, filename := range filenames {
+			groups = append(groups, []string{filename})
+		}
+		return groups, nil
+	}
+
+	// Compile the regex
+	re, err := regexp.Compile(j.OrderRegex)
+	if err != nil {
+		return nil, fmt.Errorf("job: Failed to compile order regex %s: %s", j.OrderRegex, err)
+	}
+
+	// Add each file to the group of its match
+	index := map[string]int{}
+	for _, filename := range filenames {
+		key := re.FindString(filename)
+		if key == "" {
+			groups = append(groups, []string{filename})
+			continue
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], filename)
+	}
+	logger.Debug("job: Grouped files", slog.Int("files", len(filenames)), slog.Int("groups", len(groups)))
+
+	return groups, nil
+}
+
 func (j *job) createChain() {
 	// Link the processors
 	for i, p := range j.Processors {
@@ -87,23 +344,27 @@ func (j *job) createChain() {
 	}
 }
 
-func (j *job) logMemoryUsage() {
+func (j *job) logMemoryUsage(ctx context.Context) {
+	logger := Logger(ctx)
+
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 	allocated := int64(float64(m.Alloc) / 1024)
-	slog.Info("job: Current allocated heap memory", slog.Int64("kB", allocated))
+	logger.Info("job: Current allocated heap memory", slog.Int64("kB", allocated))
 
 }
 
-func SortAndLimit(files []string, max int) []string {
+func SortAndLimit(ctx context.Context, files []string, max int) []string {
+	logger := Logger(ctx)
+
 	sort.Strings(files)
-	slog.Debug("harvester: Sorted files", slog.Int("files", len(files)))
+	logger.Debug("harvester: Sorted files", slog.Int("files", len(files)))
 	if max == 0 {
 		return files
 	}
 	if len(files) <= max {
 		return files
 	}
-	slog.Info("harvester: Limiting files", slog.Int("max", max))
+	logger.Info("harvester: Limiting files", slog.Int("max", max))
 	return files[:max]
 }
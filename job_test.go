@@ -0,0 +1,249 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReader is a reader that lists its files, and records the files it processes
+type fakeReader struct {
+	files []string
+	fail  map[string]bool // files that fail
+	hold  chan struct{}   // if set, every file waits for it
+	next  FileWriter
+
+	mu          sync.Mutex
+	processed   []string
+	inFlight    int
+	maxInFlight int
+}
+
+func (r *fakeReader) SetNext(next FileWriter) { r.next = next }
+
+func (r *fakeReader) List(ctx context.Context) ([]string, error) {
+	return append([]string{}, r.files...), nil
+}
+
+func (r *fakeReader) Process(ctx context.Context, filename string) error {
+	r.mu.Lock()
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.inFlight--
+		r.processed = append(r.processed, filename)
+		r.mu.Unlock()
+	}()
+
+	if r.hold != nil {
+		select {
+		case <-r.hold:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if r.fail[filename] {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+// waitInFlight waits until the reader has n files in progress
+func (r *fakeReader) waitInFlight(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		inFlight := r.inFlight
+		r.mu.Unlock()
+		if inFlight == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d files in progress, want %d", inFlight, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// flushCounter is a writer that counts how often it is flushed
+type flushCounter struct {
+	flushes int
+}
+
+func (w *flushCounter) SetNext(next FileWriter) {}
+
+func (w *flushCounter) Process(ctx context.Context, filename string, r io.Reader) error { return nil }
+
+func (w *flushCounter) Flush(ctx context.Context) error {
+	w.flushes++
+	return nil
+}
+
+func TestGroupFiles(t *testing.T) {
+	files := []string{"orders-1.csv", "customers-1.csv", "orders-2.csv", "README", "customers-2.csv"}
+	tests := []struct {
+		name  string
+		regex string
+		want  [][]string
+	}{
+		{"no regex", "", [][]string{{"orders-1.csv"}, {"customers-1.csv"}, {"orders-2.csv"}, {"README"}, {"customers-2.csv"}}},
+		{"by prefix", "^[a-z]+-", [][]string{{"orders-1.csv", "orders-2.csv"}, {"customers-1.csv", "customers-2.csv"}, {"README"}}},
+		{"one group", `\.csv$`, [][]string{{"orders-1.csv", "customers-1.csv", "orders-2.csv", "customers-2.csv"}, {"README"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &job{OrderRegex: tt.regex}
+			got, err := j.groupFiles(context.Background(), files)
+			if err != nil {
+				t.Fatalf("groupFiles: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups are %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (&job{OrderRegex: "("}).groupFiles(context.Background(), files); err == nil {
+		t.Errorf("groupFiles succeeded with an invalid regex")
+	}
+}
+
+func TestJobProcessesFiles(t *testing.T) {
+	files := []string{"a-1", "a-2", "a-3", "b-1", "b-2", "c-1", "d-1", "e-1"}
+	tests := []struct {
+		name        string
+		concurrency int
+		orderRegex  string
+		fail        map[string]bool
+		want        []string
+	}{
+		{"one by one", 0, "", nil, files},
+		{"parallel", 4, "", nil, files},
+		{"failure does not stop other files", 4, "", map[string]bool{"a-2": true}, files},
+		{"failure skips the rest of the group", 4, "^[a-z]", map[string]bool{"a-2": true}, []string{"a-1", "a-2", "b-1", "b-2", "c-1", "d-1", "e-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &fakeReader{files: files, fail: tt.fail}
+			writer := &flushCounter{}
+			j := NewJob(reader, writer)
+			j.Concurrency = tt.concurrency
+			j.OrderRegex = tt.orderRegex
+
+			if err := j.RunOnce(); err != nil {
+				t.Fatalf("RunOnce: %s", err)
+			}
+			got := append([]string{}, reader.processed...)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("processed %v, want %v", got, tt.want)
+			}
+			if reader.maxInFlight > max(tt.concurrency, 1) {
+				t.Errorf("processed %d files at once, want at most %d", reader.maxInFlight, max(tt.concurrency, 1))
+			}
+			if writer.flushes != 1 {
+				t.Errorf("flushed %d times, want once", writer.flushes)
+			}
+
+			// Files of a group are processed in order
+			if tt.orderRegex != "" {
+				var a []string
+				for _, filename := range reader.processed {
+					if filename[0] == 'a' {
+						a = append(a, filename)
+					}
+				}
+				if !reflect.DeepEqual(a, []string{"a-1", "a-2"}) {
+					t.Errorf("processed group a as %v, want a-1 and a-2 in order", a)
+				}
+			}
+		})
+	}
+}
+
+func TestJobRunsInParallel(t *testing.T) {
+	// All files wait until three of them are in progress at once
+	reader := &fakeReader{files: []string{"a", "b", "c", "d", "e", "f"}, hold: make(chan struct{})}
+	j := NewJob(reader, &flushCounter{})
+	j.Concurrency = 3
+
+	done := make(chan error, 1)
+	go func() { done <- j.RunOnce() }()
+	reader.waitInFlight(t, 3)
+	close(reader.hold)
+	if err := <-done; err != nil {
+		t.Fatalf("RunOnce: %s", err)
+	}
+	if reader.maxInFlight != 3 || len(reader.processed) != 6 {
+		t.Errorf("processed %d files with %d at once, want 6 with 3 at once", len(reader.processed), reader.maxInFlight)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	// The first file is in progress when the job is cancelled, and the other files are not started
+	reader := &fakeReader{files: []string{"a", "b", "c"}, hold: make(chan struct{})}
+	writer := &flushCounter{}
+	j := NewJob(reader, writer)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() { done <- j.RunOnceContext(ctx) }()
+	reader.waitInFlight(t, 1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunOnceContext returned %v, want %v", err, context.Canceled)
+	}
+	if !reflect.DeepEqual(reader.processed, []string{"a"}) {
+		t.Errorf("processed %v, want only a", reader.processed)
+	}
+	if writer.flushes != 1 {
+		t.Errorf("flushed %d times, want once, also when cancelled", writer.flushes)
+	}
+}
+
+func TestJobRunInProgress(t *testing.T) {
+	reader := &fakeReader{files: []string{"a"}, hold: make(chan struct{})}
+	j := NewJob(reader, &flushCounter{})
+
+	done := make(chan error, 1)
+	go func() { done <- j.RunOnce() }()
+	reader.waitInFlight(t, 1)
+
+	if err := j.RunOnce(); !errors.Is(err, ErrRunInProgress) {
+		t.Errorf("second run returned %v, want %v", err, ErrRunInProgress)
+	}
+	close(reader.hold)
+	if err := <-done; err != nil {
+		t.Errorf("first run: %s", err)
+	}
+}
+
+func TestSortAndLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		max   int
+		want  []string
+	}{
+		{"no limit", []string{"c", "a", "b"}, 0, []string{"a", "b", "c"}},
+		{"limit", []string{"c", "a", "b"}, 2, []string{"a", "b"}},
+		{"limit above count", []string{"b", "a"}, 5, []string{"a", "b"}},
+		{"empty", []string{}, 3, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SortAndLimit(context.Background(), tt.files, tt.max); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortAndLimit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
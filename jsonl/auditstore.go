@@ -0,0 +1,48 @@
+package jsonl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gwijnja/harvester"
+)
+
+// AuditStore appends every audit record as a single line of JSON to a file.
+type AuditStore struct {
+	Path string // Example: "/var/log/mft/audit.jsonl"
+	mu   sync.Mutex
+}
+
+// Save appends the record to the file. The file is created if it does not exist,
+// and opened for every record, so it can be rotated without restarting the job.
+func (s *AuditStore) Save(_ context.Context, record harvester.AuditRecord) error {
+
+	// Encode the record
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("jsonl: Failed to encode audit record: %s", err)
+	}
+	line = append(line, '\n')
+
+	// Prevent concurrent transfers from mixing their lines
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Append the line to the file
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("jsonl: Failed to open audit file %s: %s", s.Path, err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("jsonl: Failed to write audit record to %s: %s", s.Path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("jsonl: Failed to close audit file %s: %s", s.Path, err)
+	}
+
+	return nil
+}
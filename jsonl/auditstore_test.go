@@ -0,0 +1,91 @@
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+func TestAuditStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		records int
+		rotate  bool // remove the file halfway
+		want    int
+	}{
+		{"one record", 1, false, 1},
+		{"many records", 100, false, 100},
+		{"rotated file", 10, true, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			s := &AuditStore{Path: path}
+
+			// Save the records concurrently, every record must end up on a line of its own
+			var wg sync.WaitGroup
+			save := func(from, to int) {
+				for i := from; i < to; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						record := harvester.AuditRecord{
+							TransferID:   fmt.Sprintf("transfer-%d", i),
+							SourcePath:   "/in/orders.csv",
+							SourceHashes: map[string]string{"sha1": "f2a5f1a10d662ef7402af78b4d3f37c3266aff76"},
+							Status:       harvester.StatusSuccess,
+						}
+						if err := s.Save(context.Background(), record); err != nil {
+							t.Errorf("Save: %s", err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+			if tt.rotate {
+				save(0, tt.records/2)
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				save(tt.records/2, tt.records)
+			} else {
+				save(0, tt.records)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			lines := 0
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var record harvester.AuditRecord
+				if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+					t.Fatalf("line %d is not a record: %s", lines+1, err)
+				}
+				if record.SourceHashes["sha1"] != "f2a5f1a10d662ef7402af78b4d3f37c3266aff76" || record.Status != harvester.StatusSuccess {
+					t.Errorf("line %d has hashes %v and status %q", lines+1, record.SourceHashes, record.Status)
+				}
+				lines++
+			}
+			if lines != tt.want {
+				t.Errorf("file has %d records, want %d", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditStoreUnwritable(t *testing.T) {
+	s := &AuditStore{Path: filepath.Join(t.TempDir(), "missing", "audit.jsonl")}
+	if err := s.Save(context.Background(), harvester.AuditRecord{}); err == nil {
+		t.Errorf("Save succeeded in a directory that does not exist")
+	}
+}
@@ -1,6 +1,7 @@
 package local
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -18,15 +19,17 @@ type Archiver struct {
 	harvester.NextProcessor
 	Transmit string
 	Archive  string
-	Regex    string // Example: "(\\d{4})-(\\d{2})-(\\d{2})"
-	Format   string // Example: "$1/$2/$3"
+	Regex    string       // Example: "(\\d{4})-(\\d{2})-(\\d{2})"
+	Format   string       // Example: "$1/$2/$3"
+	Logger   *slog.Logger // nil for the logger of the job
 }
 
 // Process receives a file and writes it to the local filesystem.
-func (a *Archiver) Process(filename string, r io.Reader) error {
+func (a *Archiver) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, a.Logger)
 
 	// Prepare archive directory
-	archiveDir, err := a.PrepArchiveDir(filename)
+	archiveDir, err := a.PrepArchiveDir(ctx, filename)
 	if err != nil {
 		return err
 	}
@@ -37,30 +40,30 @@ func (a *Archiver) Process(filename string, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("local: Failed to create transmit file %s: %s", transmitPath, err)
 	}
-	slog.Info("local: Created transmit file", slog.String("path", transmitPath))
+	logger.Info("local: Created transmit file", slog.String("path", transmitPath))
 
 	defer func() {
 		f.Close()
-		slog.Info("local: Closed transmit file", slog.String("path", transmitPath))
+		logger.Info("local: Closed transmit file", slog.String("path", transmitPath))
 	}()
 
 	tee := io.TeeReader(r, f)
 
 	// Forward the reader to the next processor
-	slog.Debug("Calling NextProcessor.Process")
-	err = a.NextProcessor.Process(filename, tee)
+	logger.Debug("Calling NextProcessor.Process")
+	err = a.NextProcessor.Process(ctx, filename, tee)
 	if err != nil {
 		f.Close()
-		slog.Info("local: Closed transmit file", slog.String("path", transmitPath))
+		logger.Info("local: Closed transmit file", slog.String("path", transmitPath))
 
 		os.Remove(transmitPath)
-		slog.Info("local: Removed transmit file", slog.String("path", transmitPath))
+		logger.Info("local: Removed transmit file", slog.String("path", transmitPath))
 		return err
 	}
 
 	// Close the file
 	f.Close()
-	slog.Info("local: Closed transmit file", slog.String("path", transmitPath))
+	logger.Info("local: Closed transmit file", slog.String("path", transmitPath))
 
 	// Move to archive directory
 	archivePath := filepath.Join(archiveDir, filename)
@@ -68,18 +71,19 @@ func (a *Archiver) Process(filename string, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("local: Failed to move %s to %s: %s", transmitPath, archivePath, err)
 	}
-	slog.Info("Moved", slog.String("from", transmitPath), slog.String("to", archivePath))
+	logger.Info("Moved", slog.String("from", transmitPath), slog.String("to", archivePath))
 
 	return nil
 }
 
 // PrepArchiveDir creates the archive directory if it does not exist.
-func (r *Archiver) PrepArchiveDir(filename string) (string, error) {
+func (r *Archiver) PrepArchiveDir(ctx context.Context, filename string) (string, error) {
+	logger := harvester.ContextLogger(ctx, r.Logger)
 
 	// Match the filename with the regex
-	subPath, err := r.MatchPath(filename)
+	subPath, err := r.MatchPath(ctx, filename)
 	if err != nil {
-		slog.Warn("local: Failed to match filename, file will be placed in archive root", slog.String("filename", filename))
+		logger.Warn("local: Failed to match filename, file will be placed in archive root", slog.String("filename", filename))
 		subPath = ""
 	}
 
@@ -95,34 +99,35 @@ func (r *Archiver) PrepArchiveDir(filename string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("local: Failed to create archive directory %s: %s", fullPath, err)
 	}
-	slog.Info("local: Created archive directory", slog.String("path", fullPath))
+	logger.Info("local: Created archive directory", slog.String("path", fullPath))
 
 	return fullPath, nil
 }
 
 // MatchPath matches the filename with the regex and formats the archive path.
-func (r *Archiver) MatchPath(filename string) (string, error) {
+func (r *Archiver) MatchPath(ctx context.Context, filename string) (string, error) {
+	logger := harvester.ContextLogger(ctx, r.Logger)
 
 	// Compile the regex
 	re, err := regexp.Compile(r.Regex)
 	if err != nil {
 		return "", fmt.Errorf("local: Failed to compile regex: %s", err)
 	}
-	slog.Debug("local: Compiled regex", slog.String("regex", r.Regex))
+	logger.Debug("local: Compiled regex", slog.String("regex", r.Regex))
 
 	// Match the filename
 	matches := re.FindStringSubmatch(filename)
 	if len(matches) == 0 {
 		return "", fmt.Errorf("local: Failed to match filename %s against regex %s", filename, r.Regex)
 	}
-	slog.Debug("local: Matched regex", slog.String("filename", filename), slog.Int("matches", len(matches)))
+	logger.Debug("local: Matched regex", slog.String("filename", filename), slog.Int("matches", len(matches)))
 
 	// Format the archive path
 	path := r.Format
 	for i, match := range matches {
 		path = strings.Replace(path, fmt.Sprintf("$%d", i), match, -1)
 	}
-	slog.Info("local: Formatted subpath", slog.String("subpath", path))
+	logger.Info("local: Formatted subpath", slog.String("subpath", path))
 
 	return path, nil
 }
@@ -1,7 +1,9 @@
 package local
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -19,24 +21,30 @@ type FileReader struct {
 	FollowSymlinks      bool
 	Regex               string
 	MaxFiles            int
-	next                harvester.FileWriter
+	Sidecar             harvester.Sidecar // If set, a file is only processed with its sidecar, and must match it
+	TempDir             string            // Directory for staging files with a Sidecar larger than MaxMemory, empty for the default directory of the OS
+	MaxMemory           int64             // Files with a Sidecar up to this size are staged in memory, 0 for harvester.DefaultMaxMemory, -1 to always use a temporary file
+	Logger              *slog.Logger      // nil for the logger of the job
+	harvester.Quarantine
+	next harvester.FileWriter
 }
 
 func (r *FileReader) SetNext(next harvester.FileWriter) {
 	r.next = next
 }
 
-func (d *FileReader) List() ([]string, error) {
+func (d *FileReader) List(ctx context.Context) ([]string, error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
 
 	// List files in the ToLoad directory
 	files, err := os.ReadDir(d.ToLoad)
 	if err != nil {
 		return nil, fmt.Errorf("local: Failed to list files in %s: %s", d.ToLoad, err)
 	}
-	slog.Debug("local: Listed files in ToLoad", slog.String("path", d.ToLoad))
+	logger.Debug("local: Listed files in ToLoad", slog.String("path", d.ToLoad))
 
 	if d.Regex != "" {
-		slog.Debug("local: Filtering files with regex", slog.String("regex", d.Regex))
+		logger.Debug("local: Filtering files with regex", slog.String("regex", d.Regex))
 	}
 
 	// Prepare the regex
@@ -47,70 +55,163 @@ func (d *FileReader) List() ([]string, error) {
 
 	// Create a list of filenames
 	filenames := make([]string, 0, len(files))
+	all := make([]string, 0, len(files))
 	for _, file := range files {
+		all = append(all, file.Name())
 
 		// Skip directories
 		if file.IsDir() {
-			slog.Debug("local: Skipping directory", slog.String("filename", file.Name()))
+			logger.Debug("local: Skipping directory", slog.String("filename", file.Name()))
 			continue
 		}
 
 		// Skip symlinks if FollowSymlinks is false
 		if file.Type()&fs.ModeSymlink != 0 && !d.FollowSymlinks {
-			slog.Debug("local: Skipping symlink", slog.String("filename", file.Name()))
+			logger.Debug("local: Skipping symlink", slog.String("filename", file.Name()))
 			continue
 		}
 
 		// Skip files that do not match the regex
 		if !re.MatchString(file.Name()) {
-			slog.Warn("local: Skipping non-matching file", slog.String("filename", file.Name()))
+			logger.Warn("local: Skipping non-matching file", slog.String("filename", file.Name()))
 			continue
 		}
 
 		// Add the filename to the list
 		filenames = append(filenames, file.Name())
-		slog.Info("local: Found file", slog.String("filename", file.Name()))
+		logger.Info("local: Found file", slog.String("filename", file.Name()))
 	}
 
-	return harvester.SortAndLimit(filenames, d.MaxFiles), nil
+	// Only process files that have a sidecar, and skip the sidecars themselves
+	filenames = harvester.FilterSidecars(ctx, d.Sidecar, filenames, all)
+
+	return harvester.SortAndLimit(ctx, filenames, d.MaxFiles), nil
 }
 
 // Process reads a file from disk and presents it to the next processor in the chain.
-func (r *FileReader) Process(filename string) error {
+func (r *FileReader) Process(ctx context.Context, filename string) (err error) {
 
-	// Open the file
+	// Start the transfer, so all log lines can be correlated, and record the result in the audit trail
 	from := filepath.Join(r.ToLoad, filename)
+	ctx = harvester.StartTransfer(ctx, "local", from)
+	logger := harvester.ContextLogger(ctx, r.Logger)
+	defer func() {
+		if err != nil {
+			r.Failed(ctx, err, r.quarantine)
+		} else {
+			r.Succeeded(ctx)
+		}
+		harvester.FinishTransfer(ctx, err)
+	}()
+
+	// Open the file
 	f, err := os.Open(from)
 	if err != nil {
 		return fmt.Errorf("local: Failed to open file %s: %s", from, err)
 	}
-	slog.Debug("local: Opened file", slog.String("path", from))
+	logger.Debug("local: Opened file", slog.String("path", from))
+	if info, err := f.Stat(); err == nil {
+		harvester.SetSourceModTime(ctx, info.ModTime())
+		harvester.SetSourceMode(ctx, info.Mode().Perm())
+	}
 
 	defer func() {
 		f.Close()
-		slog.Info("local: Closed file", slog.String("path", from))
+		logger.Info("local: Closed file", slog.String("path", from))
 	}()
 
-	// Call the next processor in the chain
-	if err := r.next.Process(filename, f); err != nil {
+	// Call the next processor in the chain, and verify the file against its sidecar
+	if r.Sidecar == nil {
+		if err := r.next.Process(ctx, filename, f); err != nil {
+			return err
+		}
+	} else {
+		if err := r.verify(ctx, filename, f); err != nil {
+			return err
+		}
+	}
+
+	// After the transfer has completed succesfully, either delete the file or move it, with its sidecar
+	if err := r.done(ctx, filename); err != nil {
 		return err
 	}
+	if r.Sidecar != nil {
+		return r.done(ctx, filename+r.Sidecar.Suffix())
+	}
+	return nil
+}
 
-	// After the transfer has completed succesfully, either delete the file or move it
+// verify reads the sidecar of the file, and presents the file to the next processor once it is verified.
+// The file is staged while it is verified, so a file that does not match is never delivered.
+func (r *FileReader) verify(ctx context.Context, filename string, f io.Reader) error {
+	logger := harvester.ContextLogger(ctx, r.Logger)
+
+	// Read the sidecar
+	sidecarPath := filepath.Join(r.ToLoad, filename+r.Sidecar.Suffix())
+	sf, err := os.Open(sidecarPath)
+	if err != nil {
+		return fmt.Errorf("local: Failed to open sidecar %s: %s", sidecarPath, err)
+	}
+	content, err := harvester.ReadSidecar(sf)
+	sf.Close()
+	if err != nil {
+		return err
+	}
+	logger.Debug("local: Read sidecar", slog.String("path", sidecarPath), slog.Int("bytes", len(content)))
+
+	// Stage and verify the file, and only then present it to the next processor
+	staged := &harvester.SpillBuffer{TempDir: r.TempDir, MaxMemory: r.MaxMemory, Logger: logger}
+	defer staged.Close()
+	if err := harvester.VerifySidecar(ctx, r.Sidecar, filename, sidecarPath, content, f, staged); err != nil {
+		return err
+	}
+	return r.next.Process(ctx, filename, staged.Reader())
+}
+
+// done deletes a file from ToLoad, or moves it to Loaded
+func (r *FileReader) done(ctx context.Context, filename string) error {
+	logger := harvester.ContextLogger(ctx, r.Logger)
+
+	from := filepath.Join(r.ToLoad, filename)
 	if r.DeleteAfterDownload {
 		if err := os.Remove(from); err != nil {
 			return fmt.Errorf("local: Failed to remove file %s: %s", from, err)
 		}
-		slog.Info("local: Deleted file", slog.String("path", from))
+		logger.Info("local: Deleted file", slog.String("path", from))
 		return nil
 	}
 
 	// Move the file from ToLoad to Loaded
 	to := filepath.Join(r.Loaded, filename)
-	err = os.Rename(from, to)
-	if err != nil {
+	if err := os.Rename(from, to); err != nil {
 		return fmt.Errorf("local: Failed to move file %s to %s: %s", from, to, err)
 	}
-	slog.Info("local: Moved file", slog.String("from", from), slog.String("to", to))
+	logger.Info("local: Moved file", slog.String("from", from), slog.String("to", to))
+	return nil
+}
+
+// quarantine moves a file that keeps failing to the Error directory, and writes the sidecar next to it.
+func (r *FileReader) quarantine(ctx context.Context, errorPath string, sidecarPath string, sidecar []byte) error {
+	logger := harvester.ContextLogger(ctx, r.Logger)
+
+	from := filepath.Join(r.ToLoad, filepath.Base(errorPath))
+	if err := os.Rename(from, errorPath); err != nil {
+		return fmt.Errorf("local: Failed to move file %s to %s: %s", from, errorPath, err)
+	}
+	logger.Info("local: Moved file", slog.String("from", from), slog.String("to", errorPath))
+
+	// The sidecar of the sender goes along, so the file can be put back with it
+	if r.Sidecar != nil {
+		from, to := from+r.Sidecar.Suffix(), errorPath+r.Sidecar.Suffix()
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("local: Failed to move sidecar %s to %s: %s", from, to, err)
+		}
+		logger.Info("local: Moved sidecar", slog.String("from", from), slog.String("to", to))
+	}
+
+	if err := os.WriteFile(sidecarPath, sidecar, 0644); err != nil {
+		return fmt.Errorf("local: Failed to write sidecar %s: %s", sidecarPath, err)
+	}
+	logger.Info("local: Wrote sidecar", slog.String("path", sidecarPath))
 	return nil
 }
@@ -0,0 +1,91 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/sidecar"
+)
+
+// recorder is the next processor in the tests, and keeps the names of the files it receives
+type recorder struct {
+	files []string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	if _, err := io.ReadAll(rd); err != nil {
+		return err
+	}
+	r.files = append(r.files, filename)
+	return nil
+}
+
+// listDir returns the names of the files in the directory, sorted
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestQuarantineMovesSidecar(t *testing.T) {
+	toLoad, errorDir := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"report.csv":        "id;amount\n1;10\n",
+		"report.csv.sha256": "0000000000000000000000000000000000000000000000000000000000000000  report.csv\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(toLoad, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := &recorder{}
+	r := &FileReader{
+		ToLoad:     toLoad,
+		Sidecar:    &sidecar.Checksum{Algorithm: "sha256"},
+		Quarantine: harvester.Quarantine{Error: errorDir, MaxFailures: 2},
+	}
+	r.SetNext(next)
+
+	// The file does not match its sidecar, and is quarantined after the second run
+	ctx := harvester.StartRun(context.Background())
+	for run := 1; run <= 2; run++ {
+		filenames, err := r.List(ctx)
+		if err != nil {
+			t.Fatalf("List: %s", err)
+		}
+		if !reflect.DeepEqual(filenames, []string{"report.csv"}) {
+			t.Fatalf("run %d: listed %v, want only the data file", run, filenames)
+		}
+		if err := r.Process(ctx, "report.csv"); err == nil {
+			t.Fatalf("run %d: Process succeeded with a wrong checksum", run)
+		}
+	}
+
+	if len(next.files) != 0 {
+		t.Errorf("delivered %v, want nothing", next.files)
+	}
+	if got := listDir(t, toLoad); len(got) != 0 {
+		t.Errorf("ToLoad contains %v, want nothing", got)
+	}
+	want := []string{"report.csv", "report.csv" + harvester.SidecarSuffix, "report.csv.sha256"}
+	if got := listDir(t, errorDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Error contains %v, want %v", got, want)
+	}
+}
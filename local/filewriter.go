@@ -1,6 +1,7 @@
 package local
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,10 +16,21 @@ type FileWriter struct {
 	harvester.NextProcessor
 	Transmit string
 	ToLoad   string
+	Logger   *slog.Logger // nil for the logger of the job
 }
 
 // Process receives a file and writes it to the local filesystem.
-func (w *FileWriter) Process(filename string, r io.Reader) error {
+func (w *FileWriter) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, w.Logger)
+
+	// A file from an archive is named with the directories it is in, so create them in Transmit and ToLoad
+	if dir := filepath.Dir(filename); dir != "." {
+		for _, parent := range []string{filepath.Join(w.Transmit, dir), filepath.Join(w.ToLoad, dir)} {
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return fmt.Errorf("local: Failed to create directory %s: %s", parent, err)
+			}
+		}
+	}
 
 	// Create the file in the Transmit directory
 	transmitPath := filepath.Join(w.Transmit, filename)
@@ -26,44 +38,45 @@ func (w *FileWriter) Process(filename string, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("local: Failed to open file %s: %s", transmitPath, err)
 	}
-	slog.Info("local: Created file", slog.String("path", transmitPath))
+	logger.Info("local: Created file", slog.String("path", transmitPath))
 
 	defer func() {
 		f.Close()
-		slog.Info("local: Closed file", slog.String("path", transmitPath))
+		logger.Info("local: Closed file", slog.String("path", transmitPath))
 	}()
 
 	// Copy the reader to the file
-	written, err := harvester.AuditCopy(f, r)
+	written, err := harvester.AuditCopy(ctx, "local.FileWriter", f, r)
 	if err != nil {
 		// If the copy fails, close the file and delete it if something was created
-		slog.Warn("local: Copy failed, closing and removing the transmit file.")
+		logger.Warn("local: Copy failed, closing and removing the transmit file.")
 
 		f.Close()
-		slog.Info("local: Closed file", slog.String("path", transmitPath))
+		logger.Info("local: Closed file", slog.String("path", transmitPath))
 
 		os.Remove(transmitPath)
-		slog.Info("local: Removed file", slog.String("path", transmitPath))
+		logger.Info("local: Removed file", slog.String("path", transmitPath))
 
 		return err
 	}
-	slog.Info("local: Copied the file", slog.String("path", transmitPath), slog.Int64("written", written))
+	logger.Info("local: Copied the file", slog.String("path", transmitPath), slog.Int64("written", written))
 
 	// Move the file from Transmit to ToLoad
 	toLoadPath := fmt.Sprintf("%s/%s", w.ToLoad, filename)
 	err = os.Rename(transmitPath, toLoadPath)
 	if err != nil {
-		slog.Warn("local: Move to ToLoad failed, closing and removing the transmit file.")
+		logger.Warn("local: Move to ToLoad failed, closing and removing the transmit file.")
 
 		f.Close()
-		slog.Info("local: Closed file", slog.String("path", transmitPath))
+		logger.Info("local: Closed file", slog.String("path", transmitPath))
 
 		os.Remove(transmitPath)
-		slog.Info("local: Removed file", slog.String("path", transmitPath))
+		logger.Info("local: Removed file", slog.String("path", transmitPath))
 
 		return fmt.Errorf("local: Failed to move file %s to %s: %s", transmitPath, toLoadPath, err)
 	}
-	slog.Info("total: Moved file", slog.String("from", transmitPath), slog.String("to", toLoadPath))
+	logger.Info("total: Moved file", slog.String("from", transmitPath), slog.String("to", toLoadPath))
+	harvester.SetDestination(ctx, "local", toLoadPath)
 
 	return nil
 }
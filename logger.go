@@ -0,0 +1,56 @@
+package harvester
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger returns a context that carries the logger, for use by all processors in the chain.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// WithJobName returns a context that carries the name of the job, which is added to every log line.
+func WithJobName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, jobKey, name)
+}
+
+// Logger returns the logger in the context, or the default logger if there is none.
+// The logger includes the job name, run ID and transfer of the context.
+func Logger(ctx context.Context) *slog.Logger {
+	return ContextLogger(ctx, nil)
+}
+
+// ContextLogger returns the logger with the job name, run ID and transfer of the context.
+// If the logger is nil, the logger in the context is used, or the default logger if there is none.
+// Processors use this to log to their own logger, while keeping the attributes of the job.
+func ContextLogger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		logger, _ = ctx.Value(loggerKey).(*slog.Logger)
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	attrs := []any{}
+	if name, _ := ctx.Value(jobKey).(string); name != "" {
+		attrs = append(attrs, slog.String("job", name))
+	}
+	if runID := RunID(ctx); runID != "" {
+		attrs = append(attrs, slog.String("run_id", runID))
+	}
+	if t := TransferFromContext(ctx); t != nil {
+		attrs = append(attrs,
+			slog.String("transfer_id", t.TransferID),
+			slog.String("source_system", t.SourceSystem),
+			slog.String("source_name", t.SourceName),
+		)
+		if t.ParentID != "" {
+			attrs = append(attrs, slog.String("parent_id", t.ParentID))
+		}
+	}
+	if len(attrs) == 0 {
+		return logger
+	}
+	return logger.With(attrs...)
+}
@@ -0,0 +1,99 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+// jsonLogger returns a logger that writes JSON lines to the buffer
+func jsonLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, nil))
+}
+
+func TestContextLogger(t *testing.T) {
+	run := StartRun(WithJobName(context.Background(), "orders"))
+	transfer := StartTransfer(run, "local", "/in/archive.zip")
+	entry := StartEntryTransfer(transfer, "orders.csv")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want map[string]string
+	}{
+		{"nothing", context.Background(), map[string]string{}},
+		{"job", WithJobName(context.Background(), "orders"), map[string]string{"job": "orders"}},
+		{"run", run, map[string]string{"job": "orders", "run_id": RunID(run)}},
+		{"transfer", transfer, map[string]string{
+			"job":           "orders",
+			"run_id":        RunID(run),
+			"transfer_id":   TransferFromContext(transfer).TransferID,
+			"source_system": "local",
+			"source_name":   "archive.zip",
+		}},
+		{"entry", entry, map[string]string{
+			"job":           "orders",
+			"run_id":        RunID(run),
+			"transfer_id":   TransferFromContext(entry).TransferID,
+			"source_system": "local",
+			"source_name":   "orders.csv",
+			"parent_id":     TransferFromContext(transfer).TransferID,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ContextLogger(tt.ctx, jsonLogger(&buf)).Info("test")
+
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q: %s", buf.String(), err)
+			}
+			got := map[string]string{}
+			for key, value := range line {
+				if key != "time" && key != "level" && key != "msg" {
+					got[key], _ = value.(string)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attributes are %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggerChoice(t *testing.T) {
+	var job, processor bytes.Buffer
+	ctx := WithLogger(context.Background(), jsonLogger(&job))
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		logger        *slog.Logger
+		wantJob       bool
+		wantProcessor bool
+	}{
+		{"logger of the job", ctx, nil, true, false},
+		{"logger of the processor", ctx, jsonLogger(&processor), false, true},
+		{"logger of the processor without a job logger", context.Background(), jsonLogger(&processor), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job.Reset()
+			processor.Reset()
+			ContextLogger(tt.ctx, tt.logger).Info("test")
+			if (job.Len() > 0) != tt.wantJob || (processor.Len() > 0) != tt.wantProcessor {
+				t.Errorf("job logger wrote %q, processor logger wrote %q", job.String(), processor.String())
+			}
+		})
+	}
+
+	// Logger uses the logger of the job
+	job.Reset()
+	Logger(ctx).Info("test")
+	if job.Len() == 0 {
+		t.Errorf("Logger did not use the logger of the job")
+	}
+}
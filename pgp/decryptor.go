@@ -0,0 +1,124 @@
+package pgp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/gwijnja/harvester"
+)
+
+// ErrNotSigned is returned when Signers is set, and a file is not signed by one of them.
+var ErrNotSigned = errors.New("pgp: File is not signed by one of the Signers")
+
+// Decryptor decrypts an OpenPGP file, armored or binary, and presents it to the next processor in the chain.
+// The decrypted data is streamed to the next processor while it is being decrypted, so it is never held in memory.
+// The integrity of the file and the signature can only be checked at the end, so a file that fails the check
+// makes the transfer fail after the next processor has received the data, and the writer removes its partial file.
+type Decryptor struct {
+	harvester.NextProcessor
+	PrivateKeyFile string           // File with the private key to decrypt with, armored or binary
+	Passphrase     harvester.Secret // Passphrase of the private key, nil if it is not protected
+	Signers        []string         // Files with the public keys of the senders, if set a file must be signed by one of them
+	Logger         *slog.Logger     // nil for the logger of the job
+}
+
+// Process reads an encrypted file and writes the decrypted contents to the next processor
+func (d *Decryptor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Read the keys before anything is read
+	privateKeys, err := readPrivateKey(ctx, d.PrivateKeyFile, d.Passphrase)
+	if err != nil {
+		return err
+	}
+	signers, err := readKeys(d.Signers)
+	if err != nil {
+		return err
+	}
+	keyring := append(append(openpgp.EntityList{}, privateKeys...), signers...)
+
+	// Remove the armor, if there is one
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if isArmored(br) {
+		block, err := decodeArmor(br)
+		if err != nil {
+			return err
+		}
+		in = block.Body
+		logger.Debug("pgp: Decoded armor", slog.String("filename", filename), slog.String("type", block.Type))
+	}
+
+	// Read the header of the message, and find the key to decrypt it with
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		return nil, fmt.Errorf("pgp: File %s is not encrypted for the private key in %s", filename, d.PrivateKeyFile)
+	}
+	message, err := openpgp.ReadMessage(in, keyring, prompt, nil)
+	if err != nil {
+		return fmt.Errorf("pgp: Failed to read message %s: %w", filename, err)
+	}
+	if !message.IsEncrypted {
+		return fmt.Errorf("pgp: File %s is not encrypted", filename)
+	}
+	if message.DecryptedWith.PublicKey != nil {
+		harvester.SetAuditDetail(ctx, "pgp_decryption_key", message.DecryptedWith.PublicKey.KeyIdString())
+	}
+	if message.IsSigned {
+		harvester.SetAuditDetail(ctx, "pgp_signer", fmt.Sprintf("%016X", message.SignedByKeyId))
+	}
+
+	// A signature is required if there are Signers, and must be made by one of them
+	if len(d.Signers) > 0 && (!message.IsSigned || message.SignedBy == nil || !contains(signers, message.SignedBy.Entity)) {
+		return fmt.Errorf("pgp: Failed to verify %s: %w", filename, ErrNotSigned)
+	}
+
+	// Remove the extension from the filename
+	newname := filename
+	for _, extension := range []string{".pgp", ".gpg", ".asc"} {
+		if strings.HasSuffix(filename, extension) {
+			newname = strings.TrimSuffix(filename, extension)
+			logger.Info("pgp: Removed extension", slog.String("newname", newname))
+			break
+		}
+	}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Decrypt the input into the pipe, the integrity and signature are checked at the end
+			written, err := harvester.AuditCopy(ctx, "pgp.Decryptor", w, message.UnverifiedBody)
+			if err != nil {
+				return fmt.Errorf("pgp: Failed to decrypt %s: %w", filename, err)
+			}
+			if len(d.Signers) > 0 {
+				if message.SignatureError != nil {
+					harvester.SetAuditDetail(ctx, "pgp_signature", "invalid")
+					return fmt.Errorf("pgp: Invalid signature on %s: %w", filename, message.SignatureError)
+				}
+				harvester.SetAuditDetail(ctx, "pgp_signature", "valid")
+				logger.Info("pgp: Verified signature", slog.String("filename", filename), slog.String("signer", message.SignedBy.PublicKey.KeyIdString()))
+			}
+			logger.Info("pgp: Decrypted file", slog.String("filename", filename), slog.Int64("bytes", written))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("pgp: Calling the next processor")
+			return d.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
+
+// contains reports whether the entity is in the list
+func contains(list openpgp.EntityList, entity *openpgp.Entity) bool {
+	for _, e := range list {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
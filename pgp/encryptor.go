@@ -0,0 +1,127 @@
+package pgp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/gwijnja/harvester"
+)
+
+// Encryptor encrypts a file with OpenPGP for one or more recipients, optionally signs it,
+// and presents it to the next processor in the chain. The encrypted data is streamed to the next processor
+// while it is being encrypted, so it is never held in memory.
+type Encryptor struct {
+	harvester.NextProcessor
+	Recipients     []string         // Files with the public keys of the recipients, armored or binary, at least one
+	SigningKeyFile string           // File with the private key to sign with, empty to not sign
+	Passphrase     harvester.Secret // Passphrase of the signing key, nil if it is not protected
+	Armor          bool             // Write an ASCII armored message with the .asc extension, instead of binary with .pgp
+	Logger         *slog.Logger     // nil for the logger of the job
+}
+
+// Process reads a file and writes the encrypted contents to the next processor
+func (e *Encryptor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, e.Logger)
+
+	// Read the keys before anything is read
+	recipients, err := readKeys(e.Recipients)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("pgp: No Recipients")
+	}
+	encryptionKeys := []openpgp.Key{}
+	for _, recipient := range recipients {
+		key, ok := recipient.EncryptionKey(time.Now())
+		if !ok {
+			return fmt.Errorf("pgp: Recipient %s has no valid encryption key", recipient.PrimaryKey.KeyIdString())
+		}
+		encryptionKeys = append(encryptionKeys, key)
+	}
+	harvester.SetAuditDetail(ctx, "pgp_recipients", keyIDs(encryptionKeys))
+
+	var signer *openpgp.Entity
+	if e.SigningKeyFile != "" {
+		keys, err := readPrivateKey(ctx, e.SigningKeyFile, e.Passphrase)
+		if err != nil {
+			return err
+		}
+		signer = keys[0]
+		key, ok := signer.SigningKey(time.Now())
+		if !ok {
+			return fmt.Errorf("pgp: Key %s has no valid signing key", signer.PrimaryKey.KeyIdString())
+		}
+		harvester.SetAuditDetail(ctx, "pgp_signer", key.PublicKey.KeyIdString())
+	}
+
+	// Rename the file
+	newname := filename + ".pgp"
+	if e.Armor {
+		newname = filename + ".asc"
+	}
+	logger.Info("pgp: Renamed context filename", slog.String("newname", newname))
+
+	// The name and modification time are stored in the encrypted message
+	hints := &openpgp.FileHints{IsBinary: true, FileName: filepath.Base(filename)}
+	if t := harvester.TransferFromContext(ctx); t != nil {
+		hints.ModTime = t.SourceModTime()
+	}
+	config := &packet.Config{DefaultCipher: packet.CipherAES256}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Armor the message if needed
+			var out io.WriteCloser = nopWriteCloser{w}
+			if e.Armor {
+				armorWriter, err := armor.Encode(w, "PGP MESSAGE", nil)
+				if err != nil {
+					return fmt.Errorf("pgp: Failed to create armor writer: %w", err)
+				}
+				out = armorWriter
+			}
+
+			// Encrypt the input into the pipe
+			plaintext, err := openpgp.Encrypt(out, recipients, signer, hints, config)
+			if err != nil {
+				return fmt.Errorf("pgp: Failed to create encryption writer: %w", err)
+			}
+			written, err := harvester.AuditCopy(ctx, "pgp.Encryptor", plaintext, r)
+			if err != nil {
+				plaintext.Close()
+				return fmt.Errorf("pgp: Failed to copy input to encryption writer: %w", err)
+			}
+			logger.Info("pgp: Encrypted file", slog.String("filename", filename), slog.Int64("bytes", written), slog.Int("recipients", len(recipients)), slog.Bool("signed", signer != nil))
+
+			// Write the signature and the end of the message
+			if err := plaintext.Close(); err != nil {
+				return fmt.Errorf("pgp: Failed to close encryption writer: %w", err)
+			}
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("pgp: Failed to close armor writer: %w", err)
+			}
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("pgp: Calling the next processor")
+			return e.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
+
+// nopWriteCloser adds a Close method that does nothing to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
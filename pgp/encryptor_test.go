@@ -0,0 +1,250 @@
+package pgp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, string(b)
+	return nil
+}
+
+// secret returns the value as a harvester.Secret
+func secret(value string) harvester.Secret {
+	return harvester.SecretFunc(func(ctx context.Context) (string, error) { return value, nil })
+}
+
+// key is a generated key pair, written to files
+type key struct {
+	public  string // armored public key
+	private string // binary private key
+}
+
+// newKey generates a key pair in a temporary directory, and protects the private key if a passphrase is given
+func newKey(t *testing.T, name string, passphrase string) key {
+	t.Helper()
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	k := key{public: filepath.Join(dir, name+".asc"), private: filepath.Join(dir, name+".key")}
+
+	public, err := os.Create(k.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer public.Close()
+	w, err := armor.Encode(public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if passphrase != "" {
+		if err := entity.EncryptPrivateKeys([]byte(passphrase), config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	private, err := os.Create(k.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer private.Close()
+	if err := entity.SerializePrivateWithoutSigning(private, config); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	ours := newKey(t, "ours", "")
+	theirs := newKey(t, "theirs", "")
+	protected := newKey(t, "protected", "s3cret")
+	input := strings.Repeat("order;customer;amount\n", 5000)
+
+	tests := []struct {
+		name      string
+		encryptor Encryptor
+		decryptor Decryptor
+		wantName  string
+		wantErr   error // nil for any error if failing is true
+		failing   bool
+	}{
+		{
+			name:      "binary",
+			encryptor: Encryptor{Recipients: []string{ours.public}},
+			decryptor: Decryptor{PrivateKeyFile: ours.private},
+			wantName:  "orders.csv.pgp",
+		},
+		{
+			name:      "armored",
+			encryptor: Encryptor{Recipients: []string{ours.public}, Armor: true},
+			decryptor: Decryptor{PrivateKeyFile: ours.private},
+			wantName:  "orders.csv.asc",
+		},
+		{
+			name:      "one of several recipients",
+			encryptor: Encryptor{Recipients: []string{theirs.public, ours.public}},
+			decryptor: Decryptor{PrivateKeyFile: ours.private},
+			wantName:  "orders.csv.pgp",
+		},
+		{
+			name:      "protected key",
+			encryptor: Encryptor{Recipients: []string{protected.public}},
+			decryptor: Decryptor{PrivateKeyFile: protected.private, Passphrase: secret("s3cret")},
+			wantName:  "orders.csv.pgp",
+		},
+		{
+			name:      "signed",
+			encryptor: Encryptor{Recipients: []string{ours.public}, SigningKeyFile: protected.private, Passphrase: secret("s3cret")},
+			decryptor: Decryptor{PrivateKeyFile: ours.private, Signers: []string{protected.public}},
+			wantName:  "orders.csv.pgp",
+		},
+		{
+			name:      "other key",
+			encryptor: Encryptor{Recipients: []string{theirs.public}},
+			decryptor: Decryptor{PrivateKeyFile: ours.private},
+			wantName:  "orders.csv.pgp",
+			failing:   true,
+		},
+		{
+			name:      "wrong passphrase",
+			encryptor: Encryptor{Recipients: []string{protected.public}},
+			decryptor: Decryptor{PrivateKeyFile: protected.private, Passphrase: secret("guess")},
+			wantName:  "orders.csv.pgp",
+			failing:   true,
+		},
+		{
+			name:      "not signed",
+			encryptor: Encryptor{Recipients: []string{ours.public}},
+			decryptor: Decryptor{PrivateKeyFile: ours.private, Signers: []string{theirs.public}},
+			wantName:  "orders.csv.pgp",
+			wantErr:   ErrNotSigned,
+		},
+		{
+			name:      "signed by someone else",
+			encryptor: Encryptor{Recipients: []string{ours.public}, SigningKeyFile: protected.private, Passphrase: secret("s3cret")},
+			decryptor: Decryptor{PrivateKeyFile: ours.private, Signers: []string{theirs.public}},
+			wantName:  "orders.csv.pgp",
+			wantErr:   ErrNotSigned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := &recorder{}
+			tt.encryptor.SetNext(encrypted)
+			if err := tt.encryptor.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+				t.Fatalf("Encrypt: %s", err)
+			}
+			if encrypted.filename != tt.wantName {
+				t.Errorf("encrypted file is named %s, want %s", encrypted.filename, tt.wantName)
+			}
+			if tt.encryptor.Armor != strings.HasPrefix(encrypted.data, "-----BEGIN PGP MESSAGE-----") {
+				t.Errorf("armor is %v, but the file starts with %q", tt.encryptor.Armor, encrypted.data[:10])
+			}
+
+			files := &recorder{}
+			tt.decryptor.SetNext(files)
+			err := tt.decryptor.Process(context.Background(), encrypted.filename, strings.NewReader(encrypted.data))
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Decrypt returned %v, want %v", err, tt.wantErr)
+				}
+			case tt.failing:
+				if err == nil {
+					t.Errorf("Decrypt succeeded, want an error")
+				}
+			case err != nil:
+				t.Errorf("Decrypt: %s", err)
+			case files.filename != "orders.csv" || files.data != input:
+				t.Errorf("got %s with %d bytes, want orders.csv with %d bytes", files.filename, len(files.data), len(input))
+			}
+		})
+	}
+}
+
+func TestDecryptDamagedFile(t *testing.T) {
+	k := newKey(t, "ours", "")
+	input := strings.Repeat("order;customer;amount\n", 5000)
+	encrypted := &recorder{}
+	e := &Encryptor{Recipients: []string{k.public}}
+	e.SetNext(encrypted)
+	if err := e.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+
+	changed := []byte(encrypted.data)
+	changed[len(changed)-100] ^= 0x01
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"changed", string(changed)},
+		{"truncated", encrypted.data[:len(encrypted.data)-100]},
+		{"not encrypted", input},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decryptor{PrivateKeyFile: k.private}
+			d.SetNext(&recorder{})
+			if err := d.Process(context.Background(), "orders.csv.pgp", strings.NewReader(tt.data)); err == nil {
+				t.Errorf("decrypting succeeded")
+			}
+		})
+	}
+}
+
+func TestConfigurationErrors(t *testing.T) {
+	k := newKey(t, "ours", "")
+	protected := newKey(t, "protected", "s3cret")
+	tests := []struct {
+		name      string
+		processor harvester.FileWriter
+	}{
+		{"no recipients", &Encryptor{}},
+		{"missing recipient", &Encryptor{Recipients: []string{filepath.Join(t.TempDir(), "missing.asc")}}},
+		{"signing with a public key", &Encryptor{Recipients: []string{k.public}, SigningKeyFile: k.public}},
+		{"protected key without passphrase", &Encryptor{Recipients: []string{k.public}, SigningKeyFile: protected.private}},
+		{"decrypting with a public key", &Decryptor{PrivateKeyFile: k.public}},
+		{"missing signer", &Decryptor{PrivateKeyFile: k.private, Signers: []string{filepath.Join(t.TempDir(), "missing.asc")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.processor.SetNext(&recorder{})
+			if err := tt.processor.Process(context.Background(), "orders.csv", strings.NewReader("x")); err == nil {
+				t.Errorf("Process succeeded, want an error")
+			}
+		})
+	}
+}
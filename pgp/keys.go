@@ -0,0 +1,96 @@
+package pgp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/gwijnja/harvester"
+)
+
+// armorPrefix is the start of an ASCII armored key or message.
+var armorPrefix = []byte("-----BEGIN PGP")
+
+// readKeys reads the keys in the files, which may be armored or binary. The files are read every time,
+// so a replaced key is used without a restart.
+func readKeys(paths []string) (openpgp.EntityList, error) {
+	keys := openpgp.EntityList{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("pgp: Failed to open key file %s: %s", path, err)
+		}
+		r := bufio.NewReader(f)
+		var list openpgp.EntityList
+		if isArmored(r) {
+			list, err = openpgp.ReadArmoredKeyRing(r)
+		} else {
+			list, err = openpgp.ReadKeyRing(r)
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("pgp: Failed to read key file %s: %s", path, err)
+		}
+		keys = append(keys, list...)
+	}
+	return keys, nil
+}
+
+// readPrivateKey reads the private key in the file, and decrypts it with the passphrase if it is protected
+func readPrivateKey(ctx context.Context, path string, passphrase harvester.Secret) (openpgp.EntityList, error) {
+	keys, err := readKeys([]string{path})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("pgp: Key file %s does not contain a key", path)
+	}
+	for _, key := range keys {
+		if key.PrivateKey == nil {
+			return nil, fmt.Errorf("pgp: Key file %s does not contain a private key", path)
+		}
+		if !key.PrivateKey.Encrypted {
+			continue
+		}
+		if passphrase == nil {
+			return nil, fmt.Errorf("pgp: Private key in %s is protected, but there is no Passphrase", path)
+		}
+		secret, err := passphrase.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("pgp: Failed to get passphrase: %s", err)
+		}
+		if err := key.DecryptPrivateKeys([]byte(secret)); err != nil {
+			return nil, fmt.Errorf("pgp: Failed to decrypt private key in %s: %s", path, err)
+		}
+	}
+	return keys, nil
+}
+
+// isArmored reports whether the data starts with an armor header, after any white space
+func isArmored(r *bufio.Reader) bool {
+	head, _ := r.Peek(64)
+	return bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), armorPrefix)
+}
+
+// decodeArmor returns the binary data of an armored message
+func decodeArmor(r *bufio.Reader) (*armor.Block, error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("pgp: Failed to decode armor: %s", err)
+	}
+	return block, nil
+}
+
+// keyIDs returns the IDs of the keys as a comma separated list, for the audit trail
+func keyIDs(keys []openpgp.Key) string {
+	ids := []string{}
+	for _, key := range keys {
+		ids = append(ids, key.PublicKey.KeyIdString())
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,137 @@
+package pgp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/gwijnja/harvester"
+)
+
+// Signature is a sidecar with a detached OpenPGP signature of a file, for sidecar.Writer and the readers.
+// The file is signed and verified while it streams by, so it is never held in memory.
+type Signature struct {
+	SigningKeyFile string           // File with the private key to sign with, armored or binary (sidecar.Writer)
+	Passphrase     harvester.Secret // Passphrase of the private key, nil if it is not protected (sidecar.Writer)
+	Signers        []string         // Files with the public keys of the senders, the file must be signed by one of them (readers)
+	Armor          bool             // Create ASCII armored signatures with the suffix ".asc", instead of binary signatures with ".sig"
+}
+
+// Suffix returns ".asc" for armored signatures, and ".sig" otherwise
+func (s *Signature) Suffix() string {
+	if s.Armor {
+		return ".asc"
+	}
+	return ".sig"
+}
+
+// Create returns a builder that signs the file with the private key in SigningKeyFile
+func (s *Signature) Create(ctx context.Context, filename string) (harvester.SidecarBuilder, error) {
+	keys, err := readPrivateKey(ctx, s.SigningKeyFile, s.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+	harvester.SetAuditDetail(ctx, "pgp_signer", key.PrimaryKey.KeyIdString())
+
+	sign := openpgp.DetachSign
+	if s.Armor {
+		sign = openpgp.ArmoredDetachSign
+	}
+	b := &signatureBuilder{}
+	b.start(func(r io.Reader) error {
+		if err := sign(&b.signature, key, r, nil); err != nil {
+			return fmt.Errorf("pgp: Failed to sign %s: %s", filename, err)
+		}
+		return nil
+	})
+	return b, nil
+}
+
+// Verify returns a verifier that checks the signature in the sidecar against the keys in Signers
+func (s *Signature) Verify(ctx context.Context, filename string, sidecar []byte) (harvester.SidecarVerifier, error) {
+	if len(s.Signers) == 0 {
+		return nil, fmt.Errorf("pgp: No Signers to verify the signature of %s with", filename)
+	}
+	signers, err := readKeys(s.Signers)
+	if err != nil {
+		return nil, err
+	}
+
+	check := openpgp.CheckDetachedSignature
+	if isArmored(bufio.NewReader(bytes.NewReader(sidecar))) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	v := signatureVerifier{&signatureBuilder{}}
+	v.start(func(r io.Reader) error {
+		signer, err := check(signers, r, bytes.NewReader(sidecar), nil)
+		if err != nil {
+			if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+				return fmt.Errorf("pgp: Failed to verify %s: %w", filename, ErrNotSigned)
+			}
+			var signatureError pgperrors.SignatureError
+			if errors.As(err, &signatureError) {
+				harvester.SetAuditDetail(ctx, "pgp_signature", "invalid")
+				return fmt.Errorf("%w: invalid signature on %s: %s", harvester.ErrVerificationFailed, filename, err)
+			}
+			return fmt.Errorf("pgp: Failed to verify %s: %s", filename, err)
+		}
+		harvester.SetAuditDetail(ctx, "pgp_signer", signer.PrimaryKey.KeyIdString())
+		harvester.SetAuditDetail(ctx, "pgp_signature", "valid")
+		return nil
+	})
+	return v, nil
+}
+
+// signatureBuilder passes the data of a file through a pipe to a function that signs or verifies it
+type signatureBuilder struct {
+	pw        *io.PipeWriter
+	done      chan error
+	signature bytes.Buffer
+}
+
+// start runs the function in a goroutine, which reads the data that is written to the builder
+func (b *signatureBuilder) start(f func(r io.Reader) error) {
+	pr, pw := io.Pipe()
+	b.pw = pw
+	b.done = make(chan error, 1)
+	go func() {
+		err := f(pr)
+		pr.CloseWithError(err) // fails further writes, instead of blocking them
+		b.done <- err
+	}()
+}
+
+// Write passes the data to the goroutine
+func (b *signatureBuilder) Write(p []byte) (int, error) {
+	return b.pw.Write(p)
+}
+
+// Finish ends the data, and returns the signature or the result of the verification
+func (b *signatureBuilder) Finish(err error) ([]byte, error) {
+	b.pw.CloseWithError(err) // a nil error closes the pipe with io.EOF
+	doneErr := <-b.done
+	if err != nil {
+		return nil, err
+	}
+	if doneErr != nil {
+		return nil, doneErr
+	}
+	return b.signature.Bytes(), nil
+}
+
+// signatureVerifier passes the data of a file to a function that verifies it
+type signatureVerifier struct {
+	*signatureBuilder
+}
+
+// Finish ends the data, and returns the result of the verification
+func (v signatureVerifier) Finish(err error) error {
+	_, err = v.signatureBuilder.Finish(err)
+	return err
+}
@@ -0,0 +1,92 @@
+package pgp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// sign returns the detached signature of the data
+func sign(t *testing.T, s *Signature, data string) []byte {
+	t.Helper()
+	b, err := s.Create(context.Background(), "orders.csv")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if _, err := io.Copy(b, strings.NewReader(data)); err != nil {
+		t.Fatalf("signing: %s", err)
+	}
+	signature, err := b.Finish(nil)
+	if err != nil {
+		t.Fatalf("Finish: %s", err)
+	}
+	return signature
+}
+
+func TestSignature(t *testing.T) {
+	ours := newKey(t, "ours", "s3cret")
+	theirs := newKey(t, "theirs", "")
+	data := strings.Repeat("order;customer;amount\n", 5000)
+
+	tests := []struct {
+		name    string
+		armor   bool
+		signers []string
+		data    string
+		wantErr error
+	}{
+		{"binary", false, []string{ours.public}, data, nil},
+		{"armored", true, []string{ours.public}, data, nil},
+		{"one of several signers", false, []string{theirs.public, ours.public}, data, nil},
+		{"other signer", false, []string{theirs.public}, data, ErrNotSigned},
+		{"changed data", false, []string{ours.public}, strings.Replace(data, "amount", "amounT", 1), harvester.ErrVerificationFailed},
+		{"shorter data", true, []string{ours.public}, data[:len(data)-1], harvester.ErrVerificationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Signature{SigningKeyFile: ours.private, Passphrase: secret("s3cret"), Signers: tt.signers, Armor: tt.armor}
+			signature := sign(t, s, data)
+			if tt.armor != strings.HasPrefix(string(signature), "-----BEGIN PGP SIGNATURE-----") {
+				t.Errorf("armor is %v, but the signature starts with %q", tt.armor, signature[:10])
+			}
+
+			v, err := s.Verify(context.Background(), "orders.csv", signature)
+			if err != nil {
+				t.Fatalf("Verify: %s", err)
+			}
+			// A verifier that already knows the result fails the write, and Finish returns the reason
+			io.Copy(v, strings.NewReader(tt.data))
+			if err := v.Finish(nil); tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Finish returned %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureReadError(t *testing.T) {
+	k := newKey(t, "ours", "")
+	s := &Signature{SigningKeyFile: k.private, Signers: []string{k.public}}
+	signature := sign(t, s, "id;amount\n")
+
+	v, err := s.Verify(context.Background(), "orders.csv", signature)
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+	lost := errors.New("connection lost")
+	if _, err := io.Copy(v, strings.NewReader("id;")); err != nil {
+		t.Fatalf("verifying: %s", err)
+	}
+	if err := v.Finish(lost); !errors.Is(err, lost) {
+		t.Errorf("Finish returned %v, want %v", err, lost)
+	}
+}
+
+func TestSignatureWithoutSigners(t *testing.T) {
+	if _, err := (&Signature{}).Verify(context.Background(), "orders.csv", []byte("signature")); err == nil {
+		t.Errorf("Verify succeeded without Signers, want an error")
+	}
+}
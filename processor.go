@@ -1,6 +1,9 @@
 package harvester
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 // NextProcessor is a struct that holds the next processor in the chain.
 type NextProcessor struct {
@@ -13,9 +16,9 @@ func (b *NextProcessor) SetNext(next FileWriter) {
 }
 
 // Process calls the next processor in the chain, if it exists.
-func (b *NextProcessor) Process(filename string, r io.Reader) error {
+func (b *NextProcessor) Process(ctx context.Context, filename string, r io.Reader) error {
 	if b.next != nil {
-		return b.next.Process(filename, r)
+		return b.next.Process(ctx, filename, r)
 	}
 	return nil
 }
@@ -0,0 +1,128 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quarantine moves files that keep failing out of the ToLoad directory of a reader, so they are not retried forever.
+// Readers embed it, and report the result of every transfer with Succeeded or Failed.
+// The failures are counted in memory, so the count starts over when the program restarts.
+type Quarantine struct {
+	Error       string                                   // Directory for files that failed MaxFailures times
+	MaxFailures int                                      // Number of consecutive failures before a file is quarantined, 0 to never quarantine
+	Notify      func(ctx context.Context, q Quarantined) // Called after a file was quarantined, optional
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+// Quarantined describes a file that was moved to the Error directory. It is written next to the file as a sidecar.
+type Quarantined struct {
+	JobName      string    `json:"job,omitempty"`
+	RunID        string    `json:"run_id"`
+	TransferID   string    `json:"transfer_id"`
+	SourceSystem string    `json:"source_system"`
+	SourcePath   string    `json:"source_path"`
+	ErrorPath    string    `json:"error_path"`
+	SidecarPath  string    `json:"sidecar_path"`
+	Failures     int       `json:"failures"`
+	Error        string    `json:"error"`
+	Quarantined  time.Time `json:"quarantined"`
+}
+
+// SidecarSuffix is appended to the filename of a quarantined file, to name the sidecar that describes the error.
+const SidecarSuffix = ".error.json"
+
+// QuarantineFunc moves the file of a transfer to errorPath, and writes the sidecar to sidecarPath.
+// It is implemented by every reader, because only the reader knows how to reach its files.
+type QuarantineFunc func(ctx context.Context, errorPath string, sidecarPath string, sidecar []byte) error
+
+// Succeeded resets the failure count of the file of the transfer in the context.
+func (q *Quarantine) Succeeded(ctx context.Context) {
+	t := TransferFromContext(ctx)
+	if t == nil || q.MaxFailures <= 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.failures, q.key(t))
+}
+
+// Failed counts a failure of the file of the transfer in the context. When the file has failed MaxFailures times
+// in a row, it is moved to the Error directory with the move function, and Notify is called.
+// Failures caused by cancelling the job are not counted.
+func (q *Quarantine) Failed(ctx context.Context, err error, move QuarantineFunc) {
+	logger := Logger(ctx)
+
+	t := TransferFromContext(ctx)
+	if t == nil || q.MaxFailures <= 0 || ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	// Count the failure
+	key := q.key(t)
+	q.mu.Lock()
+	if q.failures == nil {
+		q.failures = map[string]int{}
+	}
+	q.failures[key]++
+	failures := q.failures[key]
+	q.mu.Unlock()
+
+	if failures < q.MaxFailures {
+		logger.Warn("harvester: File failed", slog.Int("failures", failures), slog.Int("max_failures", q.MaxFailures))
+		return
+	}
+	if q.Error == "" {
+		logger.Error("harvester: Cannot quarantine file, no Error directory", slog.Int("failures", failures))
+		return
+	}
+
+	// Describe the error
+	jobName, _ := ctx.Value(jobKey).(string)
+	errorPath := filepath.Join(q.Error, t.SourceName)
+	quarantined := Quarantined{
+		JobName:      jobName,
+		RunID:        t.RunID,
+		TransferID:   t.TransferID,
+		SourceSystem: t.SourceSystem,
+		SourcePath:   t.SourcePath,
+		ErrorPath:    errorPath,
+		SidecarPath:  errorPath + SidecarSuffix,
+		Failures:     failures,
+		Error:        err.Error(),
+		Quarantined:  time.Now(),
+	}
+	sidecar, jsonErr := json.MarshalIndent(quarantined, "", "  ")
+	if jsonErr != nil {
+		logger.Error("harvester: Failed to marshal quarantine sidecar", slog.Any("error", jsonErr))
+		return
+	}
+
+	// Move the file out of the way
+	if moveErr := move(ctx, quarantined.ErrorPath, quarantined.SidecarPath, sidecar); moveErr != nil {
+		logger.Error("harvester: Failed to quarantine file", slog.String("error_path", errorPath), slog.Any("error", moveErr))
+		return
+	}
+	logger.Error("harvester: Quarantined file", slog.String("error_path", errorPath), slog.Int("failures", failures), slog.Any("error", err))
+
+	q.mu.Lock()
+	delete(q.failures, key)
+	q.mu.Unlock()
+
+	if q.Notify != nil {
+		q.Notify(ctx, quarantined)
+	}
+}
+
+// key identifies the file of the transfer across runs
+func (q *Quarantine) key(t *Transfer) string {
+	return t.SourceSystem + " " + t.SourcePath
+}
@@ -0,0 +1,156 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// quarantineStep is a transfer of a file in a quarantine test
+type quarantineStep struct {
+	path string // source path of the file
+	err  error  // result of the transfer, nil for success
+}
+
+func TestQuarantineCounts(t *testing.T) {
+	failed := errors.New("not a valid zip file")
+	tests := []struct {
+		name        string
+		maxFailures int
+		noErrorDir  bool
+		moveErr     error
+		steps       []quarantineStep
+		want        []string // files that were quarantined, in order
+	}{
+		{
+			name:        "quarantined after max failures",
+			maxFailures: 3,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", failed}, {"/in/a.zip", failed}},
+			want:        []string{"/error/a.zip"},
+		},
+		{
+			name:        "not yet",
+			maxFailures: 3,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", failed}},
+		},
+		{
+			name:        "success resets the count",
+			maxFailures: 2,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", nil}, {"/in/a.zip", failed}},
+		},
+		{
+			name:        "files are counted separately",
+			maxFailures: 2,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/b.zip", failed}, {"/in/b.zip", failed}},
+			want:        []string{"/error/b.zip"},
+		},
+		{
+			name:        "cancelled transfers are not counted",
+			maxFailures: 2,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", fmt.Errorf("read: %w", context.Canceled)}},
+		},
+		{
+			name:        "count starts over after quarantine",
+			maxFailures: 1,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", failed}},
+			want:        []string{"/error/a.zip", "/error/a.zip"},
+		},
+		{
+			name:        "disabled",
+			maxFailures: 0,
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", failed}},
+		},
+		{
+			name:        "no Error directory",
+			maxFailures: 1,
+			noErrorDir:  true,
+			steps:       []quarantineStep{{"/in/a.zip", failed}},
+		},
+		{
+			name:        "failed move is tried again on the next failure",
+			maxFailures: 1,
+			moveErr:     errors.New("permission denied"),
+			steps:       []quarantineStep{{"/in/a.zip", failed}, {"/in/a.zip", failed}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errorDir := "/error"
+			if tt.noErrorDir {
+				errorDir = ""
+			}
+			var notified []Quarantined
+			q := &Quarantine{
+				Error:       errorDir,
+				MaxFailures: tt.maxFailures,
+				Notify:      func(ctx context.Context, quarantined Quarantined) { notified = append(notified, quarantined) },
+			}
+
+			var moved []string
+			attempts := 0
+			move := func(ctx context.Context, errorPath string, sidecarPath string, sidecar []byte) error {
+				attempts++
+				if tt.moveErr != nil {
+					return tt.moveErr
+				}
+				moved = append(moved, errorPath)
+				return nil
+			}
+
+			run := StartRun(WithJobName(context.Background(), "orders"))
+			for _, step := range tt.steps {
+				ctx := StartTransfer(run, "local", step.path)
+				if step.err != nil {
+					q.Failed(ctx, step.err, move)
+				} else {
+					q.Succeeded(ctx)
+				}
+			}
+
+			if fmt.Sprint(moved) != fmt.Sprint(tt.want) {
+				t.Errorf("quarantined %v, want %v", moved, tt.want)
+			}
+			if len(notified) != len(tt.want) {
+				t.Errorf("notified %d times, want %d", len(notified), len(tt.want))
+			}
+			if tt.moveErr != nil && attempts != len(tt.steps) {
+				t.Errorf("tried to move %d times, want %d", attempts, len(tt.steps))
+			}
+		})
+	}
+}
+
+func TestQuarantineSidecar(t *testing.T) {
+	q := &Quarantine{Error: "/error", MaxFailures: 2}
+	var written Quarantined
+	move := func(ctx context.Context, errorPath string, sidecarPath string, sidecar []byte) error {
+		if sidecarPath != errorPath+SidecarSuffix {
+			t.Errorf("sidecar path is %s, want %s", sidecarPath, errorPath+SidecarSuffix)
+		}
+		return json.Unmarshal(sidecar, &written)
+	}
+
+	run := StartRun(WithJobName(context.Background(), "orders"))
+	q.Failed(StartTransfer(run, "sftp", "/in/a.zip"), errors.New("first"), move)
+	ctx := StartTransfer(run, "sftp", "/in/a.zip")
+	q.Failed(ctx, errors.New("second"), move)
+
+	tr := TransferFromContext(ctx)
+	want := Quarantined{
+		JobName:      "orders",
+		RunID:        tr.RunID,
+		TransferID:   tr.TransferID,
+		SourceSystem: "sftp",
+		SourcePath:   "/in/a.zip",
+		ErrorPath:    "/error/a.zip",
+		SidecarPath:  "/error/a.zip" + SidecarSuffix,
+		Failures:     2,
+		Error:        "second",
+		Quarantined:  written.Quarantined,
+	}
+	if written != want || written.Quarantined.IsZero() {
+		t.Errorf("sidecar is %+v, want %+v", written, want)
+	}
+}
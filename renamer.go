@@ -1,6 +1,7 @@
 package harvester
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,34 +10,36 @@ import (
 )
 
 type Renamer struct {
-	Regex  string // Example: "(\\d{4})-(\\d{2})-(\\d{2})"
-	Format string // Example: "$1$2$3.txt"
+	Regex  string       // Example: "(\\d{4})-(\\d{2})-(\\d{2})"
+	Format string       // Example: "$1$2$3.txt"
+	Logger *slog.Logger // nil for the logger of the job
 	NextProcessor
 }
 
-func (r *Renamer) Process(oldFilename string, reader io.Reader) error {
+func (r *Renamer) Process(ctx context.Context, oldFilename string, reader io.Reader) error {
+	logger := ContextLogger(ctx, r.Logger)
 
 	// Compile the regex
 	re, err := regexp.Compile(r.Regex)
 	if err != nil {
 		return fmt.Errorf("harvester: Failed to compile regex: %s", err)
 	}
-	slog.Debug("harvester: Compiled regex", slog.String("regex", r.Regex))
+	logger.Debug("harvester: Compiled regex", slog.String("regex", r.Regex))
 
 	// Match the regex
 	matches := re.FindStringSubmatch(oldFilename)
 	if len(matches) == 0 {
 		return fmt.Errorf("harvester: Failed to match %s", oldFilename)
 	}
-	slog.Debug("harvester: Matched regex", slog.Int("num_matches", len(matches)))
+	logger.Debug("harvester: Matched regex", slog.Int("num_matches", len(matches)))
 
 	// Replace the matches in the format string
 	newFilename := r.Format
 	for i, match := range matches {
 		newFilename = strings.Replace(newFilename, fmt.Sprintf("$%d", i), match, -1)
 	}
-	slog.Info("harvester: Renamed file", slog.String("old", oldFilename), slog.String("new", newFilename))
+	logger.Info("harvester: Renamed file", slog.String("old", oldFilename), slog.String("new", newFilename))
 
 	// Call next processor
-	return r.NextProcessor.Process(newFilename, reader)
+	return r.NextProcessor.Process(ctx, newFilename, reader)
 }
@@ -0,0 +1,132 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy determines how often and how fast failed steps are retried.
+// Only steps that can safely be repeated are retried, like connecting and renaming.
+type RetryPolicy struct {
+	MaxAttempts    int              // Total number of attempts including the first, 0 or 1 for no retries
+	InitialBackoff time.Duration    // Wait before the second attempt, default 1 second
+	MaxBackoff     time.Duration    // Upper limit of the wait between attempts, default 1 minute
+	Multiplier     float64          // Factor by which the wait grows after every attempt, default 2
+	Jitter         float64          // Random variation of the wait, example: 0.2 for plus or minus 20%
+	Retryable      func(error) bool // Decides which errors are retried, nil for the built-in classification
+}
+
+// WithRetryPolicy returns a context that carries the retry policy, for connectors that do not have their own.
+func WithRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey, policy)
+}
+
+// Retry calls fn until it succeeds, fails with an error that is not retryable, or the attempts are exhausted.
+// The policy is used if it is not nil, otherwise the policy in the context, otherwise fn is called once.
+// The classify function tells which errors are retryable, in addition to the errors of IsRetryable.
+// The attempt number, starting at 1, is passed to fn, so it can reconnect or check for earlier success.
+func Retry(ctx context.Context, policy *RetryPolicy, operation string, classify func(error) bool, fn func(attempt int) error) error {
+	logger := Logger(ctx)
+
+	if policy == nil {
+		policy, _ = ctx.Value(retryPolicyKey).(*RetryPolicy)
+	}
+	if policy == nil {
+		policy = &RetryPolicy{}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+
+		// Give up if the error is permanent, or if there are no attempts left
+		if !policy.retryable(err, classify) {
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			if policy.MaxAttempts > 1 {
+				logger.Error("harvester: Giving up after retries", slog.String("operation", operation), slog.Int("attempts", attempt), slog.Any("error", err))
+			}
+			return err
+		}
+
+		// Wait before the next attempt
+		backoff := policy.backoff(attempt)
+		logger.Warn(
+			"harvester: Retrying after transient error",
+			slog.String("operation", operation),
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", backoff),
+			slog.Any("error", err),
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether the error should be retried according to the policy.
+func (p *RetryPolicy) retryable(err error, classify func(error) bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err) || (classify != nil && classify(err))
+}
+
+// backoff returns the wait after the given attempt, with exponential growth and jitter.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = time.Second
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = time.Minute
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	backoff := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(max))
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryable reports whether the error is a transient network error, like a timeout or a dropped connection.
+func IsRetryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
@@ -0,0 +1,126 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", timeoutError{}, true},
+		{"wrapped timeout", fmt.Errorf("ftp: Failed to dial: %w", timeoutError{}), true},
+		{"dial error", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"broken pipe", fmt.Errorf("write: %w", syscall.EPIPE), true},
+		{"closed connection", fmt.Errorf("sftp: Failed to dial: %w", net.ErrClosed), true},
+		{"end of file", fmt.Errorf("ssh: handshake failed: %w", io.EOF), true},
+		{"unexpected end of file", io.ErrUnexpectedEOF, true},
+		{"not wrapped", fmt.Errorf("sftp: Failed to dial: %s", io.EOF), false},
+		{"missing file", os.ErrNotExist, false},
+		{"permission denied", fmt.Errorf("open: %w", syscall.EACCES), false},
+		{"other", errors.New("wrong password"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	transient := fmt.Errorf("read: %w", syscall.ECONNRESET)
+	permanent := errors.New("wrong password")
+	custom := errors.New("server busy")
+	tests := []struct {
+		name         string
+		policy       *RetryPolicy
+		classify     func(error) bool
+		errs         []error // error of every attempt, nil after the last
+		wantAttempts int
+		wantErr      error
+	}{
+		{"no policy", nil, nil, []error{transient}, 1, transient},
+		{"success", &RetryPolicy{MaxAttempts: 3}, nil, []error{nil}, 1, nil},
+		{"transient then success", &RetryPolicy{MaxAttempts: 3}, nil, []error{transient, transient}, 3, nil},
+		{"attempts exhausted", &RetryPolicy{MaxAttempts: 2}, nil, []error{transient, transient, transient}, 2, transient},
+		{"permanent", &RetryPolicy{MaxAttempts: 3}, nil, []error{permanent}, 1, permanent},
+		{"classified by the connector", &RetryPolicy{MaxAttempts: 3}, func(err error) bool { return err == custom }, []error{custom}, 2, nil},
+		{"classified by the policy", &RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return err == permanent }}, nil, []error{permanent}, 2, nil},
+		{"policy overrides", &RetryPolicy{MaxAttempts: 3, Retryable: func(error) bool { return false }}, nil, []error{transient}, 1, transient},
+		{"cancelled", &RetryPolicy{MaxAttempts: 3}, nil, []error{fmt.Errorf("read: %w", context.Canceled)}, 1, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.policy != nil {
+				tt.policy.InitialBackoff = time.Millisecond
+			}
+			attempts := 0
+			err := Retry(context.Background(), tt.policy, "test", tt.classify, func(attempt int) error {
+				attempts++
+				if attempt != attempts {
+					t.Errorf("attempt %d passed as %d", attempts, attempt)
+				}
+				if attempt > len(tt.errs) {
+					return nil
+				}
+				return tt.errs[attempt-1]
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Retry returned %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"defaults first", RetryPolicy{}, 1, time.Second},
+		{"defaults third", RetryPolicy{}, 3, 4 * time.Second},
+		{"defaults capped", RetryPolicy{}, 10, time.Minute},
+		{"multiplier", RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 3}, 3, 900 * time.Millisecond},
+		{"capped", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, 4, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.backoff(tt.attempt); got != tt.want {
+				t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+
+	// Jitter spreads the wait around the backoff, within the fraction
+	policy := RetryPolicy{InitialBackoff: time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(1); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("backoff with jitter 0.2 is %s, want between 800ms and 1.2s", got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package harvester
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxScheduleAttempts limits the search for a start time outside the blackout windows.
+const maxScheduleAttempts = 100000
+
+// Schedule determines when a job runs, using cron expressions and optional blackout windows.
+type Schedule struct {
+	Cron      []string   // Example: "0 6,18 * * 1-5" for every weekday at 06:00 and 18:00
+	Timezone  string     // Example: "Europe/Amsterdam", empty for the local timezone
+	Blackouts []Blackout // Periods in which the job never starts
+}
+
+// Blackout is a daily period in which the job does not start, for example during backups.
+type Blackout struct {
+	From     string         // Example: "23:00"
+	Until    string         // Example: "01:30", may be earlier than From to cross midnight
+	Weekdays []time.Weekday // Days on which the blackout starts, empty for every day
+}
+
+// compiledSchedule is a Schedule with parsed cron expressions, timezone and blackouts.
+type compiledSchedule struct {
+	crons     []cron.Schedule
+	location  *time.Location
+	blackouts []compiledBlackout
+}
+
+// compiledBlackout is a Blackout with times converted to minutes after midnight.
+type compiledBlackout struct {
+	from     int
+	until    int
+	weekdays map[time.Weekday]bool
+}
+
+// compile parses the cron expressions, timezone and blackouts.
+func (s *Schedule) compile() (*compiledSchedule, error) {
+
+	if len(s.Cron) == 0 {
+		return nil, fmt.Errorf("harvester: Schedule has no cron expressions")
+	}
+
+	// Load the timezone
+	location := time.Local
+	if s.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("harvester: Failed to load timezone %s: %s", s.Timezone, err)
+		}
+	}
+
+	// Parse the cron expressions
+	crons := make([]cron.Schedule, 0, len(s.Cron))
+	for _, expression := range s.Cron {
+		c, err := cron.ParseStandard(expression)
+		if err != nil {
+			return nil, fmt.Errorf("harvester: Failed to parse cron expression %s: %s", expression, err)
+		}
+		crons = append(crons, c)
+	}
+
+	// Parse the blackouts
+	blackouts := make([]compiledBlackout, 0, len(s.Blackouts))
+	for _, b := range s.Blackouts {
+		from, err := parseClock(b.From)
+		if err != nil {
+			return nil, err
+		}
+		until, err := parseClock(b.Until)
+		if err != nil {
+			return nil, err
+		}
+		weekdays := map[time.Weekday]bool{}
+		for _, d := range b.Weekdays {
+			weekdays[d] = true
+		}
+		blackouts = append(blackouts, compiledBlackout{from: from, until: until, weekdays: weekdays})
+	}
+
+	return &compiledSchedule{crons: crons, location: location, blackouts: blackouts}, nil
+}
+
+// next returns the first start time after t that is not inside a blackout window.
+func (s *compiledSchedule) next(t time.Time) (time.Time, error) {
+	t = t.In(s.location)
+	for i := 0; i < maxScheduleAttempts; i++ {
+
+		// Find the earliest time of all cron expressions
+		var earliest time.Time
+		for _, c := range s.crons {
+			n := c.Next(t)
+			if !n.IsZero() && (earliest.IsZero() || n.Before(earliest)) {
+				earliest = n
+			}
+		}
+		if earliest.IsZero() {
+			return time.Time{}, fmt.Errorf("harvester: Schedule has no next run after %s", t)
+		}
+
+		// Use it if it is outside the blackouts, otherwise continue searching after it
+		if !s.inBlackout(earliest) {
+			return earliest, nil
+		}
+		t = earliest
+	}
+	return time.Time{}, fmt.Errorf("harvester: Schedule has no next run outside the blackouts after %s", t)
+}
+
+// inBlackout reports whether t falls inside one of the blackout windows.
+func (s *compiledSchedule) inBlackout(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	for _, b := range s.blackouts {
+		if b.from <= b.until {
+			if minute >= b.from && minute < b.until && b.onDay(t.Weekday()) {
+				return true
+			}
+			continue
+		}
+
+		// The blackout crosses midnight, so the early part belongs to the blackout of the previous day
+		if minute >= b.from && b.onDay(t.Weekday()) {
+			return true
+		}
+		if minute < b.until && b.onDay((t.Weekday()+6)%7) {
+			return true
+		}
+	}
+	return false
+}
+
+// onDay reports whether the blackout starts on the weekday.
+func (b compiledBlackout) onDay(d time.Weekday) bool {
+	return len(b.weekdays) == 0 || b.weekdays[d]
+}
+
+// parseClock converts a time like "23:00" to the number of minutes after midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("harvester: Failed to parse time %s, expected HH:MM: %s", clock, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,140 @@
+package harvester
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Amsterdam, also where the system has no timezone database
+)
+
+// at returns the time in UTC, on a day in May 2024. The 17th is a Friday.
+func at(day int, hour int, minute int) time.Time {
+	return time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		after    time.Time
+		want     time.Time
+	}{
+		{
+			name:     "every hour",
+			schedule: Schedule{Cron: []string{"0 * * * *"}, Timezone: "UTC"},
+			after:    at(17, 10, 15),
+			want:     at(17, 11, 0),
+		},
+		{
+			name:     "earliest of several expressions",
+			schedule: Schedule{Cron: []string{"0 18 * * *", "30 12 * * *"}, Timezone: "UTC"},
+			after:    at(17, 10, 0),
+			want:     at(17, 12, 30),
+		},
+		{
+			name:     "weekdays skip the weekend",
+			schedule: Schedule{Cron: []string{"0 6 * * 1-5"}, Timezone: "UTC"},
+			after:    at(17, 7, 0),
+			want:     at(20, 6, 0),
+		},
+		{
+			name:     "timezone",
+			schedule: Schedule{Cron: []string{"0 6 * * *"}, Timezone: "Europe/Amsterdam"},
+			after:    at(17, 3, 0),
+			want:     at(17, 4, 0),
+		},
+		{
+			name:     "skips a blackout",
+			schedule: Schedule{Cron: []string{"0 * * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "11:00", Until: "13:00"}}},
+			after:    at(17, 10, 15),
+			want:     at(17, 13, 0),
+		},
+		{
+			name:     "blackout until is exclusive",
+			schedule: Schedule{Cron: []string{"30 * * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "11:00", Until: "11:30"}}},
+			after:    at(17, 11, 0),
+			want:     at(17, 11, 30),
+		},
+		{
+			name:     "blackout across midnight, evening",
+			schedule: Schedule{Cron: []string{"0 * * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "23:00", Until: "01:30"}}},
+			after:    at(17, 22, 30),
+			want:     at(18, 2, 0),
+		},
+		{
+			name:     "blackout across midnight, morning",
+			schedule: Schedule{Cron: []string{"*/15 * * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "23:00", Until: "01:30"}}},
+			after:    at(18, 0, 10),
+			want:     at(18, 1, 30),
+		},
+		{
+			name:     "blackout on other weekdays",
+			schedule: Schedule{Cron: []string{"0 12 * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "11:00", Until: "13:00", Weekdays: []time.Weekday{time.Saturday}}}},
+			after:    at(17, 10, 0),
+			want:     at(17, 12, 0),
+		},
+		{
+			name:     "blackout on the weekday",
+			schedule: Schedule{Cron: []string{"0 12 * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "11:00", Until: "13:00", Weekdays: []time.Weekday{time.Friday}}}},
+			after:    at(17, 10, 0),
+			want:     at(18, 12, 0),
+		},
+		{
+			name:     "the morning belongs to the blackout of the day before",
+			schedule: Schedule{Cron: []string{"0 1 * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "23:00", Until: "02:00", Weekdays: []time.Weekday{time.Friday}}}},
+			after:    at(17, 12, 0),
+			want:     at(19, 1, 0),
+		},
+		{
+			name:     "blackout from equals until is empty",
+			schedule: Schedule{Cron: []string{"0 12 * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "12:00", Until: "12:00"}}},
+			after:    at(17, 10, 0),
+			want:     at(17, 12, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.schedule.compile()
+			if err != nil {
+				t.Fatalf("compile: %s", err)
+			}
+			got, err := s.next(tt.after)
+			if err != nil {
+				t.Fatalf("next: %s", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("next after %s is %s, want %s", tt.after, got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNeverOutsideBlackout(t *testing.T) {
+	s, err := (&Schedule{Cron: []string{"0 12 * * *"}, Timezone: "UTC", Blackouts: []Blackout{{From: "00:00", Until: "23:59"}}}).compile()
+	if err != nil {
+		t.Fatalf("compile: %s", err)
+	}
+	if got, err := s.next(at(17, 0, 0)); err == nil {
+		t.Errorf("next returned %s, want an error because every run is in the blackout", got)
+	}
+}
+
+func TestScheduleCompileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+	}{
+		{"no cron expressions", Schedule{}},
+		{"invalid cron expression", Schedule{Cron: []string{"every day"}}},
+		{"too many fields", Schedule{Cron: []string{"0 0 6 * * *"}}},
+		{"unknown timezone", Schedule{Cron: []string{"0 6 * * *"}, Timezone: "Mars/Olympus_Mons"}},
+		{"invalid blackout start", Schedule{Cron: []string{"0 6 * * *"}, Blackouts: []Blackout{{From: "25:00", Until: "01:00"}}}},
+		{"invalid blackout end", Schedule{Cron: []string{"0 6 * * *"}, Blackouts: []Blackout{{From: "23:00", Until: "1am"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.schedule.compile(); err == nil {
+				t.Errorf("compile succeeded, want an error")
+			}
+		})
+	}
+}
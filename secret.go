@@ -0,0 +1,49 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Secret provides a password or other secret when it is needed, so it does not have to be written in the configuration.
+type Secret interface {
+	Secret(ctx context.Context) (string, error)
+}
+
+// EnvSecret is the name of an environment variable that holds the secret.
+type EnvSecret string
+
+// Secret returns the value of the environment variable.
+func (e EnvSecret) Secret(ctx context.Context) (string, error) {
+	value, ok := os.LookupEnv(string(e))
+	if !ok || value == "" {
+		return "", fmt.Errorf("harvester: Environment variable %s is not set", string(e))
+	}
+	return value, nil
+}
+
+// FileSecret is the path of a file that holds the secret, like the secrets that Docker and Kubernetes mount.
+type FileSecret string
+
+// Secret returns the contents of the file, without trailing newlines.
+func (f FileSecret) Secret(ctx context.Context) (string, error) {
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", fmt.Errorf("harvester: Failed to read secret: %s", err)
+	}
+	value := strings.TrimRight(string(b), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("harvester: Secret file %s is empty", string(f))
+	}
+	return value, nil
+}
+
+// SecretFunc turns a function into a Secret, for example to fetch the secret from a vault.
+type SecretFunc func(ctx context.Context) (string, error)
+
+// Secret calls the function.
+func (f SecretFunc) Secret(ctx context.Context) (string, error) {
+	return f(ctx)
+}
@@ -0,0 +1,114 @@
+package harvester
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Session is a connection to a server that the transfers of a job run share, so a run logs in once instead of
+// once for every file. Connectors open sessions with OpenSession, and return them with ReleaseSession.
+type Session interface {
+	// Check returns an error if the connection no longer works, for example because the server closed it.
+	Check() error
+
+	// Close closes the connection.
+	Close() error
+}
+
+// sessions holds the idle sessions of a job run, by the key of their connector.
+type sessions struct {
+	mu     sync.Mutex
+	idle   map[any][]Session
+	closed bool
+}
+
+// withSessions returns a context in which connectors share their sessions, and a function that closes the idle
+// sessions. The job calls it at the end of a run, after the processors have flushed.
+func withSessions(ctx context.Context) (context.Context, func()) {
+	s := &sessions{idle: map[any][]Session{}}
+	return context.WithValue(ctx, sessionsKey, s), func() {
+		s.mu.Lock()
+		idle := s.idle
+		s.idle = nil
+		s.closed = true
+		s.mu.Unlock()
+
+		count := 0
+		for _, list := range idle {
+			for _, session := range list {
+				session.Close()
+				count++
+			}
+		}
+		if count > 0 {
+			Logger(ctx).Debug("harvester: Closed idle sessions", slog.Int("sessions", count))
+		}
+	}
+}
+
+// OpenSession returns an idle session of the job run for the key, or opens a new one. An idle session is checked
+// before it is returned, and closed and replaced if it no longer works. The key identifies the connector, usually
+// its pointer, so only transfers that use the same connector share sessions. Outside a job run, OpenSession
+// always opens a new session. A session is used by one transfer at a time, so a run has at most as many
+// sessions per connector as it processes files in parallel.
+func OpenSession(ctx context.Context, key any, open func() (Session, error)) (Session, error) {
+	logger := Logger(ctx)
+
+	s, _ := ctx.Value(sessionsKey).(*sessions)
+	for s != nil {
+		session := s.take(key)
+		if session == nil {
+			break
+		}
+		if err := session.Check(); err != nil {
+			logger.Info("harvester: Idle session no longer works, reconnecting", slog.Any("error", err))
+			session.Close()
+			continue
+		}
+		logger.Debug("harvester: Reusing session")
+		return session, nil
+	}
+
+	return open()
+}
+
+// ReleaseSession returns a session to the job run, so the next transfer can use it. If reuse is false, because
+// the transfer failed and the connection may be in an unknown state, or if there is no job run, the session is
+// closed instead.
+func ReleaseSession(ctx context.Context, key any, session Session, reuse bool) {
+	if session == nil {
+		return
+	}
+	s, _ := ctx.Value(sessionsKey).(*sessions)
+	if reuse && s != nil && s.put(key, session) {
+		return
+	}
+	session.Close()
+}
+
+// take removes an idle session for the key, or returns nil if there is none.
+func (s *sessions) take(key any) Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.idle[key]
+	if len(list) == 0 {
+		return nil
+	}
+	session := list[len(list)-1]
+	s.idle[key] = list[:len(list)-1]
+	return session
+}
+
+// put adds an idle session for the key, and reports false if the run has already ended.
+func (s *sessions) put(key any, session Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.idle[key] = append(s.idle[key], session)
+	return true
+}
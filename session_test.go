@@ -0,0 +1,114 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeSession is a session that counts how often it is closed
+type fakeSession struct {
+	id     int
+	broken bool
+	closed int
+}
+
+func (s *fakeSession) Check() error {
+	if s.broken {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+func (s *fakeSession) Close() error {
+	s.closed++
+	return nil
+}
+
+// opener opens numbered sessions, and counts them
+type opener struct {
+	opened int
+}
+
+func (o *opener) open() (Session, error) {
+	o.opened++
+	return &fakeSession{id: o.opened}, nil
+}
+
+func TestSessions(t *testing.T) {
+	tests := []struct {
+		name       string
+		run        bool // within a job run
+		reuse      bool // the first transfer succeeded
+		otherKey   bool // the second transfer uses another connector
+		broken     bool // the connection breaks while idle
+		wantOpened int
+		wantClosed int // times the first session was closed before the end of the run
+	}{
+		{"reused", true, true, false, false, 1, 0},
+		{"failed transfer", true, false, false, false, 2, 1},
+		{"other connector", true, true, true, false, 2, 0},
+		{"broken while idle", true, true, false, true, 2, 1},
+		{"outside a run", false, true, false, false, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, closeSessions := context.Background(), func() {}
+			if tt.run {
+				ctx, closeSessions = withSessions(ctx)
+			}
+			o := &opener{}
+			first, _ := OpenSession(ctx, "sftp", o.open)
+			ReleaseSession(ctx, "sftp", first, tt.reuse)
+			first.(*fakeSession).broken = tt.broken
+
+			key := "sftp"
+			if tt.otherKey {
+				key = "ftp"
+			}
+			second, _ := OpenSession(ctx, key, o.open)
+			if o.opened != tt.wantOpened {
+				t.Errorf("opened %d sessions, want %d", o.opened, tt.wantOpened)
+			}
+			if closed := first.(*fakeSession).closed; closed != tt.wantClosed {
+				t.Errorf("first session was closed %d times, want %d", closed, tt.wantClosed)
+			}
+			if (second == first) != (tt.wantOpened == 1) {
+				t.Errorf("second transfer uses session %d", second.(*fakeSession).id)
+			}
+
+			// At the end of the run every session is closed once
+			ReleaseSession(ctx, key, second, true)
+			closeSessions()
+			for _, session := range []Session{first, second} {
+				if closed := session.(*fakeSession).closed; closed != 1 {
+					t.Errorf("session %d was closed %d times, want once", session.(*fakeSession).id, closed)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionsInParallel(t *testing.T) {
+	// A session is used by one transfer at a time, so transfers in progress each have their own
+	ctx, closeSessions := withSessions(context.Background())
+	o := &opener{}
+	first, _ := OpenSession(ctx, "sftp", o.open)
+	second, _ := OpenSession(ctx, "sftp", o.open)
+	if first == second || o.opened != 2 {
+		t.Errorf("two transfers share session %d, opened %d", first.(*fakeSession).id, o.opened)
+	}
+	ReleaseSession(ctx, "sftp", first, true)
+	ReleaseSession(ctx, "sftp", second, true)
+	if third, _ := OpenSession(ctx, "sftp", o.open); o.opened != 2 {
+		t.Errorf("opened session %d while two were idle", third.(*fakeSession).id)
+	}
+
+	// A session that is released after the run has ended is closed
+	closeSessions()
+	late := &fakeSession{}
+	ReleaseSession(ctx, "sftp", late, true)
+	if late.closed != 1 {
+		t.Errorf("session released after the run was closed %d times, want once", late.closed)
+	}
+}
@@ -0,0 +1,233 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// authMethods returns the authentication methods of the connector, and a function that closes the connection to
+// the ssh-agent, which must stay open until the handshake is done. All keys are offered in a single publickey
+// method, because the SSH client tries every method only once.
+func (c *Connector) authMethods(ctx context.Context, logger *slog.Logger) ([]ssh.AuthMethod, func(), error) {
+	var auths []ssh.AuthMethod
+
+	// Add password authentication
+	auths = addPasswordAuth(auths, c.Password)
+
+	// Add public key authentication, with the private key, the certificate and the keys in the ssh-agent
+	signers, closeAgent, err := c.signers(ctx, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+
+	// Add keyboard-interactive authentication
+	if c.KeyboardInteractive {
+		if c.Password == "" {
+			closeAgent()
+			return nil, nil, fmt.Errorf("sftp: KeyboardInteractive needs a Password to answer the prompts")
+		}
+		auths = append(auths, keyboardInteractive(c.Password))
+	}
+
+	return auths, closeAgent, nil
+}
+
+// addPasswordAuth adds password authentication to the list of authentication methods
+func addPasswordAuth(auths []ssh.AuthMethod, password string) []ssh.AuthMethod {
+	if password != "" {
+		return append(auths, ssh.Password(password))
+	}
+	return auths
+}
+
+// keyboardInteractive answers every prompt that does not echo, like "Password: ", with the password. Servers use
+// keyboard-interactive instead of password authentication when they let PAM ask for the password.
+func keyboardInteractive(password string) ssh.AuthMethod {
+	return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if echos[i] {
+				return nil, fmt.Errorf("sftp: Cannot answer keyboard-interactive prompt %q", question)
+			}
+			answers[i] = password
+		}
+		return answers, nil
+	})
+}
+
+// signers returns the keys to offer to the server: the certificate first, then the private key, then the keys in
+// the ssh-agent. The returned function closes the connection to the ssh-agent.
+func (c *Connector) signers(ctx context.Context, logger *slog.Logger) ([]ssh.Signer, func(), error) {
+	closeAgent := func() {}
+
+	// Parse the private key
+	var signers []ssh.Signer
+	key, err := c.privateKey(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != nil {
+		signers = append(signers, key)
+	}
+
+	// Get the keys from the ssh-agent
+	var agentSigners []ssh.Signer
+	if c.UseAgent {
+		agentSigners, closeAgent, err = c.agentSigners(logger)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Combine the certificate with the key it belongs to
+	cert, err := c.certificate(ctx, logger)
+	if err != nil {
+		closeAgent()
+		return nil, nil, err
+	}
+	if cert != nil {
+		certSigner, err := certSigner(cert, append(append([]ssh.Signer{}, signers...), agentSigners...))
+		if err != nil {
+			closeAgent()
+			return nil, nil, err
+		}
+		signers = append([]ssh.Signer{certSigner}, signers...)
+	}
+
+	return append(signers, agentSigners...), closeAgent, nil
+}
+
+// privateKey parses PrivateKey or PrivateKeyFile, or returns nil if neither is set
+func (c *Connector) privateKey(ctx context.Context) (ssh.Signer, error) {
+	var key []byte
+	var source string
+	switch {
+	case c.PrivateKey != nil:
+		secret, err := c.PrivateKey.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: Failed to get private key: %s", err)
+		}
+		key = []byte(secret)
+		source = "secret"
+	case c.PrivateKeyFile != "":
+		var err error
+		key, err = os.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: Failed to read private key from %s: %s", c.PrivateKeyFile, err)
+		}
+		source = c.PrivateKeyFile
+	default:
+		return nil, nil
+	}
+
+	// Parse the private key
+	var signer ssh.Signer
+	var err error
+	if c.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(c.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sftp: Failed to parse private key from %s: %s", source, err)
+	}
+	return signer, nil
+}
+
+// certificate parses Certificate or CertificateFile, or returns nil if neither is set
+func (c *Connector) certificate(ctx context.Context, logger *slog.Logger) (*ssh.Certificate, error) {
+	var data []byte
+	var source string
+	switch {
+	case c.Certificate != nil:
+		secret, err := c.Certificate.Secret(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: Failed to get certificate: %s", err)
+		}
+		data = []byte(secret)
+		source = "secret"
+	case c.CertificateFile != "":
+		var err error
+		data, err = os.ReadFile(c.CertificateFile)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: Failed to read certificate from %s: %s", c.CertificateFile, err)
+		}
+		source = c.CertificateFile
+	default:
+		return nil, nil
+	}
+
+	// Parse the certificate, which has the format of a line in authorized_keys
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: Failed to parse certificate from %s: %s", source, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("sftp: Failed to parse certificate from %s: %s is a public key, not a certificate", source, pub.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("sftp: Certificate from %s is not a user certificate", source)
+	}
+
+	// Warn about a certificate that the server will reject, because the error of the server does not say why
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter {
+		logger.Warn("sftp: Certificate is not valid yet", slog.String("source", source), slog.Time("valid_after", time.Unix(int64(cert.ValidAfter), 0)))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		logger.Warn("sftp: Certificate has expired", slog.String("source", source), slog.Time("valid_before", time.Unix(int64(cert.ValidBefore), 0)))
+	}
+	logger.Debug("sftp: Loaded certificate", slog.String("source", source), slog.String("key_id", cert.KeyId), slog.Any("principals", cert.ValidPrincipals))
+	return cert, nil
+}
+
+// certSigner combines the certificate with the signer of the key it certifies
+func certSigner(cert *ssh.Certificate, signers []ssh.Signer) (ssh.Signer, error) {
+	want := cert.Key.Marshal()
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), want) {
+			certSigner, err := ssh.NewCertSigner(cert, signer)
+			if err != nil {
+				return nil, fmt.Errorf("sftp: Failed to use certificate: %s", err)
+			}
+			return certSigner, nil
+		}
+	}
+	return nil, fmt.Errorf("sftp: Certificate %s does not belong to the private key or a key in the ssh-agent", cert.KeyId)
+}
+
+// agentSigners returns the keys in the ssh-agent, and a function that closes the connection to the agent
+func (c *Connector) agentSigners(logger *slog.Logger) ([]ssh.Signer, func(), error) {
+	socket := c.AgentSocket
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return nil, nil, fmt.Errorf("sftp: Failed to find ssh-agent, SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sftp: Failed to connect to ssh-agent at %s: %s", socket, err)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("sftp: Failed to get keys from ssh-agent at %s: %s", socket, err)
+	}
+	logger.Debug("sftp: Got keys from ssh-agent", slog.String("socket", socket), slog.Int("keys", len(signers)))
+
+	return signers, func() { conn.Close() }, nil
+}
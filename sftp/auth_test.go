@@ -0,0 +1,237 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// secret returns the value as a harvester.Secret
+func secret(value string) harvester.Secret {
+	return harvester.SecretFunc(func(ctx context.Context) (string, error) { return value, nil })
+}
+
+// newKey returns a new ed25519 key, and the private key in PEM format
+func newKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer, string) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, signer, string(pem.EncodeToMemory(block))
+}
+
+// newCertificate returns a certificate of the key, signed by a new certificate authority, in authorized_keys format
+func newCertificate(t *testing.T, key ssh.PublicKey, certType uint32) string {
+	t.Helper()
+	_, ca, _ := newKey(t)
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           "harvester",
+		ValidPrincipals: []string{"itsme"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(cert))
+}
+
+// startAgent serves an ssh-agent with the keys on a socket, and returns the path of the socket
+func startAgent(t *testing.T, keys ...ed25519.PrivateKey) string {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return socket
+}
+
+func TestSigners(t *testing.T) {
+	_, fileSigner, fileKey := newKey(t)
+	agentKey, agentSigner, _ := newKey(t)
+	_, otherSigner, _ := newKey(t)
+	socket := startAgent(t, agentKey)
+	fileKeyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(fileKeyPath, []byte(fileKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		connector Connector
+		want      []string // types of the keys, in order
+		wantKeys  []ssh.PublicKey
+		wantErr   bool
+	}{
+		{
+			name:      "nothing",
+			connector: Connector{},
+		},
+		{
+			name:      "private key",
+			connector: Connector{PrivateKey: secret(fileKey)},
+			want:      []string{ssh.KeyAlgoED25519},
+			wantKeys:  []ssh.PublicKey{fileSigner.PublicKey()},
+		},
+		{
+			name:      "private key file",
+			connector: Connector{PrivateKeyFile: fileKeyPath},
+			want:      []string{ssh.KeyAlgoED25519},
+			wantKeys:  []ssh.PublicKey{fileSigner.PublicKey()},
+		},
+		{
+			name:      "agent after private key",
+			connector: Connector{PrivateKey: secret(fileKey), UseAgent: true, AgentSocket: socket},
+			want:      []string{ssh.KeyAlgoED25519, ssh.KeyAlgoED25519},
+			wantKeys:  []ssh.PublicKey{fileSigner.PublicKey(), agentSigner.PublicKey()},
+		},
+		{
+			name:      "certificate of the private key first",
+			connector: Connector{PrivateKey: secret(fileKey), Certificate: secret(newCertificate(t, fileSigner.PublicKey(), ssh.UserCert))},
+			want:      []string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519},
+		},
+		{
+			name:      "certificate of a key in the agent",
+			connector: Connector{UseAgent: true, AgentSocket: socket, Certificate: secret(newCertificate(t, agentSigner.PublicKey(), ssh.UserCert))},
+			want:      []string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519},
+		},
+		{
+			name:      "certificate of another key",
+			connector: Connector{PrivateKey: secret(fileKey), Certificate: secret(newCertificate(t, otherSigner.PublicKey(), ssh.UserCert))},
+			wantErr:   true,
+		},
+		{
+			name:      "host certificate",
+			connector: Connector{PrivateKey: secret(fileKey), Certificate: secret(newCertificate(t, fileSigner.PublicKey(), ssh.HostCert))},
+			wantErr:   true,
+		},
+		{
+			name:      "public key instead of certificate",
+			connector: Connector{PrivateKey: secret(fileKey), Certificate: secret(string(ssh.MarshalAuthorizedKey(fileSigner.PublicKey())))},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid private key",
+			connector: Connector{PrivateKey: secret("not a key")},
+			wantErr:   true,
+		},
+		{
+			name:      "agent not running",
+			connector: Connector{UseAgent: true, AgentSocket: filepath.Join(t.TempDir(), "missing.sock")},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signers, closeAgent, err := tt.connector.signers(context.Background(), slog.Default())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("signers returned %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer closeAgent()
+
+			var types []string
+			for _, signer := range signers {
+				types = append(types, signer.PublicKey().Type())
+			}
+			if !reflect.DeepEqual(types, tt.want) {
+				t.Errorf("signers have types %v, want %v", types, tt.want)
+			}
+			for i, key := range tt.wantKeys {
+				if !reflect.DeepEqual(signers[i].PublicKey().Marshal(), key.Marshal()) {
+					t.Errorf("signer %d is %s, want %s", i, ssh.FingerprintSHA256(signers[i].PublicKey()), ssh.FingerprintSHA256(key))
+				}
+			}
+		})
+	}
+}
+
+func TestAgentWithoutSocket(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, _, err := (&Connector{UseAgent: true}).signers(context.Background(), slog.Default()); err == nil {
+		t.Errorf("signers succeeded without SSH_AUTH_SOCK")
+	}
+}
+
+func TestKeyboardInteractive(t *testing.T) {
+	tests := []struct {
+		name      string
+		questions []string
+		echos     []bool
+		want      []string
+		wantErr   bool
+	}{
+		{"password", []string{"Password: "}, []bool{false}, []string{"s3cret"}, false},
+		{"no questions", nil, nil, []string{}, false},
+		{"two passwords", []string{"Password: ", "Password again: "}, []bool{false, false}, []string{"s3cret", "s3cret"}, false},
+		{"echoed prompt", []string{"Username: "}, []bool{true}, nil, true},
+	}
+	challenge := keyboardInteractive("s3cret").(ssh.KeyboardInteractiveChallenge)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answers, err := challenge("", "", tt.questions, tt.echos)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("challenge returned %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(answers, tt.want) {
+				t.Errorf("answers are %q, want %q", answers, tt.want)
+			}
+		})
+	}
+
+	// Keyboard-interactive needs a password to answer with
+	if _, _, err := (&Connector{KeyboardInteractive: true}).authMethods(context.Background(), slog.Default()); err == nil {
+		t.Errorf("authMethods succeeded with KeyboardInteractive and without a Password")
+	}
+	methods, closeAgent, err := (&Connector{Password: "s3cret", KeyboardInteractive: true}).authMethods(context.Background(), slog.Default())
+	if err != nil {
+		t.Fatalf("authMethods: %s", err)
+	}
+	defer closeAgent()
+	if len(methods) != 2 {
+		t.Errorf("got %d methods, want password and keyboard-interactive", len(methods))
+	}
+}
@@ -1,7 +1,11 @@
 package sftp
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"log/slog"
+	"sync"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -10,15 +14,56 @@ import (
 type connection struct {
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
+	logger     *slog.Logger
 }
 
-func (c *connection) Close() {
+// missing reports whether the file does not exist on the server. Other errors of the server are returned, so a
+// failure to look is not mistaken for a missing file.
+func (c *connection) missing(path string) (bool, error) {
+	_, err := c.sftpClient.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	return false, err
+}
+
+// bind lets the connection log with the logger of the transfer that uses it, which is not the transfer that
+// connected once the connection is reused
+func (c *connection) bind(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// closeOnCancel closes the connection when the context is cancelled, so a read or write that waits for a stalled
+// server returns. Closing the remote file is not enough, because the server has to reply to that too.
+// Call the returned function when the data is transferred, it returns the context's error if the connection was
+// closed.
+func (c *connection) closeOnCancel(ctx context.Context) func() error {
+	stop := context.AfterFunc(ctx, func() {
+		c.logger.Warn("sftp: Closing the connection to interrupt the transfer")
+		c.sshClient.Close()
+	})
+	return sync.OnceValue(func() error {
+		if !stop() {
+			return ctx.Err()
+		}
+		return nil
+	})
+}
+
+// Check asks the server for the working directory, to find out if it closed the connection while it was idle
+func (c *connection) Check() error {
+	_, err := c.sftpClient.Getwd()
+	return err
+}
+
+func (c *connection) Close() error {
 	if c.sftpClient != nil {
 		c.sftpClient.Close()
-		slog.Info("sftp: Closed SFTP client")
+		c.logger.Info("sftp: Closed SFTP client")
 	}
 	if c.sshClient != nil {
 		c.sshClient.Close()
-		slog.Info("sftp: Closed SSH connection")
+		c.logger.Info("sftp: Closed SSH connection")
 	}
+	return nil
 }
@@ -1,123 +1,224 @@
 package sftp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"net"
 
+	"github.com/gwijnja/harvester"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 // Connector is a structure that holds the configuration for an SFTP connection.
 type Connector struct {
 	Host                  string
 	Port                  int
-	FailIfHostKeyChanged  bool
-	FailIfHostKeyNotFound bool
+	HostKeyFingerprints   []string // Accept only host keys with these fingerprints, example: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"
+	KnownHostsFile        string   // Path of the known_hosts file, empty for $HOME/.ssh/known_hosts, ignored with HostKeyFingerprints
+	TrustOnFirstUse       bool     // Add the host key of a host that is not in the known_hosts file to it, instead of failing
+	InsecureIgnoreHostKey bool     // Accept any host key, which allows man-in-the-middle attacks
+	FailIfHostKeyChanged  bool     // Deprecated: host keys are always verified, unless InsecureIgnoreHostKey is set
+	FailIfHostKeyNotFound bool     // Deprecated: unknown hosts always fail, unless TrustOnFirstUse or InsecureIgnoreHostKey is set
 	Username              string
 	Password              string
+	KeyboardInteractive   bool // Answer the password prompts of keyboard-interactive authentication with Password
 	PrivateKeyFile        string
+	PrivateKey            harvester.Secret // Private key in PEM format, instead of PrivateKeyFile, like harvester.EnvSecret("SFTP_KEY")
 	Passphrase            string
+	CertificateFile       string                 // OpenSSH user certificate of the private key or a key in the ssh-agent, like id_ed25519-cert.pub
+	Certificate           harvester.Secret       // Certificate instead of CertificateFile, in the same format
+	UseAgent              bool                   // Authenticate with the keys in the ssh-agent
+	AgentSocket           string                 // Socket of the ssh-agent, empty for $SSH_AUTH_SOCK
+	Retry                 *harvester.RetryPolicy // nil for the retry policy of the job
+	Logger                *slog.Logger           // nil for the logger of the job
 }
 
-// connect establishes a connection to the SFTP server
-func (c *Connector) connect() (*connection, error) {
+// system describes the SFTP server, for identifying the source of a transfer
+func (c *Connector) system() string {
+	return fmt.Sprintf("sftp://%s@%s:%d", c.Username, c.Host, c.Port)
+}
+
+// open returns an idle connection of the job run, or connects to the SFTP server. Return it with release.
+func (c *Connector) open(ctx context.Context) (*connection, error) {
+	s, err := harvester.OpenSession(ctx, c, func() (harvester.Session, error) {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := s.(*connection)
+	conn.bind(harvester.ContextLogger(ctx, c.Logger))
+	return conn, nil
+}
+
+// release returns the connection to the job run, so the next transfer can use it. If err is not nil,
+// the connection may be in an unknown state, so it is closed instead.
+func (c *Connector) release(ctx context.Context, conn *connection, err error) {
+	harvester.ReleaseSession(ctx, c, conn, err == nil)
+}
 
-	var auths []ssh.AuthMethod
+// connect establishes a connection to the SFTP server, retrying transient failures according to the retry policy
+func (c *Connector) connect(ctx context.Context) (*connection, error) {
+	var conn *connection
+	err := harvester.Retry(ctx, c.Retry, "sftp: connect", isRetryable, func(int) error {
+		var err error
+		conn, err = c.connectOnce(ctx)
+		return err
+	})
+	return conn, err
+}
 
-	// Add password authentication
-	auths = addPasswordAuth(auths, c.Password)
+// connectOnce establishes a connection to the SFTP server
+func (c *Connector) connectOnce(ctx context.Context) (*connection, error) {
+	logger := harvester.ContextLogger(ctx, c.Logger)
 
-	// Add private key authentication
-	auths, err := addPrivateKeyAuth(auths, c.PrivateKeyFile, c.Passphrase)
+	// Prepare the authentication methods
+	auths, closeAgent, err := c.authMethods(ctx, logger)
 	if err != nil {
 		return nil, err
 	}
+	defer closeAgent()
 
-	// Create a new SSH client
-	config := ssh.ClientConfig{
-		User:            c.Username,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		// HostKeyAlgorithms: []string{ssh.KeyAlgoDSA},
+	// Verify the host key
+	addr := fmt.Sprintf("%s:%d", c.Host, c.Port)
+	hostKeyCallback, hostKeyAlgorithms, err := c.hostKeyCallback(addr, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	// Overwrite the HostKeyCallback if FailIfHostKeyChanged is set
-	if c.FailIfHostKeyChanged {
-		path := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
-		hostKeyCallback, err := knownhosts.New(path)
-		if err != nil {
-			if c.FailIfHostKeyNotFound {
-				return nil, fmt.Errorf("sftp: Failed to open known_hosts file: %s", err)
-			}
-			slog.Warn("sftp: Failed to open known_hosts file, continuing without host key verification", slog.Any("err", err))
-		} else {
-			config.HostKeyCallback = hostKeyCallback
-		}
+	// Create a new SSH client
+	config := ssh.ClientConfig{
+		User:              c.Username,
+		Auth:              auths,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
 	}
 
 	// Connect to the SSH server
-	addr := fmt.Sprintf("%s:%d", c.Host, c.Port)
-	sshClient, err := ssh.Dial("tcp", addr, &config)
+	sshClient, err := dial(ctx, addr, &config)
 	if err != nil {
-		return nil, fmt.Errorf("sftp: Failed to dial: %s", err)
+		return nil, err
 	}
-	slog.Info("sftp: Connected", slog.String("address", addr), slog.String("username", c.Username))
+	logger.Info("sftp: Connected", slog.String("address", addr), slog.String("username", c.Username))
 
 	// Create a new SFTP client
 	sftpClient, err := sftp.NewClient(sshClient)
 	if err != nil {
-		slog.Error("sftp: Failed to create SFTP client, closing SSH connection")
+		logger.Error("sftp: Failed to create SFTP client, closing SSH connection")
 		sshClient.Close()
-		slog.Info("sftp: Closed SSH connection")
-		return nil, fmt.Errorf("sftp: Failed to create SFTP client: %s", err)
+		logger.Info("sftp: Closed SSH connection")
+		return nil, fmt.Errorf("sftp: Failed to create SFTP client: %w", err)
 	}
-	slog.Info("sftp: Created SFTP client")
+	logger.Info("sftp: Created SFTP client")
 
 	// Return both, because both must be closed at the same time
 	return &connection{
 		sshClient:  sshClient,
 		sftpClient: sftpClient,
+		logger:     logger,
 	}, nil
 }
 
-// addPasswordAuth adds password authentication to the list of authentication methods
-func addPasswordAuth(auths []ssh.AuthMethod, password string) []ssh.AuthMethod {
-	if password != "" {
-		return append(auths, ssh.Password(password))
-	}
-	return auths
-}
-
-// addPrivateKeyAuth adds private key authentication to the list of authentication methods
-func addPrivateKeyAuth(auths []ssh.AuthMethod, privateKeyFile string, passphrase string) ([]ssh.AuthMethod, error) {
+// dial opens a TCP connection and performs the SSH handshake, both of which are aborted when the context is cancelled
+func dial(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
 
-	// Return the list of authentication methods if no private key file is provided
-	if privateKeyFile == "" {
-		return auths, nil
+	// Open the TCP connection
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: Failed to dial: %w", err)
 	}
 
-	// Read the private key from the file
-	key, err := os.ReadFile(privateKeyFile)
+	// Close the TCP connection if the context is cancelled during the handshake
+	stop := context.AfterFunc(ctx, func() {
+		netConn.Close()
+	})
+	defer stop()
+
+	// Perform the SSH handshake
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
 	if err != nil {
-		return auths, fmt.Errorf("sftp: Failed to read private key from %s: %s", privateKeyFile, err)
+		netConn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("sftp: Failed to dial: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("sftp: Failed to dial: %w", err)
 	}
 
-	// Parse the private key
-	var signer ssh.Signer
-	if passphrase != "" {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
-	} else {
-		signer, err = ssh.ParsePrivateKey(key)
-	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	// Return an error if the private key could not be parsed
+// rename moves a file on the server, retrying transient failures according to the retry policy.
+// Before every retry it reconnects, and checks whether the previous attempt succeeded after all,
+// so a file is never moved twice. The previous attempt succeeded if the file is gone, even if it is no longer
+// at the destination either, because the receiver may already have picked it up.
+// The connection is replaced when it reconnects.
+func (c *Connector) rename(ctx context.Context, conn **connection, from string, to string) error {
+	return harvester.Retry(ctx, c.Retry, "sftp: rename", isRetryable, func(attempt int) error {
+		if attempt > 1 {
+			if err := c.reconnect(ctx, conn); err != nil {
+				return err
+			}
+			missing, err := (*conn).missing(from)
+			if err != nil {
+				return fmt.Errorf("sftp: Failed to stat %s: %w", from, err)
+			}
+			if missing {
+				harvester.ContextLogger(ctx, c.Logger).Info("sftp: Previous rename succeeded", slog.String("from", from), slog.String("to", to))
+				return nil
+			}
+		}
+		return (*conn).sftpClient.Rename(from, to)
+	})
+}
+
+// remove deletes a file from the server, retrying transient failures according to the retry policy.
+// Before every retry it reconnects, and checks whether the file still exists.
+func (c *Connector) remove(ctx context.Context, conn **connection, path string) error {
+	return harvester.Retry(ctx, c.Retry, "sftp: remove", isRetryable, func(attempt int) error {
+		if attempt > 1 {
+			if err := c.reconnect(ctx, conn); err != nil {
+				return err
+			}
+			missing, err := (*conn).missing(path)
+			if err != nil {
+				return fmt.Errorf("sftp: Failed to stat %s: %w", path, err)
+			}
+			if missing {
+				return nil
+			}
+		}
+		return (*conn).sftpClient.Remove(path)
+	})
+}
+
+// reconnect replaces the connection with a new one
+func (c *Connector) reconnect(ctx context.Context, conn **connection) error {
+	(*conn).Close()
+	newConn, err := c.connectOnce(ctx)
 	if err != nil {
-		return auths, fmt.Errorf("sftp: Failed to parse private key from %s: %s", privateKeyFile, err)
+		return err
 	}
+	*conn = newConn
+	return nil
+}
 
-	return append(auths, ssh.PublicKeys(signer)), nil
+// isRetryable reports whether the SFTP error means that the connection was lost.
+func isRetryable(err error) bool {
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, sftp.ErrSSHFxNoConnection) {
+		return true
+	}
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.FxCode()
+		return code == sftp.ErrSSHFxConnectionLost || code == sftp.ErrSSHFxNoConnection
+	}
+	return false
 }
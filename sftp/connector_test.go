@@ -0,0 +1,35 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection lost", sftp.ErrSSHFxConnectionLost, true},
+		{"no connection", sftp.ErrSSHFxNoConnection, true},
+		{"wrapped", fmt.Errorf("sftp: Failed to move file: %w", sftp.ErrSSHFxConnectionLost), true},
+		{"status connection lost", &sftp.StatusError{Code: 7}, true},
+		{"status no connection", &sftp.StatusError{Code: 6}, true},
+		{"status no such file", &sftp.StatusError{Code: 2}, false},
+		{"status permission denied", &sftp.StatusError{Code: 3}, false},
+		{"missing file", os.ErrNotExist, false},
+		{"other", errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
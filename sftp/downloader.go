@@ -1,7 +1,9 @@
 package sftp
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -17,25 +19,32 @@ type Downloader struct {
 	Regex               string
 	MaxFiles            int
 	DeleteAfterDownload bool
+	Sidecar             harvester.Sidecar // If set, a file is only processed with its sidecar, and must match it
+	TempDir             string            // Directory for staging files with a Sidecar larger than MaxMemory, empty for the default directory of the OS
+	MaxMemory           int64             // Files with a Sidecar up to this size are staged in memory, 0 for harvester.DefaultMaxMemory, -1 to always use a temporary file
+	harvester.Quarantine
 	harvester.NextProcessor
 }
 
 // List returns a list of files in the ToLoad directory that match the regex.
-func (d *Downloader) List() ([]string, error) {
+func (d *Downloader) List(ctx context.Context) (filenames []string, err error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
 
-	// Connect to the SFTP server
-	conn, err := d.Connector.connect()
+	// Connect to the SFTP server, or reuse a connection of the job run
+	conn, err := d.Connector.open(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	defer func() {
+		d.Connector.release(ctx, conn, err)
+	}()
 
 	// List the files in the ToLoad directory, relative to the current root.
 	ff, err := conn.sftpClient.ReadDir(d.ToLoad)
 	if err != nil {
 		return nil, fmt.Errorf("sftp: Failed to read directory %s: %s", d.ToLoad, err)
 	}
-	slog.Info("sftp: Read directory", slog.Int("entries", len(ff)))
+	logger.Info("sftp: Read directory", slog.Int("entries", len(ff)))
 
 	// Exclude directories, we are only interested in files
 	ff = excludeDirectories(ff)
@@ -46,66 +55,177 @@ func (d *Downloader) List() ([]string, error) {
 		return nil, fmt.Errorf("sftp: Failed to compile regex %s: %s", d.Regex, err)
 	}
 	files := []string{}
+	all := []string{}
 	for _, f := range ff {
+		all = append(all, f.Name())
 		if !re.MatchString(f.Name()) {
-			slog.Warn("sftp: Skipping non-matching file", slog.String("filename", f.Name()))
+			logger.Warn("sftp: Skipping non-matching file", slog.String("filename", f.Name()))
 			continue
 		}
 		files = append(files, f.Name())
-		slog.Info("sftp: Found file", slog.String("filename", f.Name()))
+		logger.Info("sftp: Found file", slog.String("filename", f.Name()))
 	}
 
-	return harvester.SortAndLimit(files, d.MaxFiles), nil
+	// Only process files that have a sidecar, and skip the sidecars themselves
+	files = harvester.FilterSidecars(ctx, d.Sidecar, files, all)
+
+	return harvester.SortAndLimit(ctx, files, d.MaxFiles), nil
 }
 
 // Process downloads the file from the SFTP server and calls the next processor.
-func (d *Downloader) Process(filename string) error {
+func (d *Downloader) Process(ctx context.Context, filename string) (err error) {
+
+	// Start the transfer, so all log lines can be correlated, and record the result in the audit trail
+	toloadPath := filepath.Join(d.ToLoad, filename)
+	ctx = harvester.StartTransfer(ctx, d.system(), toloadPath)
+	logger := harvester.ContextLogger(ctx, d.Logger)
+	defer func() {
+		if err != nil {
+			d.Failed(ctx, err, d.quarantine)
+		} else {
+			d.Succeeded(ctx)
+		}
+		harvester.FinishTransfer(ctx, err)
+	}()
 
-	// Connect to the SFTP server
-	conn, err := d.Connector.connect()
+	// Connect to the SFTP server, or reuse a connection of the job run
+	conn, err := d.Connector.open(ctx)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		conn.Close()
+		d.Connector.release(ctx, conn, err)
 	}()
 
 	// Open the file
-	toloadPath := filepath.Join(d.ToLoad, filename)
 	remoteFile, err := conn.sftpClient.Open(toloadPath)
 	if err != nil {
 		return fmt.Errorf("sftp: Failed to open remote file %s: %s", toloadPath, err)
 	}
-	slog.Info("sftp: Opened remote file", slog.String("filename", filename))
+	logger.Info("sftp: Opened remote file", slog.String("filename", filename))
+	if info, err := remoteFile.Stat(); err == nil {
+		harvester.SetSourceModTime(ctx, info.ModTime())
+		harvester.SetSourceMode(ctx, info.Mode().Perm())
+	}
 	defer func() {
 		remoteFile.Close()
-		slog.Info("sftp: Closed remote file", slog.String("filename", filename))
+		logger.Info("sftp: Closed remote file", slog.String("filename", filename))
 	}()
 
-	// Call the next processor
-	err = d.NextProcessor.Process(filename, remoteFile)
+	// Call the next processor, and verify the file against its sidecar. A read that waits for a stalled server is
+	// interrupted by closing the connection when the job is cancelled.
+	stop := conn.closeOnCancel(ctx)
+	if d.Sidecar == nil {
+		err = d.NextProcessor.Process(ctx, filename, remoteFile)
+	} else {
+		err = d.verify(ctx, conn, filename, remoteFile)
+	}
+	if cancelErr := stop(); cancelErr != nil {
+		err = fmt.Errorf("sftp: Interrupted download of %s: %w", toloadPath, cancelErr)
+	}
 	if err != nil {
 		return err
 	}
 
-	// Check if the file should be deleted
+	// Delete the file or move it to the Loaded directory, with its sidecar
+	if err := d.done(ctx, &conn, filename); err != nil {
+		return err
+	}
+	if d.Sidecar != nil {
+		return d.done(ctx, &conn, filename+d.Sidecar.Suffix())
+	}
+	return nil
+}
+
+// verify reads the sidecar of the file, and presents the file to the next processor once it is verified.
+// The file is staged while it is verified, so a file that does not match is never delivered.
+func (d *Downloader) verify(ctx context.Context, conn *connection, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Read the sidecar
+	sidecarPath := filepath.Join(d.ToLoad, filename+d.Sidecar.Suffix())
+	f, err := conn.sftpClient.Open(sidecarPath)
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to open sidecar %s: %s", sidecarPath, err)
+	}
+	content, err := harvester.ReadSidecar(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	logger.Debug("sftp: Read sidecar", slog.String("path", sidecarPath), slog.Int("bytes", len(content)))
+
+	// Stage and verify the file, and only then present it to the next processor
+	staged := &harvester.SpillBuffer{TempDir: d.TempDir, MaxMemory: d.MaxMemory, Logger: logger}
+	defer staged.Close()
+	if err := harvester.VerifySidecar(ctx, d.Sidecar, filename, sidecarPath, content, r, staged); err != nil {
+		return err
+	}
+	return d.NextProcessor.Process(ctx, filename, staged.Reader())
+}
+
+// done deletes a remote file from ToLoad, or moves it to Loaded
+func (d *Downloader) done(ctx context.Context, conn **connection, filename string) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	toloadPath := filepath.Join(d.ToLoad, filename)
 	if d.DeleteAfterDownload {
-		err = conn.sftpClient.Remove(toloadPath)
-		if err != nil {
+		if err := d.remove(ctx, conn, toloadPath); err != nil {
 			return fmt.Errorf("sftp: Failed to delete remote file %s: %s", toloadPath, err)
 		}
-		slog.Info("sftp: Deleted remote file", slog.String("filename", filename))
+		logger.Info("sftp: Deleted remote file", slog.String("filename", filename))
 		return nil
 	}
 
 	// Move the file to the Loaded directory
 	loadedPath := filepath.Join(d.Loaded, filename)
-	err = conn.sftpClient.Rename(toloadPath, loadedPath)
-	if err != nil {
+	if err := d.rename(ctx, conn, toloadPath, loadedPath); err != nil {
 		return fmt.Errorf("sftp: Failed to move remote file %s to %s: %s", toloadPath, loadedPath, err)
 	}
-	slog.Info("sftp: Moved remote file", slog.String("from", toloadPath), slog.String("to", loadedPath))
+	logger.Info("sftp: Moved remote file", slog.String("from", toloadPath), slog.String("to", loadedPath))
+	return nil
+}
+
+// quarantine moves a file that keeps failing to the Error directory, and writes the sidecar next to it.
+// The connection of the failed transfer may be broken and has been closed, so it uses another connection.
+func (d *Downloader) quarantine(ctx context.Context, errorPath string, sidecarPath string, sidecar []byte) (err error) {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	conn, err := d.Connector.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		d.Connector.release(ctx, conn, err)
+	}()
 
+	from := filepath.Join(d.ToLoad, filepath.Base(errorPath))
+	if err := d.rename(ctx, &conn, from, errorPath); err != nil {
+		return fmt.Errorf("sftp: Failed to move remote file %s to %s: %s", from, errorPath, err)
+	}
+	logger.Info("sftp: Moved remote file", slog.String("from", from), slog.String("to", errorPath))
+
+	// The sidecar of the sender goes along, so the file can be put back with it
+	if d.Sidecar != nil {
+		from, to := from+d.Sidecar.Suffix(), errorPath+d.Sidecar.Suffix()
+		if err := d.rename(ctx, &conn, from, to); err != nil {
+			return fmt.Errorf("sftp: Failed to move remote sidecar %s to %s: %s", from, to, err)
+		}
+		logger.Info("sftp: Moved remote sidecar", slog.String("from", from), slog.String("to", to))
+	}
+
+	f, err := conn.sftpClient.Create(sidecarPath)
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to create sidecar %s: %s", sidecarPath, err)
+	}
+	_, err = f.Write(sidecar)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to write sidecar %s: %s", sidecarPath, err)
+	}
+	logger.Info("sftp: Wrote sidecar", slog.String("path", sidecarPath))
 	return nil
 }
 
@@ -0,0 +1,193 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMu prevents concurrent connections from adding the same host to a known_hosts file twice
+var knownHostsMu sync.Mutex
+
+// hostKeyCallback returns the callback that verifies the host key of the server, and the host key algorithms
+// to ask the server for, so it presents a key that is in the known_hosts file. Nil algorithms means the defaults.
+func (c *Connector) hostKeyCallback(addr string, logger *slog.Logger) (ssh.HostKeyCallback, []string, error) {
+
+	// Accept any host key, only if explicitly asked for
+	if c.InsecureIgnoreHostKey {
+		logger.Warn("sftp: Host key verification is disabled", slog.String("address", addr))
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	// Accept only the pinned host keys
+	if len(c.HostKeyFingerprints) > 0 {
+		callback, err := pinnedHostKeys(c.HostKeyFingerprints, logger)
+		return callback, nil, err
+	}
+
+	// Find the known_hosts file
+	path, err := c.knownHostsFile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Create the file for trust on first use, so the first host can be added
+	if c.TrustOnFirstUse {
+		if err := createKnownHostsFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Parse the known_hosts file
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sftp: Failed to open known_hosts file %s: %s", path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		err := callback(hostname, remote, key)
+		if err == nil {
+			logger.Debug("sftp: Verified host key", slog.String("fingerprint", fingerprint), slog.String("known_hosts", path))
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return fmt.Errorf("sftp: Failed to verify host key of %s: %s", hostname, err)
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("sftp: Host key of %s has changed, the server presented %s %s, which is not in %s", hostname, key.Type(), fingerprint, path)
+		}
+		if !c.TrustOnFirstUse {
+			return fmt.Errorf("sftp: Host key of %s is unknown, the server presented %s %s, which is not in %s", hostname, key.Type(), fingerprint, path)
+		}
+		return trustOnFirstUse(path, hostname, remote, key, logger)
+	}, hostKeyAlgorithms(callback, addr), nil
+}
+
+// knownHostsFile returns the path of the known_hosts file
+func (c *Connector) knownHostsFile() (string, error) {
+	if c.KnownHostsFile != "" {
+		return c.KnownHostsFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("sftp: Failed to find known_hosts file, set KnownHostsFile: %s", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// createKnownHostsFile creates an empty known_hosts file and its directory, if they do not exist
+func createKnownHostsFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("sftp: Failed to create directory of known_hosts file %s: %s", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to create known_hosts file %s: %s", path, err)
+	}
+	return f.Close()
+}
+
+// trustOnFirstUse adds the host key of an unknown host to the known_hosts file. The file is read again while it
+// is locked, because a concurrent connection may have added the host in the meantime, possibly with another key.
+func trustOnFirstUse(path string, hostname string, remote net.Addr, key ssh.PublicKey, logger *slog.Logger) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to open known_hosts file %s: %s", path, err)
+	}
+	var keyErr *knownhosts.KeyError
+	if err := callback(hostname, remote, key); err == nil {
+		return nil
+	} else if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+		return fmt.Errorf("sftp: Host key of %s has changed, the server presented %s %s, which is not in %s", hostname, key.Type(), ssh.FingerprintSHA256(key), path)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to open known_hosts file %s: %s", path, err)
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("sftp: Failed to add host key to known_hosts file %s: %s", path, err)
+	}
+	logger.Warn("sftp: Trusted host key on first use", slog.String("host", hostname), slog.String("type", key.Type()), slog.String("fingerprint", ssh.FingerprintSHA256(key)), slog.String("known_hosts", path))
+	return nil
+}
+
+// pinnedHostKeys returns a callback that only accepts host keys with one of the SHA256 fingerprints
+func pinnedHostKeys(fingerprints []string, logger *slog.Logger) (ssh.HostKeyCallback, error) {
+	pinned := make(map[string]bool, len(fingerprints))
+	for _, f := range fingerprints {
+		normalized := strings.TrimRight(strings.TrimSpace(f), "=")
+		if !strings.HasPrefix(normalized, "SHA256:") {
+			return nil, fmt.Errorf("sftp: Host key fingerprint %s is not in SHA256 format, example: SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s", f)
+		}
+		pinned[normalized] = true
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if !pinned[fingerprint] {
+			return fmt.Errorf("sftp: Host key of %s is not pinned, the server presented %s %s", hostname, key.Type(), fingerprint)
+		}
+		logger.Debug("sftp: Verified pinned host key", slog.String("fingerprint", fingerprint))
+		return nil
+	}, nil
+}
+
+// hostKeyAlgorithms returns the algorithms of the keys in the known_hosts file for the address, so a server with
+// several host keys presents one that is known. It returns nil if the host is not known, for the defaults.
+func hostKeyAlgorithms(callback ssh.HostKeyCallback, addr string) []string {
+
+	// The known_hosts package has no lookup, but a key that never matches makes it return the known keys
+	var keyErr *knownhosts.KeyError
+	if err := callback(addr, &net.TCPAddr{}, lookupKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, known := range keyErr.Want {
+		for _, algorithm := range algorithmsForKeyType(known.Key.Type()) {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
+
+// algorithmsForKeyType returns the signature algorithms that servers use with a type of host key
+func algorithmsForKeyType(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// lookupKey is a public key that matches no key in a known_hosts file
+type lookupKey struct{}
+
+func (lookupKey) Type() string    { return "lookup" }
+func (lookupKey) Marshal() []byte { return []byte("lookup") }
+func (lookupKey) Verify(_ []byte, _ *ssh.Signature) error {
+	return errors.New("sftp: Lookup key cannot verify")
+}
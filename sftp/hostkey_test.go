@@ -0,0 +1,181 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newHostKey returns a new ed25519 host key
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPinnedHostKeys(t *testing.T) {
+	key := newHostKey(t)
+	fingerprint := ssh.FingerprintSHA256(key)
+	tests := []struct {
+		name         string
+		fingerprints []string
+		wantErr      bool // creating the callback fails
+		wantReject   bool // the callback rejects the key
+	}{
+		{"pinned", []string{fingerprint}, false, false},
+		{"one of several", []string{ssh.FingerprintSHA256(newHostKey(t)), fingerprint}, false, false},
+		{"with padding and spaces", []string{" " + fingerprint + "= "}, false, false},
+		{"other key", []string{ssh.FingerprintSHA256(newHostKey(t))}, false, true},
+		{"md5 fingerprint", []string{ssh.FingerprintLegacyMD5(key)}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Connector{HostKeyFingerprints: tt.fingerprints}
+			callback, algorithms, err := c.hostKeyCallback("sftp.example.com:22", slog.Default())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hostKeyCallback returned %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if algorithms != nil {
+				t.Errorf("algorithms are %v, want the defaults", algorithms)
+			}
+			err = callback("sftp.example.com:22", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}, key)
+			if (err != nil) != tt.wantReject {
+				t.Errorf("callback returned %v, want rejected %v", err, tt.wantReject)
+			}
+		})
+	}
+}
+
+func TestKnownHosts(t *testing.T) {
+	known := newHostKey(t)
+	other := newHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	line := knownhosts.Line([]string{"sftp.example.com"}, known) + "\n"
+
+	tests := []struct {
+		name       string
+		file       string // contents of the known_hosts file, empty for no file
+		tofu       bool
+		host       string
+		key        ssh.PublicKey
+		wantReject bool
+		wantAdded  bool // the key is added to the file
+	}{
+		{"known", line, false, "sftp.example.com:22", known, false, false},
+		{"changed", line, false, "sftp.example.com:22", other, true, false},
+		{"unknown", line, false, "other.example.com:22", other, true, false},
+		{"other port", line, false, "sftp.example.com:2222", known, true, false},
+		{"trust unknown on first use", line, true, "other.example.com:22", other, false, true},
+		{"trust first host on first use", "", true, "sftp.example.com:22", known, false, true},
+		{"changed with trust on first use", line, true, "sftp.example.com:22", other, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+			if tt.file != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := &Connector{KnownHostsFile: path, TrustOnFirstUse: tt.tofu}
+			callback, _, err := c.hostKeyCallback(tt.host, slog.Default())
+			if err != nil {
+				t.Fatalf("hostKeyCallback: %s", err)
+			}
+			if err := callback(tt.host, remote, tt.key); (err != nil) != tt.wantReject {
+				t.Fatalf("callback returned %v, want rejected %v", err, tt.wantReject)
+			}
+
+			b, _ := os.ReadFile(path)
+			if added := string(b) != tt.file; added != tt.wantAdded {
+				t.Errorf("known_hosts file is %q, want the key added %v", b, tt.wantAdded)
+			}
+
+			// A trusted key is known from now on, without trust on first use
+			if tt.wantAdded {
+				c := &Connector{KnownHostsFile: path}
+				callback, _, err := c.hostKeyCallback(tt.host, slog.Default())
+				if err != nil {
+					t.Fatalf("hostKeyCallback: %s", err)
+				}
+				if err := callback(tt.host, remote, tt.key); err != nil {
+					t.Errorf("trusted key is rejected: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestKnownHostsFileMissing(t *testing.T) {
+	c := &Connector{KnownHostsFile: filepath.Join(t.TempDir(), "known_hosts")}
+	if _, _, err := c.hostKeyCallback("sftp.example.com:22", slog.Default()); err == nil {
+		t.Errorf("hostKeyCallback succeeded without a known_hosts file")
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := ssh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Key := newHostKey(t)
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	lines := []string{
+		knownhosts.Line([]string{"rsa.example.com"}, rsaKey),
+		knownhosts.Line([]string{"ed25519.example.com"}, ed25519Key),
+		knownhosts.Line([]string{"both.example.com"}, ed25519Key),
+		knownhosts.Line([]string{"both.example.com"}, rsaKey),
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want []string
+	}{
+		{"rsa.example.com:22", []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+		{"ed25519.example.com:22", []string{ssh.KeyAlgoED25519}},
+		{"both.example.com:22", []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+		{"unknown.example.com:22", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			_, algorithms, err := (&Connector{KnownHostsFile: path}).hostKeyCallback(tt.addr, slog.Default())
+			if err != nil {
+				t.Fatalf("hostKeyCallback: %s", err)
+			}
+			if !reflect.DeepEqual(algorithms, tt.want) {
+				t.Errorf("algorithms are %v, want %v", algorithms, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package sftp
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,20 +12,34 @@ import (
 
 type Uploader struct {
 	Connector
-	Transmit string
-	ToLoad   string
+	Transmit   string
+	ToLoad     string
+	VerifySize bool // Compare the size of the transmit file with the bytes sent, before moving it to ToLoad
+	VerifyHash bool // Read the transmit file back and compare its hashes with the data sent, before moving it to ToLoad
 }
 
 func (u *Uploader) SetNext(next harvester.FileWriter) {}
 
-func (u *Uploader) Process(filename string, r io.Reader) error {
+func (u *Uploader) Process(ctx context.Context, filename string, r io.Reader) (err error) {
+	logger := harvester.ContextLogger(ctx, u.Logger)
 
-	// Connect to the SFTP server
-	conn, err := u.Connector.connect()
+	// Connect to the SFTP server, or reuse a connection of the job run
+	conn, err := u.Connector.open(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer func() {
+		u.Connector.release(ctx, conn, err)
+	}()
+
+	// A file from an archive is named with the directories it is in, so create them in Transmit and ToLoad
+	if dir := filepath.Dir(filename); dir != "." {
+		for _, parent := range []string{filepath.Join(u.Transmit, dir), filepath.Join(u.ToLoad, dir)} {
+			if err := conn.sftpClient.MkdirAll(parent); err != nil {
+				return fmt.Errorf("sftp: Failed to create remote directory %s: %s", parent, err)
+			}
+		}
+	}
 
 	// Open the file to write to
 	transmitPath := filepath.Join(u.Transmit, filename)
@@ -32,27 +47,94 @@ func (u *Uploader) Process(filename string, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("sftp: Failed to create remote file %s: %s", transmitPath, err)
 	}
-	slog.Info("sftp: Opened remote file", slog.String("path", transmitPath))
+	logger.Info("sftp: Opened remote file", slog.String("path", transmitPath))
 
 	defer func() {
 		f.Close()
-		slog.Info("sftp: Closed remote file", slog.String("path", transmitPath))
+		logger.Info("sftp: Closed remote file", slog.String("path", transmitPath))
+	}()
+
+	// Interrupt a write or read that waits for a stalled server by closing the connection when the job is cancelled
+	stop := conn.closeOnCancel(ctx)
+	defer func() {
+		if cancelErr := stop(); cancelErr != nil {
+			err = fmt.Errorf("sftp: Interrupted upload of %s: %w", transmitPath, cancelErr)
+		}
 	}()
 
 	// Call AuditCopy to write the file
-	_, err = harvester.AuditCopy(f, r)
+	hop, err := harvester.AuditCopyHop(ctx, "sftp.Uploader", f, r)
 	if err != nil {
+		f.Close()
+		conn.sftpClient.Remove(transmitPath)
+		logger.Info("sftp: Removed remote file", slog.String("path", transmitPath))
+		return err
+	}
+	logger.Info("sftp: Copied file", slog.String("path", transmitPath))
+
+	// Close the file, so the server has all data before it is verified
+	err = f.Close()
+	if err != nil {
+		conn.sftpClient.Remove(transmitPath)
+		logger.Info("sftp: Removed remote file", slog.String("path", transmitPath))
+		return fmt.Errorf("sftp: Failed to close remote file %s: %s", transmitPath, err)
+	}
+
+	// Verify the transmit file
+	err = u.verify(ctx, conn, transmitPath, hop)
+	if err != nil {
+		conn.sftpClient.Remove(transmitPath)
+		logger.Info("sftp: Removed remote file", slog.String("path", transmitPath))
+		return err
+	}
+
+	// The data is transferred, so the move is not interrupted anymore
+	if err := stop(); err != nil {
 		return err
 	}
-	slog.Info("sftp: Copied file", slog.String("path", transmitPath))
 
 	// Move the file to the toload directory
 	toLoadPath := filepath.Join(u.ToLoad, filename)
-	err = conn.sftpClient.Rename(transmitPath, toLoadPath)
+	err = u.rename(ctx, &conn, transmitPath, toLoadPath)
 	if err != nil {
 		return fmt.Errorf("sftp: Failed to move file from %s to %s: %s", transmitPath, toLoadPath, err)
 	}
-	slog.Info("sftp: Moved file", slog.String("from", transmitPath), slog.String("to", toLoadPath))
+	logger.Info("sftp: Moved file", slog.String("from", transmitPath), slog.String("to", toLoadPath))
+	harvester.SetDestination(ctx, u.system(), toLoadPath)
+
+	return nil
+}
+
+// verify compares the size and hashes of the remote file with the data that was sent, if enabled.
+// The pkg/sftp client does not support the check-file extension, so hashes are verified by reading the file back.
+func (u *Uploader) verify(ctx context.Context, conn *connection, path string, sent harvester.AuditHop) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
+
+	// Compare the size
+	if u.VerifySize {
+		info, err := conn.sftpClient.Stat(path)
+		if err != nil {
+			return fmt.Errorf("sftp: Failed to stat remote file %s: %s", path, err)
+		}
+		if err := harvester.VerifySize(sent.Bytes, info.Size()); err != nil {
+			return fmt.Errorf("sftp: Size of remote file %s is wrong: %w", path, err)
+		}
+		logger.Info("sftp: Verified size", slog.String("path", path), slog.Int64("bytes", info.Size()))
+	}
+
+	// Compare the hashes
+	if u.VerifyHash {
+		f, err := conn.sftpClient.Open(path)
+		if err != nil {
+			return fmt.Errorf("sftp: Failed to open remote file %s for verification: %s", path, err)
+		}
+		defer f.Close()
+
+		if err := harvester.VerifyHash(ctx, sent.Hashes, f); err != nil {
+			return fmt.Errorf("sftp: Failed to verify hash of remote file %s: %w", path, err)
+		}
+		logger.Info("sftp: Verified hashes", slog.String("path", path))
+	}
 
 	return nil
 }
@@ -0,0 +1,135 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// MaxSidecarSize is the largest sidecar that a reader accepts, because sidecars are read into memory.
+const MaxSidecarSize = 1 << 20
+
+// Sidecar is a small file that is delivered next to a data file, named after it with a suffix, like a checksum
+// or a detached signature. Writers publish sidecars with sidecar.Writer, and readers verify them before they
+// process the data file.
+type Sidecar interface {
+	// Suffix is appended to the name of the data file to name its sidecar, like ".sha256".
+	Suffix() string
+
+	// Create returns a builder that receives the data of the file, and creates its sidecar.
+	Create(ctx context.Context, filename string) (SidecarBuilder, error)
+
+	// Verify returns a verifier that receives the data of the file, and checks it against the sidecar.
+	Verify(ctx context.Context, filename string, sidecar []byte) (SidecarVerifier, error)
+}
+
+// SidecarBuilder receives the data of a file, and creates its sidecar.
+type SidecarBuilder interface {
+	io.Writer
+
+	// Finish returns the sidecar of the data that was written. A non-nil error means the data is incomplete:
+	// the builder releases its resources and returns the error. Finish must be called exactly once.
+	Finish(err error) ([]byte, error)
+}
+
+// SidecarVerifier receives the data of a file, and checks it against the sidecar.
+type SidecarVerifier interface {
+	io.Writer
+
+	// Finish returns an error that wraps ErrVerificationFailed if the data that was written does not match the sidecar.
+	// A non-nil error means the data is incomplete: the verifier releases its resources and returns the error.
+	// Finish must be called exactly once.
+	Finish(err error) error
+}
+
+// FilterSidecars returns the files that have a sidecar, and leaves out the sidecars themselves. A file without its
+// sidecar is left for the next run, because the sender may still be uploading the sidecar. The filenames are the
+// files that a reader found, and all are the names of all files in the directory, so a sidecar is found even if its
+// name does not match the regex of the reader. If sidecar is nil, the filenames are returned unchanged.
+func FilterSidecars(ctx context.Context, sidecar Sidecar, filenames []string, all []string) []string {
+	if sidecar == nil {
+		return filenames
+	}
+	logger := Logger(ctx)
+
+	suffix := sidecar.Suffix()
+	exists := make(map[string]bool, len(all))
+	for _, name := range all {
+		exists[name] = true
+	}
+
+	filtered := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		if strings.HasSuffix(filename, suffix) {
+			logger.Debug("harvester: Skipping sidecar", slog.String("filename", filename))
+			continue
+		}
+		if !exists[filename+suffix] {
+			logger.Warn("harvester: Waiting for sidecar", slog.String("filename", filename), slog.String("sidecar", filename+suffix))
+			continue
+		}
+		filtered = append(filtered, filename)
+	}
+	return filtered
+}
+
+// ReadSidecar reads a sidecar, and fails if it is larger than MaxSidecarSize.
+func ReadSidecar(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MaxSidecarSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("harvester: Failed to read sidecar: %w", err)
+	}
+	if len(b) > MaxSidecarSize {
+		return nil, fmt.Errorf("harvester: Sidecar is larger than %d bytes", MaxSidecarSize)
+	}
+	return b, nil
+}
+
+// VerifySidecar writes the data of a file to staged, and checks it against the sidecar, which is named name. The file
+// is staged because the next processor may deliver what it reads before the end, like an unpacker that presents the
+// files of an archive one by one, or a processor that stops reading early. The caller presents the staged file to
+// the next processor only if VerifySidecar returns nil, so a file that does not match is never delivered.
+// The result is recorded in the audit trail.
+func VerifySidecar(ctx context.Context, sidecar Sidecar, filename string, name string, content []byte, r io.Reader, staged *SpillBuffer) error {
+	logger := Logger(ctx)
+
+	verifier, err := sidecar.Verify(ctx, filename, content)
+	if err != nil {
+		return err
+	}
+	// A verifier that stops early, because it already knows the result, fails the write, and Finish returns the reason
+	verifying := &verifierWriter{verifier: verifier}
+	if _, err := io.Copy(io.MultiWriter(staged, verifying), NewContextReader(ctx, r)); err != nil && verifying.err == nil {
+		verifier.Finish(err)
+		return fmt.Errorf("harvester: Failed to stage %s for verification: %w", filename, err)
+	}
+	err = verifier.Finish(nil)
+	if err == nil && verifying.err != nil {
+		err = verifying.err
+	}
+	if err != nil {
+		SetAuditDetail(ctx, "sidecar", path.Base(name)+" (mismatch)")
+		logger.Error("harvester: Sidecar does not match", slog.String("sidecar", name), slog.Any("error", err))
+		return err
+	}
+	SetAuditDetail(ctx, "sidecar", path.Base(name)+" (verified)")
+	logger.Info("harvester: Verified sidecar", slog.String("sidecar", name), slog.Int64("bytes", staged.Size()), slog.Bool("spilled", staged.Spilled()))
+	return nil
+}
+
+// verifierWriter writes to a verifier, and records why it stopped
+type verifierWriter struct {
+	verifier SidecarVerifier
+	err      error
+}
+
+func (v *verifierWriter) Write(p []byte) (int, error) {
+	n, err := v.verifier.Write(p)
+	if err != nil {
+		v.err = err
+	}
+	return n, err
+}
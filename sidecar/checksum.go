@@ -0,0 +1,131 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"path"
+	"strings"
+
+	"github.com/gwijnja/harvester"
+)
+
+// Checksum is a sidecar with the checksum of a file, in the format of sha256sum and the other coreutils tools:
+// the hexadecimal digest, two spaces and the filename. Verify also accepts a binary mode marker ("*filename"),
+// a digest without a filename, and the BSD format "SHA256 (filename) = digest".
+type Checksum struct {
+	Algorithm string // Hash algorithm, like "sha256" or "md5", empty for "sha256". The suffix is "." followed by the algorithm.
+}
+
+// algorithm returns the hash algorithm, or the default
+func (c *Checksum) algorithm() string {
+	if c.Algorithm == "" {
+		return "sha256"
+	}
+	return c.Algorithm
+}
+
+// Suffix returns the suffix of the sidecar, like ".sha256"
+func (c *Checksum) Suffix() string {
+	return "." + c.algorithm()
+}
+
+// Create returns a builder that calculates the checksum of the file
+func (c *Checksum) Create(ctx context.Context, filename string) (harvester.SidecarBuilder, error) {
+	h, err := harvester.NewHash(c.algorithm())
+	if err != nil {
+		return nil, err
+	}
+	return &checksumBuilder{Hash: h, algorithm: c.algorithm(), filename: path.Base(filename)}, nil
+}
+
+// Verify returns a verifier that compares the checksum of the file with the checksum in the sidecar
+func (c *Checksum) Verify(ctx context.Context, filename string, sidecar []byte) (harvester.SidecarVerifier, error) {
+	h, err := harvester.NewHash(c.algorithm())
+	if err != nil {
+		return nil, err
+	}
+	expected, err := parseChecksum(sidecar, path.Base(filename), h.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &checksumVerifier{Hash: h, algorithm: c.algorithm(), expected: expected}, nil
+}
+
+// checksumBuilder writes the checksum of the data in the coreutils format
+type checksumBuilder struct {
+	hash.Hash
+	algorithm string
+	filename  string
+}
+
+// Finish returns the line with the checksum and the filename
+func (b *checksumBuilder) Finish(err error) ([]byte, error) {
+	return b.finishDigest(fmt.Sprintf("%x", b.Sum(nil)), err)
+}
+
+// digestAlgorithm returns the hash algorithm of the checksum
+func (b *checksumBuilder) digestAlgorithm() string {
+	return b.algorithm
+}
+
+// finishDigest returns the line with the hexadecimal digest, which was calculated elsewhere, and the filename
+func (b *checksumBuilder) finishDigest(digest string, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s  %s\n", digest, b.filename)), nil
+}
+
+// checksumVerifier compares the checksum of the data with the expected checksum
+type checksumVerifier struct {
+	hash.Hash
+	algorithm string
+	expected  []byte
+}
+
+// Finish returns an error if the checksums differ
+func (v *checksumVerifier) Finish(err error) error {
+	if err != nil {
+		return err
+	}
+	actual := v.Sum(nil)
+	if !bytes.Equal(actual, v.expected) {
+		return fmt.Errorf("%w: sidecar has %s %x, but the file has %x", harvester.ErrVerificationFailed, v.algorithm, v.expected, actual)
+	}
+	return nil
+}
+
+// parseChecksum finds the checksum of the file in the sidecar. A sidecar may list several files, in which case
+// the line with the filename is used. Blank lines and comments are ignored.
+func parseChecksum(sidecar []byte, filename string, size int) ([]byte, error) {
+	for _, line := range strings.Split(string(sidecar), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split the line in the digest and the filename, which may be missing
+		digest, name := line, ""
+		if open := strings.Index(line, " ("); open >= 0 && strings.Contains(line, ") = ") {
+			end := strings.LastIndex(line, ") = ")
+			name, digest = line[open+2:end], line[end+4:]
+		} else if i := strings.IndexAny(line, " \t"); i >= 0 {
+			digest, name = line[:i], strings.TrimLeft(line[i:], " \t")
+			name = strings.TrimPrefix(name, "*")
+		}
+		if name != "" && path.Base(name) != filename {
+			continue
+		}
+
+		// Decode the digest, which may be in upper or lower case
+		b, err := hex.DecodeString(digest)
+		if err != nil || len(b) != size {
+			return nil, fmt.Errorf("sidecar: Invalid checksum for %s: %q", filename, digest)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("%w: sidecar has no checksum for %s", harvester.ErrVerificationFailed, filename)
+}
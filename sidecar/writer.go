@@ -0,0 +1,97 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/gwijnja/harvester"
+)
+
+// Writer presents a file to the next processor in the chain, and then a sidecar of the file, like a checksum
+// or a detached signature. The sidecar is named after the file with the suffix of the Sidecar, and is delivered
+// after the file, so a receiver that waits for the sidecar knows the file is complete. The sidecar is delivered
+// through the same writer as the file, so it is written atomically as well.
+// The sidecar is a transfer of its own in the audit trail, with the transfer of the file as its parent.
+type Writer struct {
+	harvester.NextProcessor
+	Sidecar harvester.Sidecar // Sidecar to create, like &sidecar.Checksum{} or &pgp.Signature{}
+	Logger  *slog.Logger      // nil for the logger of the job
+}
+
+// Process writes the file and then its sidecar to the next processor
+func (w *Writer) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, w.Logger)
+
+	if w.Sidecar == nil {
+		return fmt.Errorf("sidecar: No Sidecar configured")
+	}
+	builder, err := w.Sidecar.Create(ctx, filename)
+	if err != nil {
+		return err
+	}
+
+	// A checksum is one of the hashes of the copy, so the data is not hashed twice. Other builders, like a
+	// signature, receive a copy of the data.
+	copyCtx, dst := ctx, io.Writer(builder)
+	digester, isDigester := builder.(digestBuilder)
+	if isDigester {
+		copyCtx, dst = harvester.WithHashes(ctx, withAlgorithm(harvester.Hashes(ctx), digester.digestAlgorithm())), io.Discard
+	}
+
+	// Stream the file to the next processor
+	var hop harvester.AuditHop
+	err = harvester.Stream(
+		func(pw io.Writer) error {
+			var err error
+			hop, err = harvester.AuditCopyHop(copyCtx, "sidecar.Writer", io.MultiWriter(pw, dst), r)
+			if err != nil {
+				return fmt.Errorf("sidecar: Failed to copy file: %w", err)
+			}
+			logger.Debug("sidecar: Copied file", slog.String("filename", filename), slog.Int64("bytes", hop.Bytes))
+			return nil
+		},
+		func(pr io.Reader) error {
+			return w.NextProcessor.Process(ctx, filename, pr)
+		},
+	)
+	var content []byte
+	if isDigester {
+		content, err = digester.finishDigest(hop.Hashes[digester.digestAlgorithm()], err)
+	} else {
+		content, err = builder.Finish(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Deliver the sidecar as a transfer of its own, so it does not change the audit record of the file
+	name := filename + w.Sidecar.Suffix()
+	harvester.SetAuditDetail(ctx, "sidecar", name)
+	sidecarCtx := harvester.StartDerivedTransfer(ctx, name)
+	err = w.NextProcessor.Process(sidecarCtx, name, bytes.NewReader(content))
+	harvester.FinishTransfer(sidecarCtx, err)
+	if err != nil {
+		return fmt.Errorf("sidecar: Failed to deliver sidecar %s: %w", name, err)
+	}
+	logger.Info("sidecar: Delivered sidecar", slog.String("filename", name), slog.Int("bytes", len(content)))
+	return nil
+}
+
+// digestBuilder is a builder that only needs a digest of the data, which AuditCopy calculates along with its hashes
+type digestBuilder interface {
+	harvester.SidecarBuilder
+	digestAlgorithm() string
+	finishDigest(digest string, err error) ([]byte, error)
+}
+
+// withAlgorithm returns the hash algorithms with the algorithm added, if it is not one of them
+func withAlgorithm(algorithms []string, algorithm string) []string {
+	if slices.Contains(algorithms, algorithm) {
+		return algorithms
+	}
+	return append(slices.Clip(algorithms), algorithm)
+}
@@ -0,0 +1,108 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the files it receives
+type recorder struct {
+	files map[string]string
+	fail  error
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	if r.fail != nil {
+		return r.fail
+	}
+	if r.files == nil {
+		r.files = map[string]string{}
+	}
+	r.files[filename] = string(b)
+	return nil
+}
+
+func TestWriterChecksum(t *testing.T) {
+	data := strings.Repeat("id;amount\n1;10\n", 1000)
+	tests := []struct {
+		name       string
+		algorithm  string
+		hashes     []string // hashes of the job, nil for the default
+		wantHashes []string // hashes of the hop of the writer
+		wantDigest string
+	}{
+		{"default", "", nil, []string{"sha1", "sha256"}, fmt.Sprintf("%x", sha256.Sum256([]byte(data)))},
+		{"hash of the job", "sha1", nil, []string{"sha1"}, fmt.Sprintf("%x", sha1.Sum([]byte(data)))},
+		{"other hashes", "md5", []string{"sha1", "sha256"}, []string{"md5", "sha1", "sha256"}, fmt.Sprintf("%x", md5.Sum([]byte(data)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := harvester.StartRun(context.Background())
+			if tt.hashes != nil {
+				ctx = harvester.WithHashes(ctx, tt.hashes)
+			}
+			ctx = harvester.StartTransfer(ctx, "local", "/in/report.csv")
+			next := &recorder{}
+			w := &Writer{Sidecar: &Checksum{Algorithm: tt.algorithm}}
+			w.SetNext(next)
+
+			if err := w.Process(ctx, "report.csv", strings.NewReader(data)); err != nil {
+				t.Fatalf("Process: %s", err)
+			}
+			suffix := w.Sidecar.Suffix()
+			want := map[string]string{
+				"report.csv":          data,
+				"report.csv" + suffix: tt.wantDigest + "  report.csv\n",
+			}
+			if !reflect.DeepEqual(next.files, want) {
+				t.Errorf("delivered %q, want %q", next.files, want)
+			}
+
+			// The data is copied once, and the hop of the copy has the checksum too
+			hops := harvester.TransferFromContext(ctx).Hops()
+			if len(hops) != 1 {
+				t.Fatalf("transfer has %d hops, want 1", len(hops))
+			}
+			var hashes []string
+			for algorithm := range hops[0].Hashes {
+				hashes = append(hashes, algorithm)
+			}
+			sort.Strings(hashes)
+			if !reflect.DeepEqual(hashes, tt.wantHashes) {
+				t.Errorf("hop has hashes %v, want %v", hashes, tt.wantHashes)
+			}
+		})
+	}
+}
+
+func TestWriterNoSidecarOnFailure(t *testing.T) {
+	failed := errors.New("disk full")
+	next := &recorder{fail: failed}
+	w := &Writer{Sidecar: &Checksum{}}
+	w.SetNext(next)
+
+	ctx := harvester.StartTransfer(harvester.StartRun(context.Background()), "local", "/in/report.csv")
+	if err := w.Process(ctx, "report.csv", strings.NewReader("id;amount\n")); !errors.Is(err, failed) {
+		t.Errorf("Process returned %v, want %v", err, failed)
+	}
+	if len(next.files) != 0 {
+		t.Errorf("delivered %q, want nothing", next.files)
+	}
+}
@@ -0,0 +1,108 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// exactSidecar is a sidecar that contains the data of the file itself, and keeps the last verifier it returned
+type exactSidecar struct {
+	verifier *exactVerifier
+}
+
+func (s *exactSidecar) Suffix() string { return ".exact" }
+
+func (s *exactSidecar) Create(ctx context.Context, filename string) (SidecarBuilder, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *exactSidecar) Verify(ctx context.Context, filename string, sidecar []byte) (SidecarVerifier, error) {
+	s.verifier = &exactVerifier{want: sidecar}
+	return s.verifier, nil
+}
+
+// exactVerifier compares the data with the sidecar, and stops as soon as the data is longer
+type exactVerifier struct {
+	want     []byte
+	got      bytes.Buffer
+	err      error
+	finished int
+}
+
+func (v *exactVerifier) Write(p []byte) (int, error) {
+	if v.got.Len()+len(p) > len(v.want) {
+		v.err = fmt.Errorf("%w: data is longer", ErrVerificationFailed)
+		return 0, v.err
+	}
+	return v.got.Write(p)
+}
+
+func (v *exactVerifier) Finish(err error) error {
+	v.finished++
+	if err != nil {
+		return err
+	}
+	if v.err != nil {
+		return v.err
+	}
+	if !bytes.Equal(v.got.Bytes(), v.want) {
+		return fmt.Errorf("%w: data differs", ErrVerificationFailed)
+	}
+	return nil
+}
+
+func TestVerifySidecar(t *testing.T) {
+	lost := errors.New("connection lost")
+	tests := []struct {
+		name      string
+		sidecar   string
+		r         io.Reader
+		maxMemory int64
+		wantErr   error
+	}{
+		{"match", "id;amount\n1;10\n", strings.NewReader("id;amount\n1;10\n"), 0, nil},
+		{"match spilled", "id;amount\n1;10\n", strings.NewReader("id;amount\n1;10\n"), -1, nil},
+		{"empty", "", strings.NewReader(""), 0, nil},
+		{"different", "id;amount\n1;10\n", strings.NewReader("id;amount\n1;99\n"), 0, ErrVerificationFailed},
+		{"shorter", "id;amount\n1;10\n", strings.NewReader("id;amount\n"), 0, ErrVerificationFailed},
+		{"verifier stops early", "id;amount\n", io.MultiReader(strings.NewReader("id;amount\n"), strings.NewReader("1;10\n")), 0, ErrVerificationFailed},
+		{"read error", "id;amount\n1;10\n", io.MultiReader(strings.NewReader("id;"), iotest.ErrReader(lost)), 0, lost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := StartTransfer(StartRun(context.Background()), "local", "/in/report.csv")
+			sidecar := &exactSidecar{}
+			staged := &SpillBuffer{TempDir: t.TempDir(), MaxMemory: tt.maxMemory}
+			defer staged.Close()
+
+			err := VerifySidecar(ctx, sidecar, "report.csv", "/in/report.csv.exact", []byte(tt.sidecar), tt.r, staged)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifySidecar returned %v, want %v", err, tt.wantErr)
+			}
+			if sidecar.verifier.finished != 1 {
+				t.Errorf("Finish was called %d times, want once", sidecar.verifier.finished)
+			}
+			if err != nil {
+				return
+			}
+
+			// The staged file is what was verified
+			b, err := io.ReadAll(staged.Reader())
+			if err != nil {
+				t.Fatalf("reading staged file: %s", err)
+			}
+			if string(b) != tt.sidecar {
+				t.Errorf("staged %q, want %q", b, tt.sidecar)
+			}
+			if staged.Spilled() != (tt.maxMemory < 0 && len(b) > 0) {
+				t.Errorf("spilled is %v with MaxMemory %d", staged.Spilled(), tt.maxMemory)
+			}
+		})
+	}
+}
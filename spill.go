@@ -0,0 +1,101 @@
+package harvester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// DefaultMaxMemory is the size up to which a SpillBuffer keeps data in memory, if MaxMemory is not set.
+const DefaultMaxMemory = 32 * 1024 * 1024
+
+// SpillBuffer keeps the data written to it in memory until it grows beyond MaxMemory, then moves it to a temporary
+// file in TempDir. Processors use it to stage a file when they need random access or the size before they can start,
+// like a zip decompressor, or a tar packer that writes the size in front of the data. Close removes the temporary file.
+type SpillBuffer struct {
+	TempDir   string       // Directory for the temporary file, empty for the default directory of the OS
+	MaxMemory int64        // Size up to which the data is kept in memory, 0 for DefaultMaxMemory, -1 to always use a temporary file
+	Logger    *slog.Logger // nil for the default logger
+
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+// Write appends the data to the buffer or the temporary file.
+func (s *SpillBuffer) Write(p []byte) (int, error) {
+
+	// Spill the buffer to disk when it is about to grow too large
+	maxMemory := s.MaxMemory
+	if maxMemory == 0 {
+		maxMemory = DefaultMaxMemory
+	}
+	if s.file == nil && int64(s.buf.Len()+len(p)) > maxMemory {
+		f, err := os.CreateTemp(s.TempDir, "harvester-*")
+		if err != nil {
+			return 0, fmt.Errorf("harvester: Failed to create temporary file: %s", err)
+		}
+		s.file = f
+		s.logger().Info("harvester: Spilling to disk", slog.String("path", f.Name()), slog.Int64("maxmemory", maxMemory))
+		if _, err := s.file.Write(s.buf.Bytes()); err != nil {
+			return 0, fmt.Errorf("harvester: Failed to write temporary file %s: %s", s.file.Name(), err)
+		}
+		s.buf = bytes.Buffer{}
+	}
+
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written.
+func (s *SpillBuffer) Size() int64 {
+	return s.size
+}
+
+// Spilled reports whether the data was moved to a temporary file.
+func (s *SpillBuffer) Spilled() bool {
+	return s.file != nil
+}
+
+// ReaderAt returns the data for random access.
+func (s *SpillBuffer) ReaderAt() io.ReaderAt {
+	if s.file != nil {
+		return s.file
+	}
+	return bytes.NewReader(s.buf.Bytes())
+}
+
+// Reader returns the data for reading from the start.
+func (s *SpillBuffer) Reader() io.Reader {
+	return io.NewSectionReader(s.ReaderAt(), 0, s.size)
+}
+
+// Close removes the temporary file, if there is one.
+func (s *SpillBuffer) Close() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	if err := os.Remove(s.file.Name()); err != nil {
+		s.logger().Warn("harvester: Failed to remove temporary file", slog.String("path", s.file.Name()), slog.Any("error", err))
+		return
+	}
+	s.logger().Debug("harvester: Removed temporary file", slog.String("path", s.file.Name()))
+}
+
+// logger returns the logger of the buffer, or the default logger
+func (s *SpillBuffer) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,71 @@
+package harvester
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxMemory   int64
+		writes      []string
+		wantSpilled bool
+	}{
+		{"in memory", 0, []string{"id;amount\n", "1;10\n"}, false},
+		{"exactly max memory", 15, []string{"id;amount\n", "1;10\n"}, false},
+		{"spills on the write that grows too large", 12, []string{"id;amount\n", "1;10\n", "2;20\n"}, true},
+		{"always on disk", -1, []string{"id;amount\n"}, true},
+		{"empty", -1, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := &SpillBuffer{TempDir: dir, MaxMemory: tt.maxMemory}
+			for _, data := range tt.writes {
+				if _, err := io.WriteString(s, data); err != nil {
+					t.Fatalf("Write: %s", err)
+				}
+			}
+			want := strings.Join(tt.writes, "")
+			if s.Spilled() != tt.wantSpilled || s.Size() != int64(len(want)) {
+				t.Errorf("spilled is %v with size %d, want %v with size %d", s.Spilled(), s.Size(), tt.wantSpilled, len(want))
+			}
+
+			// The data can be read more than once, from the start and at an offset
+			for i := 0; i < 2; i++ {
+				b, err := io.ReadAll(s.Reader())
+				if err != nil || string(b) != want {
+					t.Errorf("read %q, %v, want %q", b, err, want)
+				}
+			}
+			if len(want) > 3 {
+				b := make([]byte, 3)
+				if _, err := s.ReaderAt().ReadAt(b, 3); err != nil || string(b) != want[3:6] {
+					t.Errorf("read %q at offset 3, %v, want %q", b, err, want[3:6])
+				}
+			}
+
+			// Close removes the temporary file
+			entries, _ := os.ReadDir(dir)
+			if (len(entries) == 1) != tt.wantSpilled {
+				t.Errorf("temporary directory has %d files before Close", len(entries))
+			}
+			s.Close()
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("temporary directory has %d files after Close, want none", len(entries))
+			}
+		})
+	}
+}
+
+func TestSpillBufferTempDirMissing(t *testing.T) {
+	s := &SpillBuffer{TempDir: filepath.Join(t.TempDir(), "missing"), MaxMemory: -1}
+	defer s.Close()
+	if _, err := io.WriteString(s, "id;amount\n"); err == nil {
+		t.Errorf("Write succeeded without a temporary directory")
+	}
+}
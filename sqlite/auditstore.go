@@ -0,0 +1,161 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwijnja/harvester"
+	_ "modernc.org/sqlite"
+)
+
+// schema creates the transfers table, with one row per transfer.
+const schema = `
+CREATE TABLE IF NOT EXISTS transfers (
+	transfer_id        TEXT PRIMARY KEY,
+	job                TEXT NOT NULL,
+	run_id             TEXT NOT NULL,
+	source_system      TEXT NOT NULL,
+	source_path        TEXT NOT NULL,
+	destination_system TEXT NOT NULL,
+	destination_path   TEXT NOT NULL,
+	source_hashes      TEXT NOT NULL,
+	destination_hashes TEXT NOT NULL,
+	hops               TEXT NOT NULL,
+	status             TEXT NOT NULL,
+	error              TEXT NOT NULL,
+	started            TEXT NOT NULL,
+	finished           TEXT NOT NULL,
+	parent_id          TEXT NOT NULL DEFAULT '',
+	source_entry       TEXT NOT NULL DEFAULT '',
+	details            TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS transfers_run_id ON transfers (run_id);
+CREATE INDEX IF NOT EXISTS transfers_started ON transfers (started);
+`
+
+// insert stores a single transfer. Hashes, hops and details are stored as JSON.
+const insert = `
+INSERT INTO transfers (
+	transfer_id, job, run_id, source_system, source_path, destination_system, destination_path,
+	source_hashes, destination_hashes, hops, status, error, started, finished, parent_id, source_entry, details
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// AuditStore stores every audit record as a row in an embedded SQLite database.
+// The database and table are created on the first record.
+type AuditStore struct {
+	Path string // Example: "/var/lib/mft/audit.db"
+	mu   sync.Mutex
+	db   *sql.DB
+}
+
+// Save inserts the record into the transfers table.
+func (s *AuditStore) Save(ctx context.Context, record harvester.AuditRecord) error {
+
+	// Open the database
+	db, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Encode the hashes, hops and details
+	sourceHashes, err := json.Marshal(record.SourceHashes)
+	if err != nil {
+		return fmt.Errorf("sqlite: Failed to encode source hashes: %s", err)
+	}
+	destinationHashes, err := json.Marshal(record.DestinationHashes)
+	if err != nil {
+		return fmt.Errorf("sqlite: Failed to encode destination hashes: %s", err)
+	}
+	hops, err := json.Marshal(record.Hops)
+	if err != nil {
+		return fmt.Errorf("sqlite: Failed to encode hops: %s", err)
+	}
+	details := []byte("{}")
+	if record.Details != nil {
+		details, err = json.Marshal(record.Details)
+		if err != nil {
+			return fmt.Errorf("sqlite: Failed to encode details: %s", err)
+		}
+	}
+
+	// Insert the record
+	_, err = db.ExecContext(ctx, insert,
+		record.TransferID,
+		record.JobName,
+		record.RunID,
+		record.SourceSystem,
+		record.SourcePath,
+		record.DestinationSystem,
+		record.DestinationPath,
+		string(sourceHashes),
+		string(destinationHashes),
+		string(hops),
+		record.Status,
+		record.Error,
+		record.Started.UTC().Format(time.RFC3339Nano),
+		record.Finished.UTC().Format(time.RFC3339Nano),
+		record.ParentID,
+		record.SourceEntry,
+		string(details),
+	)
+	if err != nil {
+		return fmt.Errorf("sqlite: Failed to insert audit record into %s: %s", s.Path, err)
+	}
+
+	return nil
+}
+
+// Close closes the database, if it was opened.
+func (s *AuditStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// open opens the database and creates the table, once.
+func (s *AuditStore) open(ctx context.Context) (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return s.db, nil
+	}
+
+	// Open the database, waiting for locks held by other processes instead of failing immediately
+	db, err := sql.Open("sqlite", s.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: Failed to open database %s: %s", s.Path, err)
+	}
+
+	// Create the table
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: Failed to create table in %s: %s", s.Path, err)
+	}
+
+	s.db = db
+	return db, nil
+}
+
+// dsn returns the URI of the database. The path is escaped, because SQLite would take a ? or # in it for the start
+// of the parameters, and it decodes the escapes again.
+func (s *AuditStore) dsn() string {
+	u := url.URL{
+		Scheme:   "file",
+		Opaque:   strings.ReplaceAll(url.PathEscape(s.Path), "%2F", "/"),
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+	}
+	return u.String()
+}
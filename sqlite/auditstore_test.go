@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gwijnja/harvester"
+)
+
+func TestAuditStorePaths(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"plain", "audit.db"},
+		{"space", "audit trail.db"},
+		{"question mark", "audit?mode=ro.db"},
+		{"hash", "audit#1.db"},
+		{"percent", "audit%20.db"},
+		{"subdirectory", filepath.Join("db", "audit.db")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			s := &AuditStore{Path: path}
+			record := harvester.AuditRecord{
+				RunID:        "run",
+				TransferID:   "transfer",
+				ParentID:     "parent",
+				SourceSystem: "local",
+				SourcePath:   "/in/orders.zip",
+				SourceEntry:  "orders.csv",
+				Details:      map[string]string{"sidecar": "orders.csv.sha256 (verified)"},
+				Status:       "success",
+				Started:      time.Now(),
+				Finished:     time.Now(),
+			}
+			if err := s.Save(context.Background(), record); err != nil {
+				t.Fatalf("Save: %s", err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close: %s", err)
+			}
+
+			// The database is at the path itself, and has the record with all columns
+			if _, err := os.Stat(path); err != nil {
+				t.Fatalf("database is not at %s: %s", path, err)
+			}
+			db, err := sql.Open("sqlite", s.dsn())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			var parentID, sourceEntry, details string
+			err = db.QueryRow("SELECT parent_id, source_entry, details FROM transfers WHERE transfer_id = ?", "transfer").Scan(&parentID, &sourceEntry, &details)
+			if err != nil {
+				t.Fatalf("reading the record: %s", err)
+			}
+			if parentID != "parent" || sourceEntry != "orders.csv" || details != `{"sidecar":"orders.csv.sha256 (verified)"}` {
+				t.Errorf("record has parent %q, entry %q and details %s", parentID, sourceEntry, details)
+			}
+		})
+	}
+}
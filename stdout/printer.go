@@ -1,6 +1,7 @@
 package stdout
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"strings"
@@ -11,18 +12,21 @@ import (
 // Printer prints the contents of a file to stdout
 type Printer struct {
 	harvester.NextProcessor
+	Logger *slog.Logger // nil for the logger of the job
 }
 
 // Process reads a file and writes the contents to stdout
-func (p *Printer) Process(filename string, r io.Reader) error {
+func (p *Printer) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, p.Logger)
 
 	buf := new(strings.Builder)
 
-	_, err := harvester.AuditCopy(buf, r)
+	_, err := harvester.AuditCopy(ctx, "stdout.Printer", buf, r)
 	if err != nil {
 		return err
 	}
-	slog.Info("stdout: Copied contents", slog.String("filename", filename), slog.String("contents", buf.String()))
+	harvester.SetDestination(ctx, "stdout", filename)
+	logger.Info("stdout: Copied contents", slog.String("filename", filename), slog.String("contents", buf.String()))
 
 	return nil
 }
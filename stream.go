@@ -0,0 +1,36 @@
+package harvester
+
+import (
+	"errors"
+	"io"
+)
+
+// Stream connects a producer and a consumer with a pipe, so data flows from one to the other without buffering it.
+// The producer runs in a separate goroutine and writes to the pipe, while the consumer reads from it.
+// When the consumer returns early without an error, the rest of the data is discarded, so the producer can finish.
+// The error of whichever failed first is returned, because the other usually fails as a result of it.
+func Stream(produce func(w io.Writer) error, consume func(r io.Reader) error) error {
+	pr, pw := io.Pipe()
+
+	// Start producing
+	produced := make(chan error, 1)
+	go func() {
+		err := produce(pw)
+		pw.CloseWithError(err) // a nil error closes the pipe with io.EOF
+		produced <- err
+	}()
+
+	// Consume, and discard what the consumer did not read
+	err := consume(pr)
+	if err == nil {
+		_, err = io.Copy(io.Discard, pr)
+	}
+	pr.CloseWithError(err) // unblocks the producer if the consumer failed
+	produceErr := <-produced
+
+	// The producer fails with the error of the consumer if the consumer failed first
+	if produceErr != nil && (err == nil || !errors.Is(produceErr, err)) {
+		return produceErr
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gwijnja/harvester"
+)
+
+// Bundle configures a Packer to collect multiple files in one archive.
+// The archives are built in Dir, and kept there until the next processor has accepted them,
+// so an archive that could not be delivered is delivered at the end of the next run.
+// With Gzip, the archives are compressed while they are delivered, so they are kept uncompressed in Dir.
+type Bundle struct {
+	Dir      string // Directory where archives are built and kept until they are delivered, required
+	Name     string // Filename of the archive as a Go time layout, formatted with the time it is started, default "bundle-20060102-150405.000.tar"
+	MaxFiles int    // Start a new archive after this many files, 0 for no limit
+	MaxBytes int64  // Start a new archive after this many bytes, 0 for no limit
+}
+
+// bundle returns the bundler that collects the files, and creates it on first use
+func (p *Packer) bundle() *harvester.Bundler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bundler == nil {
+		name := p.Bundle.Name
+		if name == "" {
+			name = "bundle-20060102-150405.000.tar"
+		}
+		p.bundler = &harvester.Bundler{
+			Dir:       p.Bundle.Dir,
+			Name:      name,
+			Extension: ".tar",
+			MaxFiles:  p.Bundle.MaxFiles,
+			MaxBytes:  p.Bundle.MaxBytes,
+			Hop:       "tar.Packer",
+			Logger:    p.Logger,
+			Build:     p.buildBundle,
+			Deliver:   p.deliver,
+		}
+	}
+	return p.bundler
+}
+
+// Flush builds the open archive, and presents all archives in the bundle directory to the next processor.
+// Archives that are accepted are removed, the others are tried again on the next flush.
+func (p *Packer) Flush(ctx context.Context) error {
+	if p.Bundle == nil {
+		return nil
+	}
+	return p.bundle().Flush(ctx)
+}
+
+// buildBundle writes an archive with the bundled files. A file without a known modification time is dated with
+// the time it was added.
+func (p *Packer) buildBundle(ctx context.Context, w io.Writer, entries []harvester.BundleEntry) error {
+	tarWriter := tar.NewWriter(w)
+	for _, entry := range entries {
+		modTime := entry.ModTime
+		if modTime.IsZero() {
+			modTime = entry.Added
+		}
+		data, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = p.writeEntry(ctx, tarWriter, header(entry.Name, entry.Size, modTime, entry.Mode), data)
+		data.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Close the archive, which writes the end-of-archive marker
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("tar: Failed to close tar writer: %w", err)
+	}
+	return nil
+}
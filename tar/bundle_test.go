@@ -0,0 +1,119 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gwijnja/harvester"
+)
+
+// bundled is a file found in a delivered archive
+type bundled struct {
+	content string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// archives is the next processor in the tests, and keeps the archives it receives
+type archives struct {
+	data [][]byte
+}
+
+func (a *archives) SetNext(next harvester.FileWriter) {}
+
+func (a *archives) Process(ctx context.Context, filename string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	a.data = append(a.data, b)
+	return nil
+}
+
+func TestBundleKeepsEarlierFilesWhenAFileFails(t *testing.T) {
+	modTime := time.Date(2024, 5, 17, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		gzip bool
+	}{
+		{"tar", false},
+		{"tar.gz", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := harvester.StartRun(context.Background())
+			next := &archives{}
+			p := &Packer{Gzip: tt.gzip, Bundle: &Bundle{Dir: t.TempDir()}}
+			p.SetNext(next)
+
+			// The first file has the mode and modification time of its source
+			first := harvester.StartTransfer(ctx, "local", "/in/a.txt")
+			harvester.SetSourceModTime(first, modTime)
+			harvester.SetSourceMode(first, 0600)
+			if err := p.Process(first, "a.txt", strings.NewReader("first")); err != nil {
+				t.Fatalf("Process(a.txt): %s", err)
+			}
+			failing := io.MultiReader(strings.NewReader("half"), iotest.ErrReader(errors.New("connection lost")))
+			if err := p.Process(harvester.StartTransfer(ctx, "local", "/in/b.txt"), "b.txt", failing); err == nil {
+				t.Fatalf("Process(b.txt) succeeded with a failing reader")
+			}
+			if err := p.Process(harvester.StartTransfer(ctx, "local", "/in/c.txt"), "c.txt", strings.NewReader("third")); err != nil {
+				t.Fatalf("Process(c.txt): %s", err)
+			}
+			if err := p.Flush(ctx); err != nil {
+				t.Fatalf("Flush: %s", err)
+			}
+			if len(next.data) != 1 {
+				t.Fatalf("delivered %d archives, want 1", len(next.data))
+			}
+
+			// Read the archive again
+			var r io.Reader = bytes.NewReader(next.data[0])
+			if tt.gzip {
+				gzipReader, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("archive is not gzipped: %s", err)
+				}
+				r = gzipReader
+			}
+			files := map[string]bundled{}
+			tarReader := tar.NewReader(r)
+			for {
+				header, err := tarReader.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("reading archive: %s", err)
+				}
+				b, err := io.ReadAll(tarReader)
+				if err != nil {
+					t.Fatalf("reading %s: %s", header.Name, err)
+				}
+				files[header.Name] = bundled{string(b), fs.FileMode(header.Mode), header.ModTime.UTC()}
+			}
+
+			third, ok := files["c.txt"]
+			if !ok || third.content != "third" || third.mode != defaultMode || third.modTime.IsZero() {
+				t.Fatalf("c.txt in archive is %+v, want its content, the default mode and the time it was added", third)
+			}
+			want := map[string]bundled{
+				"a.txt": {"first", 0600, modTime},
+				"c.txt": third,
+			}
+			if !reflect.DeepEqual(files, want) {
+				t.Errorf("bundle contains %+v, want %+v", files, want)
+			}
+		})
+	}
+}
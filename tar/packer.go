@@ -0,0 +1,127 @@
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/gzip"
+)
+
+// defaultMode is the mode of a file in the archive if the reader did not record the mode of the source file.
+const defaultMode fs.FileMode = 0644
+
+// Packer packs a file in a tar archive and presents it to the next processor in the chain.
+// A tar header contains the size of the file, so the file is staged first: in memory up to MaxMemory bytes,
+// and in a temporary file in TempDir if it is larger. The archive is streamed to the next processor.
+// With a Bundle, the files are collected in archives that are presented to the next processor at the end of the run.
+type Packer struct {
+	harvester.NextProcessor
+	Gzip      bool         // Compress the archive with gzip, as a .tar.gz file
+	TempDir   string       // Directory for files larger than MaxMemory, empty for the default directory of the OS
+	MaxMemory int64        // Files up to this size are kept in memory, 0 for harvester.DefaultMaxMemory, -1 to always use a temporary file
+	Bundle    *Bundle      // Collect multiple files in one archive, nil for one archive per file
+	Logger    *slog.Logger // nil for the logger of the job
+
+	mu      sync.Mutex
+	bundler *harvester.Bundler
+}
+
+// Process reads a file and writes a tar archive containing the file to the next processor
+func (p *Packer) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, p.Logger)
+
+	if p.Bundle != nil {
+		return p.bundle().Add(ctx, filename, r)
+	}
+
+	// Stage the file, and always remove the temporary file afterwards
+	staged := &harvester.SpillBuffer{TempDir: p.TempDir, MaxMemory: p.MaxMemory, Logger: logger}
+	defer staged.Close()
+	written, err := harvester.AuditCopy(ctx, "tar.Packer", staged, r)
+	if err != nil {
+		return fmt.Errorf("tar: Failed to stage %s: %s", filename, err)
+	}
+	logger.Debug("tar: Staged file", slog.String("filename", filename), slog.Int64("bytes", written), slog.Bool("spilled", staged.Spilled()))
+
+	newname := filename + ".tar"
+	logger.Info("tar: Renamed the context", slog.String("newname", newname))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			tarWriter := tar.NewWriter(w)
+			modTime, mode := source(ctx)
+			if err := p.writeEntry(ctx, tarWriter, header(filename, staged.Size(), modTime, mode), staged.Reader()); err != nil {
+				return err
+			}
+
+			// Close the archive, which writes the end-of-archive marker
+			if err := tarWriter.Close(); err != nil {
+				return fmt.Errorf("tar: Failed to close tar writer: %w", err)
+			}
+			logger.Debug("tar: Closed tar writer")
+			return nil
+		},
+		func(r io.Reader) error {
+			return p.deliver(ctx, newname, r)
+		},
+	)
+}
+
+// writeEntry adds a file to the archive, r must contain exactly the number of bytes in the header
+func (p *Packer) writeEntry(ctx context.Context, tarWriter *tar.Writer, header *tar.Header, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, p.Logger)
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("tar: Failed to write header for %s: %w", header.Name, err)
+	}
+	if _, err := io.Copy(tarWriter, r); err != nil {
+		return fmt.Errorf("tar: Failed to write %s to tar writer: %w", header.Name, err)
+	}
+	logger.Info("tar: Added file to tar writer", slog.String("filename", header.Name), slog.Int64("bytes", header.Size), slog.String("mode", fs.FileMode(header.Mode).String()))
+	return nil
+}
+
+// deliver presents an archive to the next processor, compressed with gzip if needed
+func (p *Packer) deliver(ctx context.Context, filename string, r io.Reader) error {
+	if p.Gzip {
+		compressor := &gzip.Compressor{Logger: p.Logger}
+		compressor.SetNext(&p.NextProcessor)
+		return compressor.Process(ctx, filename, r)
+	}
+	return p.NextProcessor.Process(ctx, filename, r)
+}
+
+// header describes a file in the archive. The name is stored without directories, and the mode and modification
+// time are those of the source file, or the defaults if they are not known.
+func header(filename string, size int64, modTime time.Time, mode fs.FileMode) *tar.Header {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.Base(filename),
+		Size:     size,
+		Mode:     int64(defaultMode),
+		ModTime:  time.Now(),
+	}
+	if mode != 0 {
+		header.Mode = int64(mode.Perm())
+	}
+	if !modTime.IsZero() {
+		header.ModTime = modTime
+	}
+	return header
+}
+
+// source returns the modification time and mode of the source file of the transfer, if the reader recorded them
+func source(ctx context.Context) (time.Time, fs.FileMode) {
+	if t := harvester.TransferFromContext(ctx); t != nil {
+		return t.SourceModTime(), t.SourceMode()
+	}
+	return time.Time{}, 0
+}
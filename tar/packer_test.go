@@ -0,0 +1,85 @@
+package tar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gwijnja/harvester"
+)
+
+func TestPackerRoundTrip(t *testing.T) {
+	modTime := time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+	input := strings.Repeat("order;customer;amount\n", 5000)
+	tests := []struct {
+		name      string
+		packer    *Packer
+		mode      fs.FileMode
+		modTime   time.Time
+		wantName  string
+		wantMode  fs.FileMode
+		wantGzip  bool
+		wantMTime bool
+	}{
+		{"tar", &Packer{}, 0, time.Time{}, "orders.csv.tar", defaultMode, false, false},
+		{"tar.gz", &Packer{Gzip: true}, 0, time.Time{}, "orders.csv.tar.gz", defaultMode, true, false},
+		{"spilled", &Packer{MaxMemory: -1}, 0, time.Time{}, "orders.csv.tar", defaultMode, false, false},
+		{"source mode and time", &Packer{}, 0600, modTime, "orders.csv.tar", 0600, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := harvester.StartTransfer(context.Background(), "local", "/in/orders.csv")
+			harvester.SetSourceMode(ctx, tt.mode)
+			harvester.SetSourceModTime(ctx, tt.modTime)
+			packed := &recorder{}
+			tt.packer.TempDir = t.TempDir()
+			tt.packer.SetNext(packed)
+
+			err := tt.packer.Process(ctx, "orders.csv", strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("Process: %s", err)
+			}
+			data, ok := packed.files[tt.wantName]
+			if !ok || len(packed.files) != 1 {
+				t.Fatalf("delivered %d files, want %s", len(packed.files), tt.wantName)
+			}
+			if isGzip := strings.HasPrefix(data, "\x1f\x8b"); isGzip != tt.wantGzip {
+				t.Errorf("archive is compressed with gzip: %v, want %v", isGzip, tt.wantGzip)
+			}
+
+			// The header has the source mode and modification time, if they are known
+			var r io.Reader = strings.NewReader(data)
+			if tt.wantGzip {
+				if r, err = gzip.NewReader(r); err != nil {
+					t.Fatalf("reading gzip: %s", err)
+				}
+			}
+			header, err := tar.NewReader(r).Next()
+			if err != nil {
+				t.Fatalf("reading archive: %s", err)
+			}
+			if header.Name != "orders.csv" || fs.FileMode(header.Mode) != tt.wantMode || header.Size != int64(len(input)) {
+				t.Errorf("archive has %s with mode %s and size %d", header.Name, fs.FileMode(header.Mode), header.Size)
+			}
+			if header.ModTime.Equal(tt.modTime) != tt.wantMTime {
+				t.Errorf("file is dated %s, source was modified at %s", header.ModTime, tt.modTime)
+			}
+
+			// The unpacker gives back the original file
+			files := &recorder{}
+			u := &Unpacker{}
+			u.SetNext(files)
+			if err := u.Process(context.Background(), tt.wantName, strings.NewReader(data)); err != nil {
+				t.Fatalf("Unpacker: %s", err)
+			}
+			if files.files["orders.csv"] != input || len(files.files) != 1 {
+				t.Errorf("unpacked %d files, want orders.csv with the input", len(files.files))
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/autodetect"
+)
+
+// PartialFailure decides what happens to an archive when only some of its entries were processed successfully.
+type PartialFailure int
+
+const (
+	// FailArchive stops at the first entry that fails, and fails the archive. The reader leaves the archive where it is,
+	// so all entries are extracted again on the next run, including the ones that succeeded.
+	FailArchive PartialFailure = iota
+
+	// AcceptArchive continues with the other entries when an entry fails, and the archive succeeds if at least one entry
+	// succeeded. The failed entries are only recorded in the log and the audit trail.
+	AcceptArchive
+)
+
+// Unpacker extracts the files from a tar archive and presents them to the next processor in the chain,
+// each as a transfer of its own. Compressed archives, like .tar.gz, .tgz or .tar.zst, are recognized by their
+// contents and decompressed first. A tar archive is read from start to end, so the files are streamed without staging.
+type Unpacker struct {
+	harvester.NextProcessor
+	Regex     string         // Only extract files whose path in the archive matches, empty for all files
+	Flatten   bool           // Name the extracted files without the directories they are in within the archive
+	OnPartial PartialFailure // What happens to the archive when only some files succeed
+	Logger    *slog.Logger   // nil for the logger of the job
+}
+
+// Process reads a tar archive and writes the contents of its files to the next processor
+func (u *Unpacker) Process(ctx context.Context, filename string, r io.Reader) error {
+	// Decompress the archive first if it is compressed, an uncompressed archive is passed through
+	decompressor := &autodetect.Decompressor{PassThrough: true, Logger: u.Logger}
+	decompressor.SetNext(processFunc(u.unpack))
+	return decompressor.Process(ctx, filename, r)
+}
+
+// unpack presents every matching file in an uncompressed archive to the next processor
+func (u *Unpacker) unpack(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
+
+	// Prepare the regex
+	re, err := regexp.Compile(u.Regex)
+	if err != nil {
+		return fmt.Errorf("tar: Failed to compile regex %s: %s", u.Regex, err)
+	}
+
+	tarReader := tar.NewReader(r)
+	succeeded, failed := 0, 0
+	var firstErr error
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar: Failed to read archive %s: %w", filename, err)
+		}
+
+		// Skip directories, links and other special files, and files that do not match the regex
+		if !header.FileInfo().Mode().IsRegular() {
+			logger.Debug("tar: Skipping entry that is not a regular file", slog.String("filename", header.Name), slog.String("type", string(header.Typeflag)))
+			continue
+		}
+		if !re.MatchString(header.Name) {
+			logger.Warn("tar: Skipping non-matching file", slog.String("filename", header.Name))
+			continue
+		}
+
+		// Stop when the job is cancelled
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Extract the file as a transfer of its own
+		entryCtx := harvester.StartEntryTransfer(ctx, header.Name)
+		harvester.SetSourceModTime(entryCtx, header.ModTime)
+		harvester.SetSourceMode(entryCtx, header.FileInfo().Mode().Perm())
+		err = u.extract(entryCtx, tarReader, header, u.entryName(header.Name))
+		harvester.FinishTransfer(entryCtx, err)
+		if err != nil {
+			failed++
+			harvester.ContextLogger(entryCtx, u.Logger).Error("tar: Failed to process file", slog.String("filename", header.Name), slog.Any("error", err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			if u.OnPartial == FailArchive {
+				return fmt.Errorf("tar: Failed to process %s from the archive: %w", header.Name, err)
+			}
+			continue
+		}
+		succeeded++
+	}
+	logger.Info("tar: Extracted archive", slog.Int("succeeded", succeeded), slog.Int("failed", failed))
+
+	// The archive fails if nothing was delivered
+	if succeeded == 0 {
+		if firstErr != nil {
+			return fmt.Errorf("tar: All %d files in the archive failed, first error: %w", failed, firstErr)
+		}
+		return fmt.Errorf("tar: No matching files in the archive")
+	}
+	if failed > 0 {
+		logger.Warn("tar: Accepted archive with failed files", slog.Int("failed", failed))
+	}
+	return nil
+}
+
+// entryName returns the filename under which a file from the archive is presented to the next processor
+func (u *Unpacker) entryName(name string) string {
+	if u.Flatten {
+		return path.Base(name)
+	}
+	return path.Clean(name)
+}
+
+// extract streams the current file of the tar reader to the next processor, under the given filename
+func (u *Unpacker) extract(ctx context.Context, tarReader *tar.Reader, header *tar.Header, filename string) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
+
+	// Do not let a file escape from the directory of a writer, for example with a name like ../../etc/passwd
+	if !filepath.IsLocal(filepath.FromSlash(filename)) {
+		return fmt.Errorf("tar: Refusing to extract %s, the path is not inside the archive", header.Name)
+	}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			written, err := harvester.AuditCopy(ctx, "tar.Unpacker (entry)", w, tarReader)
+			if err != nil {
+				return fmt.Errorf("tar: Failed extracting the file: %w", err)
+			}
+			logger.Debug("tar: Extracted the file", slog.String("filename", header.Name), slog.Int64("bytes", written))
+			return nil
+		},
+		func(r io.Reader) error {
+			return u.NextProcessor.Process(ctx, filename, r)
+		},
+	)
+}
+
+// processFunc turns a function into the next processor of a processor that is used internally.
+type processFunc func(ctx context.Context, filename string, r io.Reader) error
+
+// SetNext does nothing, a processFunc is always the last in its chain.
+func (f processFunc) SetNext(harvester.FileWriter) {}
+
+// Process calls the function.
+func (f processFunc) Process(ctx context.Context, filename string, r io.Reader) error {
+	return f(ctx, filename, r)
+}
@@ -0,0 +1,209 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/local"
+)
+
+// recorder is the next processor in the tests, and keeps the files it receives
+type recorder struct {
+	files map[string]string
+	fail  map[string]bool // files that fail
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	if r.fail[filename] {
+		return errors.New("disk full")
+	}
+	if r.files == nil {
+		r.files = map[string]string{}
+	}
+	r.files[filename] = string(b)
+	return nil
+}
+
+// archive returns a tar archive with the entries, in order. Names ending in a slash are directories,
+// and names starting with an @ are symbolic links.
+func archive(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, name := range names {
+		content := "content of " + name
+		header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}
+		switch {
+		case name[len(name)-1] == '/':
+			header = &tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}
+		case name[0] == '@':
+			header = &tar.Header{Typeflag: tar.TypeSymlink, Name: name[1:], Linkname: "/etc/passwd"}
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			io.WriteString(w, content)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gzipped returns the data compressed with gzip
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpacker(t *testing.T) {
+	entries := []string{"orders/", "orders/a.csv", "orders/b.csv", "@orders/link.csv", "readme.txt"}
+	tests := []struct {
+		name      string
+		unpacker  Unpacker
+		gzip      bool
+		fail      map[string]bool
+		wantErr   bool
+		wantFiles map[string]string
+	}{
+		{"all files", Unpacker{}, false, nil, false, map[string]string{
+			"orders/a.csv": "content of orders/a.csv",
+			"orders/b.csv": "content of orders/b.csv",
+			"readme.txt":   "content of readme.txt",
+		}},
+		{"gzip", Unpacker{Regex: `^readme`}, true, nil, false, map[string]string{"readme.txt": "content of readme.txt"}},
+		{"regex", Unpacker{Regex: `\.csv$`}, false, nil, false, map[string]string{
+			"orders/a.csv": "content of orders/a.csv",
+			"orders/b.csv": "content of orders/b.csv",
+		}},
+		{"flatten", Unpacker{Flatten: true, Regex: `\.csv$`}, false, nil, false, map[string]string{
+			"a.csv": "content of orders/a.csv",
+			"b.csv": "content of orders/b.csv",
+		}},
+		{"no matching files", Unpacker{Regex: `\.xml$`}, false, nil, true, nil},
+		{"invalid regex", Unpacker{Regex: "("}, false, nil, true, nil},
+		{"fail archive", Unpacker{OnPartial: FailArchive}, false, map[string]bool{"orders/b.csv": true}, true, map[string]string{
+			"orders/a.csv": "content of orders/a.csv",
+		}},
+		{"accept archive", Unpacker{OnPartial: AcceptArchive}, false, map[string]bool{"orders/a.csv": true}, false, map[string]string{
+			"orders/b.csv": "content of orders/b.csv",
+			"readme.txt":   "content of readme.txt",
+		}},
+		{"all files fail", Unpacker{OnPartial: AcceptArchive, Regex: `^readme`}, false, map[string]bool{"readme.txt": true}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := archive(t, entries...)
+			if tt.gzip {
+				data = gzipped(t, data)
+			}
+			next := &recorder{fail: tt.fail}
+			u := tt.unpacker
+			u.SetNext(next)
+
+			err := u.Process(context.Background(), "orders.tar", bytes.NewReader(data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process returned %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(next.files, tt.wantFiles) {
+				t.Errorf("delivered %q, want %q", next.files, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestUnpackerRejectsPathsOutsideArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		flatten  bool
+		wantErr  bool
+		wantFile string
+	}{
+		{"local", "orders.csv", false, false, "orders.csv"},
+		{"in directory", "dir/orders.csv", false, false, "dir/orders.csv"},
+		{"parent", "../../etc/x", false, true, ""},
+		{"hidden parent", "dir/../../x", false, true, ""},
+		{"absolute", "/etc/x", false, true, ""},
+		{"flattened parent", "../../etc/x", true, false, "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recorder{}
+			u := &Unpacker{Flatten: tt.flatten}
+			u.SetNext(next)
+
+			err := u.Process(context.Background(), "orders.tar", bytes.NewReader(archive(t, tt.entry)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process returned %v, want error %v", err, tt.wantErr)
+			}
+			if _, ok := next.files[tt.wantFile]; !tt.wantErr && (!ok || len(next.files) != 1) {
+				t.Errorf("delivered %q, want only %s", next.files, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestUnpackerNestedFilesToFileWriter(t *testing.T) {
+	transmit, toLoad := t.TempDir(), t.TempDir()
+	u := &Unpacker{}
+	u.SetNext(&local.FileWriter{Transmit: transmit, ToLoad: toLoad})
+
+	err := u.Process(context.Background(), "archive.tar.gz", bytes.NewReader(gzipped(t, archive(t, "./orders/", "./orders/2024/a.csv", "readme.txt"))))
+	if err != nil {
+		t.Fatalf("Process returned %v", err)
+	}
+	for name, content := range map[string]string{"orders/2024/a.csv": "content of ./orders/2024/a.csv", "readme.txt": "content of readme.txt"} {
+		b, err := os.ReadFile(filepath.Join(toLoad, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s contains %q, want %q", name, b, content)
+		}
+	}
+}
+
+func TestUnpackerDamagedArchive(t *testing.T) {
+	data := archive(t, "orders.csv")
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", data[:520]},
+		{"not a tar archive", []byte("id;amount\n1;10\n")},
+		{"truncated gzip", gzipped(t, data)[:100]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Unpacker{}
+			u.SetNext(&recorder{})
+			if err := u.Process(context.Background(), "orders.tar", bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("Process succeeded, want an error")
+			}
+		})
+	}
+}
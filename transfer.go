@@ -0,0 +1,219 @@
+package harvester
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// contextKey is the type of the keys that this package stores in a context.
+type contextKey int
+
+const (
+	transferKey contextKey = iota
+	runKey
+	jobKey
+	loggerKey
+	auditStoreKey
+	hashesKey
+	retryPolicyKey
+	sessionsKey
+)
+
+// Transfer identifies a single file transfer, from the reader through all processors to the writer.
+type Transfer struct {
+	RunID        string // ID of the job run that started the transfer
+	TransferID   string // Unique ID of this transfer
+	SourceName   string // Filename as it was found by the reader
+	SourcePath   string // Path of the file on the source system
+	SourceSystem string // Example: "sftp://itsme@sftp.example.com:22"
+	SourceEntry  string // Name of the entry within an archive, for transfers started with StartEntryTransfer
+	ParentID     string // ID of the transfer of the archive or original file, for transfers started with StartEntryTransfer or StartDerivedTransfer
+
+	mu                sync.Mutex
+	started           time.Time
+	hops              []AuditHop
+	modTime           time.Time
+	mode              fs.FileMode
+	details           map[string]string
+	destinationPath   string
+	destinationSystem string
+}
+
+// StartRun returns a context for a new job run, with a new run ID.
+func StartRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, runKey, newID())
+}
+
+// RunID returns the ID of the job run in the context, or an empty string if there is none.
+func RunID(ctx context.Context) string {
+	runID, _ := ctx.Value(runKey).(string)
+	return runID
+}
+
+// StartTransfer returns a context for a new transfer of a file found by a reader.
+// Loggers from the returned context include the transfer ID and source.
+// Readers must call FinishTransfer when the transfer has completed or failed.
+func StartTransfer(ctx context.Context, sourceSystem string, sourcePath string) context.Context {
+	t := &Transfer{
+		RunID:        RunID(ctx),
+		TransferID:   newID(),
+		SourceName:   filepath.Base(sourcePath),
+		SourcePath:   sourcePath,
+		SourceSystem: sourceSystem,
+		started:      time.Now(),
+	}
+	return context.WithValue(ctx, transferKey, t)
+}
+
+// StartEntryTransfer returns a context for a new transfer of a single entry of an archive, which is part of the
+// transfer in the context. The new transfer starts with the copies that were made of the archive so far.
+// Processors that fan out must call FinishTransfer for every entry transfer.
+func StartEntryTransfer(ctx context.Context, entry string) context.Context {
+	parent := TransferFromContext(ctx)
+	if parent == nil {
+		return StartTransfer(ctx, "", entry)
+	}
+	t := &Transfer{
+		RunID:        parent.RunID,
+		TransferID:   newID(),
+		SourceName:   filepath.Base(entry),
+		SourcePath:   parent.SourcePath,
+		SourceSystem: parent.SourceSystem,
+		SourceEntry:  entry,
+		ParentID:     parent.TransferID,
+		started:      time.Now(),
+		hops:         parent.Hops(),
+		modTime:      parent.SourceModTime(),
+		mode:         parent.SourceMode(),
+	}
+	return context.WithValue(ctx, transferKey, t)
+}
+
+// StartDerivedTransfer returns a context for a new transfer of a file that a processor created from the file of the
+// transfer in the context, like a checksum file. The new transfer has no copies yet, so it does not repeat the
+// copies of the original file. Processors must call FinishTransfer for every derived transfer.
+func StartDerivedTransfer(ctx context.Context, name string) context.Context {
+	parent := TransferFromContext(ctx)
+	if parent == nil {
+		return StartTransfer(ctx, "", name)
+	}
+	t := &Transfer{
+		RunID:        parent.RunID,
+		TransferID:   newID(),
+		SourceName:   filepath.Base(name),
+		SourcePath:   parent.SourcePath,
+		SourceSystem: parent.SourceSystem,
+		ParentID:     parent.TransferID,
+		started:      time.Now(),
+		modTime:      parent.SourceModTime(),
+		mode:         parent.SourceMode(),
+	}
+	return context.WithValue(ctx, transferKey, t)
+}
+
+// TransferFromContext returns the transfer in the context, or nil if there is none.
+func TransferFromContext(ctx context.Context) *Transfer {
+	t, _ := ctx.Value(transferKey).(*Transfer)
+	return t
+}
+
+// SetDestination records where a writer delivered the file of the transfer in the context.
+func SetDestination(ctx context.Context, system string, path string) {
+	t := TransferFromContext(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.destinationSystem = system
+	t.destinationPath = path
+}
+
+// SetSourceModTime records the modification time of the source file of the transfer in the context, if the reader knows it.
+func SetSourceModTime(ctx context.Context, modTime time.Time) {
+	t := TransferFromContext(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.modTime = modTime
+}
+
+// SourceModTime returns the modification time of the source file, or the zero time if the reader did not record it.
+func (t *Transfer) SourceModTime() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.modTime
+}
+
+// SetSourceMode records the permissions of the source file of the transfer in the context, if the reader knows them.
+func SetSourceMode(ctx context.Context, mode fs.FileMode) {
+	t := TransferFromContext(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mode = mode
+}
+
+// SourceMode returns the permissions of the source file, or 0 if the reader did not record them.
+func (t *Transfer) SourceMode() fs.FileMode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mode
+}
+
+// SetAuditDetail records a detail of the transfer in the context for the audit trail, like the keys a file was
+// encrypted for. A detail that was set before is replaced.
+func SetAuditDetail(ctx context.Context, key string, value string) {
+	t := TransferFromContext(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.details == nil {
+		t.details = map[string]string{}
+	}
+	t.details[key] = value
+}
+
+// Hops returns the copies that were made so far as part of the transfer.
+func (t *Transfer) Hops() []AuditHop {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]AuditHop{}, t.hops...)
+}
+
+// SourceHashes returns the hashes of the first copy of the transfer, which is the data as it was read from the source.
+func (t *Transfer) SourceHashes() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.hops) == 0 {
+		return nil
+	}
+	return t.hops[0].Hashes
+}
+
+// addHop records a copy that was made as part of the transfer.
+func (t *Transfer) addHop(hop AuditHop) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hops = append(t.hops, hop)
+}
+
+// newID returns a random version 4 UUID.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,59 @@
+package harvester
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+func TestTransferIDs(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	run := StartRun(context.Background())
+	first := TransferFromContext(StartTransfer(run, "sftp://itsme@sftp.example.com:22", "/out/orders.csv"))
+	second := TransferFromContext(StartTransfer(run, "sftp://itsme@sftp.example.com:22", "/out/customers.csv"))
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"run", RunID(run)},
+		{"first transfer", first.TransferID},
+		{"second transfer", second.TransferID},
+		{"other run", RunID(StartRun(context.Background()))},
+	}
+	seen := map[string]string{}
+	for _, tt := range tests {
+		if !uuid.MatchString(tt.id) {
+			t.Errorf("ID of %s is %q, want a version 4 UUID", tt.name, tt.id)
+		}
+		if other, ok := seen[tt.id]; ok {
+			t.Errorf("%s has the same ID as %s", tt.name, other)
+		}
+		seen[tt.id] = tt.name
+	}
+
+	// Both transfers belong to the run, and know their source
+	for _, transfer := range []*Transfer{first, second} {
+		if transfer.RunID != RunID(run) {
+			t.Errorf("transfer %s has run ID %s, want %s", transfer.SourceName, transfer.RunID, RunID(run))
+		}
+	}
+	if first.SourceName != "orders.csv" || first.SourcePath != "/out/orders.csv" || first.SourceSystem != "sftp://itsme@sftp.example.com:22" {
+		t.Errorf("transfer has source %s %s %s", first.SourceSystem, first.SourcePath, first.SourceName)
+	}
+}
+
+func TestTransferWithoutRun(t *testing.T) {
+	ctx := context.Background()
+	if RunID(ctx) != "" || TransferFromContext(ctx) != nil {
+		t.Errorf("empty context has run ID %q and transfer %v", RunID(ctx), TransferFromContext(ctx))
+	}
+
+	// A transfer outside of a run still gets an ID, and the helpers accept a context without a transfer
+	transfer := TransferFromContext(StartTransfer(ctx, "local", "/in/orders.csv"))
+	if transfer.RunID != "" || transfer.TransferID == "" {
+		t.Errorf("transfer has run ID %q and transfer ID %q, want only a transfer ID", transfer.RunID, transfer.TransferID)
+	}
+	SetAuditDetail(ctx, "key", "value")
+	SetDestination(ctx, "local", "/out/orders.csv")
+}
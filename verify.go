@@ -0,0 +1,49 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrVerificationFailed is returned when a delivered file differs from the data that was sent.
+var ErrVerificationFailed = errors.New("harvester: Verification failed")
+
+// VerifySize returns an error if the size of a delivered file differs from the number of bytes that were sent.
+func VerifySize(sent int64, delivered int64) error {
+	if sent != delivered {
+		return fmt.Errorf("%w: sent %d bytes, but the file has %d bytes", ErrVerificationFailed, sent, delivered)
+	}
+	return nil
+}
+
+// strongestFirst are the supported hash algorithms, from the strongest to the weakest.
+var strongestFirst = []string{"sha512", "sha256", "sha1", "md5", "crc32"}
+
+// VerifyHash reads a delivered file, and returns an error if its hash differs from the hash of the data that was sent.
+// The file is hashed with the strongest of the algorithms that were sent, and is not recorded as a hop in the audit
+// trail, because reading it back does not move the data.
+func VerifyHash(ctx context.Context, sent map[string]string, r io.Reader) error {
+	algorithm := ""
+	for _, a := range strongestFirst {
+		if _, ok := sent[a]; ok {
+			algorithm = a
+			break
+		}
+	}
+	if algorithm == "" {
+		return fmt.Errorf("%w: no hash of the data that was sent", ErrVerificationFailed)
+	}
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, NewContextReader(ctx, r)); err != nil {
+		return fmt.Errorf("harvester: Failed to read delivered file: %w", err)
+	}
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != sent[algorithm] {
+		return fmt.Errorf("%w: sent %s %s, but the file has %s", ErrVerificationFailed, algorithm, sent[algorithm], actual)
+	}
+	return nil
+}
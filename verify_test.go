@@ -0,0 +1,48 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVerifyHash(t *testing.T) {
+	// Hashes of "id;amount\n"
+	sha1 := "f2a5f1a10d662ef7402af78b4d3f37c3266aff76"
+	md5 := "41a0b69800bcc804955812fd00d346bc"
+	sha256 := "8a07b01bafe14b57d8105aac3120ee8eaebaea0f83f1d119f02ba2ac009e52c8"
+	crc32 := "20b7806e"
+	lost := errors.New("connection lost")
+	tests := []struct {
+		name    string
+		sent    map[string]string
+		r       io.Reader
+		wantErr error
+	}{
+		{"match", map[string]string{"sha1": sha1}, strings.NewReader("id;amount\n"), nil},
+		{"strongest algorithm", map[string]string{"sha1": sha1, "md5": "wrong"}, strings.NewReader("id;amount\n"), nil},
+		{"sha256 before crc32", map[string]string{"crc32": "wrong", "sha256": sha256}, strings.NewReader("id;amount\n"), nil},
+		{"crc32 is not enough", map[string]string{"crc32": crc32, "sha256": "wrong"}, strings.NewReader("id;amount\n"), ErrVerificationFailed},
+		{"md5 before crc32", map[string]string{"crc32": "wrong", "md5": md5}, strings.NewReader("id;amount\n"), nil},
+		{"only crc32", map[string]string{"crc32": crc32}, strings.NewReader("id;amount\n"), nil},
+		{"different", map[string]string{"sha1": sha1}, strings.NewReader("id;amount\n1;10\n"), ErrVerificationFailed},
+		{"no hashes", nil, strings.NewReader("id;amount\n"), ErrVerificationFailed},
+		{"unsupported hashes only", map[string]string{"sha3": sha1}, strings.NewReader("id;amount\n"), ErrVerificationFailed},
+		{"read error", map[string]string{"sha1": sha1}, iotest.ErrReader(lost), lost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := StartTransfer(StartRun(context.Background()), "sftp", "/out/report.csv")
+			err := VerifyHash(ctx, tt.sent, tt.r)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyHash returned %v, want %v", err, tt.wantErr)
+			}
+			if hops := TransferFromContext(ctx).Hops(); len(hops) != 0 {
+				t.Errorf("VerifyHash recorded %d hops, want none", len(hops))
+			}
+		})
+	}
+}
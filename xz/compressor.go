@@ -0,0 +1,68 @@
+package xz
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/gwijnja/harvester"
+	"github.com/ulikunitz/xz"
+)
+
+// dictionarySizes are the dictionary sizes of the presets of the xz command line tool, for levels 1 to 9.
+// A larger dictionary finds more repetitions, but the compressor and decompressor need more memory.
+var dictionarySizes = []int{1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// Compressor compresses a file using xz and presents it to the next processor in the chain.
+// The compressed data is streamed to the next processor while it is being compressed, so it is never held in memory.
+type Compressor struct {
+	harvester.NextProcessor
+	Level  int          // Compression level from 1 (least memory) to 9 (smallest), 0 for 6, the default of the xz tool
+	Logger *slog.Logger // nil for the logger of the job
+}
+
+// Process reads a file and writes the compressed contents to the next processor
+func (c *Compressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, c.Logger)
+
+	level := c.Level
+	if level == 0 {
+		level = 6
+	}
+	if level < 1 || level > 9 {
+		return fmt.Errorf("xz: Invalid compression level %d, use 1 to 9", c.Level)
+	}
+	config := xz.WriterConfig{DictCap: dictionarySizes[level-1]}
+
+	newname := filename + ".xz"
+	logger.Info("xz: Renamed context filename", slog.String("newname", newname))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Compress the input into the pipe
+			xzWriter, err := config.NewWriter(w)
+			if err != nil {
+				return fmt.Errorf("xz: Failed to create xz writer: %w", err)
+			}
+			written, err := harvester.AuditCopy(ctx, "xz.Compressor", xzWriter, r)
+			if err != nil {
+				xzWriter.Close()
+				return fmt.Errorf("xz: Failed to copy input to xz writer: %w", err)
+			}
+			logger.Info("xz: Copied input to xz writer", slog.String("filename", filename), slog.Int64("bytes", written))
+
+			// Write the index and footer of the stream
+			err = xzWriter.Close()
+			if err != nil {
+				return fmt.Errorf("xz: Failed to close xz writer: %w", err)
+			}
+			logger.Info("xz: Closed xz writer", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("xz: Calling the next processor")
+			return c.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
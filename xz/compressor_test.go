@@ -0,0 +1,101 @@
+package xz
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, string(b)
+	return nil
+}
+
+func TestCompressorLevels(t *testing.T) {
+	tests := []struct {
+		level   int
+		wantErr bool
+	}{
+		{-1, true},
+		{0, false},
+		{1, false},
+		{6, false},
+		{9, false},
+		{10, true},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		input := strings.Repeat("order;customer;amount\n", 1000)
+
+		// Compress, and decompress the result again
+		files := &recorder{}
+		d := &Decompressor{}
+		d.SetNext(files)
+		c := &Compressor{Level: tt.level}
+		c.SetNext(d)
+		err := c.Process(ctx, "orders.csv", strings.NewReader(input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("level %d: error %v, want error %v", tt.level, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (files.filename != "orders.csv" || files.data != input) {
+			t.Errorf("level %d: got %s with %d bytes, want orders.csv with the %d bytes that were compressed", tt.level, files.filename, len(files.data), len(input))
+		}
+	}
+}
+
+func TestXZTool(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz is not installed")
+	}
+	ctx := context.Background()
+	input := strings.Repeat("order;customer;amount\n", 1000)
+
+	// The xz tool decompresses what the compressor made
+	compressed := &recorder{}
+	c := &Compressor{}
+	c.SetNext(compressed)
+	if err := c.Process(ctx, "orders.csv", strings.NewReader(input)); err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	cmd := exec.Command("xz", "--decompress", "--stdout")
+	cmd.Stdin = strings.NewReader(compressed.data)
+	out, err := cmd.Output()
+	if err != nil || string(out) != input {
+		t.Errorf("xz returned %d bytes and error %v, want the %d bytes that were compressed", len(out), err, len(input))
+	}
+
+	// The decompressor reads what the xz tool made
+	cmd = exec.Command("xz", "--compress", "--stdout", "-9")
+	cmd.Stdin = strings.NewReader(input)
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("xz: %s", err)
+	}
+	files := &recorder{}
+	d := &Decompressor{}
+	d.SetNext(files)
+	if err := d.Process(ctx, "orders.csv.xz", bytes.NewReader(out)); err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	if files.filename != "orders.csv" || files.data != input {
+		t.Errorf("got %s with %d bytes, want orders.csv with %d bytes", files.filename, len(files.data), len(input))
+	}
+}
@@ -0,0 +1,54 @@
+package xz
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/gwijnja/harvester"
+	"github.com/ulikunitz/xz"
+)
+
+// Decompressor decompresses an xz file and presents it to the next processor in the chain.
+// The uncompressed data is streamed to the next processor while it is being decompressed, so it is never held in memory.
+type Decompressor struct {
+	harvester.NextProcessor
+	Logger *slog.Logger // nil for the logger of the job
+}
+
+// Process reads an xz file and writes the uncompressed contents to the next processor
+func (d *Decompressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Create an xz reader, which reads the header right away
+	xzReader, err := xz.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("xz: Failed to create xz reader for %s: %s", filename, err)
+	}
+	logger.Debug("xz: Created xz reader", slog.String("filename", filename))
+
+	// Remove the .xz suffix from the filename
+	newname := filename
+	if strings.HasSuffix(filename, ".xz") {
+		newname = strings.TrimSuffix(filename, ".xz")
+		logger.Info("xz: Removed .xz suffix", slog.String("newname", newname))
+	}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Decompress the input into the pipe, the xz reader verifies the checksum of every block
+			_, err := harvester.AuditCopy(ctx, "xz.Decompressor", w, xzReader)
+			if err != nil {
+				return fmt.Errorf("xz: Failed to decompress %s: %w", filename, err)
+			}
+			logger.Info("xz: Decompressed file", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("xz: Calling the next processor")
+			return d.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
@@ -0,0 +1,98 @@
+package zip
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gwijnja/harvester"
+)
+
+// Bundle configures a Compressor to collect multiple files in one archive.
+// The archives are built in Dir, and kept there until the next processor has accepted them,
+// so an archive that could not be delivered is delivered at the end of the next run.
+type Bundle struct {
+	Dir      string // Directory where archives are built and kept until they are delivered, required
+	Name     string // Filename of the archive as a Go time layout, formatted with the time it is started, default "bundle-20060102-150405.000.zip"
+	MaxFiles int    // Start a new archive after this many files, 0 for no limit
+	MaxBytes int64  // Start a new archive after this many uncompressed bytes, 0 for no limit
+}
+
+// bundle returns the bundler that collects the files, and creates it on first use
+func (z *Compressor) bundle() *harvester.Bundler {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.bundler == nil {
+		name := z.Bundle.Name
+		if name == "" {
+			name = "bundle-20060102-150405.000.zip"
+		}
+		z.bundler = &harvester.Bundler{
+			Dir:       z.Bundle.Dir,
+			Name:      name,
+			Extension: ".zip",
+			MaxFiles:  z.Bundle.MaxFiles,
+			MaxBytes:  z.Bundle.MaxBytes,
+			Hop:       "zip.Compressor",
+			Logger:    z.Logger,
+			Build:     z.buildBundle,
+			Deliver:   z.NextProcessor.Process,
+		}
+	}
+	return z.bundler
+}
+
+// Flush builds the open archive, and presents all archives in the bundle directory to the next processor.
+// Archives that are accepted are removed, the others are tried again on the next flush.
+func (z *Compressor) Flush(ctx context.Context) error {
+	if z.Bundle == nil {
+		return nil
+	}
+	return z.bundle().Flush(ctx)
+}
+
+// buildBundle writes an archive with the bundled files
+func (z *Compressor) buildBundle(ctx context.Context, w io.Writer, entries []harvester.BundleEntry) error {
+	password, err := z.password(ctx)
+	if err != nil {
+		return err
+	}
+	zipWriter, err := z.newWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := z.addEntry(zipWriter, entry, password); err != nil {
+			return err
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("zip: Failed to close zip writer: %s", err)
+	}
+	return nil
+}
+
+// addEntry copies a bundled file into the archive
+func (z *Compressor) addEntry(zipWriter *zip.Writer, entry harvester.BundleEntry, password string) error {
+	data, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	entryWriter, err := z.createEntry(zipWriter, z.header(entry.Name, entry.Added, entry.ModTime), password)
+	if err == nil {
+		_, err = io.Copy(entryWriter, data)
+	}
+	if err == nil {
+		err = entryWriter.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("zip: Failed to add %s to archive: %s", entry.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package zip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gwijnja/harvester"
+)
+
+func TestBundleKeepsEarlierFilesWhenAFileFails(t *testing.T) {
+	password := harvester.SecretFunc(func(ctx context.Context) (string, error) { return "secret", nil })
+	tests := []struct {
+		name     string
+		store    bool
+		password harvester.Secret
+	}{
+		{"deflate", false, nil},
+		{"store", true, nil},
+		{"encrypted", false, password},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			archives := &recorder{}
+			z := &Compressor{Store: tt.store, Password: tt.password, Bundle: &Bundle{Dir: t.TempDir()}}
+			z.SetNext(archives)
+
+			if err := z.Process(ctx, "a.txt", strings.NewReader("first")); err != nil {
+				t.Fatalf("Process(a.txt): %s", err)
+			}
+			failing := io.MultiReader(strings.NewReader("half"), iotest.ErrReader(errors.New("connection lost")))
+			if err := z.Process(ctx, "b.txt", failing); err == nil {
+				t.Fatalf("Process(b.txt) succeeded with a failing reader")
+			}
+			if err := z.Process(ctx, "c.txt", strings.NewReader("third")); err != nil {
+				t.Fatalf("Process(c.txt): %s", err)
+			}
+			if err := z.Flush(ctx); err != nil {
+				t.Fatalf("Flush: %s", err)
+			}
+			if len(archives.files) != 1 {
+				t.Fatalf("delivered %d archives, want 1", len(archives.files))
+			}
+
+			// Extract the archive again
+			files := &recorder{}
+			u := &Decompressor{MultipleFiles: true, Password: tt.password}
+			u.SetNext(files)
+			for _, data := range archives.files {
+				if err := u.Process(ctx, "bundle.zip", bytes.NewReader([]byte(data))); err != nil {
+					t.Fatalf("extracting bundle: %s", err)
+				}
+			}
+			want := map[string]string{"a.txt": "first", "c.txt": "third"}
+			if !reflect.DeepEqual(files.files, want) {
+				t.Errorf("bundle contains %q, want %q", files.files, want)
+			}
+		})
+	}
+}
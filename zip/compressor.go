@@ -2,56 +2,178 @@ package zip
 
 import (
 	"archive/zip"
-	"bytes"
+	"compress/flate"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gwijnja/harvester"
 )
 
 // Compressor compresses a file and presents it to the next processor in the chain.
+// The archive is streamed to the next processor while it is being written, so it is never held in memory.
+// With a Bundle, the files are collected in archives that are presented to the next processor at the end of the run.
 type Compressor struct {
 	harvester.NextProcessor
+	Level           int              // Compression level from 1 (fastest) to 9 (smallest), 0 for the default of Deflate
+	Store           bool             // Store the files without compressing them, for data that is already compressed
+	Comment         string           // Comment of the archive
+	PreserveModTime bool             // Use the modification time of the source file, instead of the current time
+	Password        harvester.Secret // Encrypt the files with this password, nil for no encryption
+	Encryption      Encryption       // Encryption method if there is a Password, default AES256
+	Bundle          *Bundle          // Collect multiple files in one archive, nil for one archive per file
+	Logger          *slog.Logger     // nil for the logger of the job
+
+	mu      sync.Mutex
+	bundler *harvester.Bundler
 }
 
 // Process reads a file and writes the compressed contents to the next processor
-func (z *Compressor) Process(filename string, r io.Reader) error {
-
-	// Create a zip writer
-	buf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buf)
-	defer func() {
-		zipWriter.Close()
-		slog.Debug("zip: Zip writer closed")
-	}()
-
-	// Create a file in the zip archive
-	zipEntryWriter, err := zipWriter.Create(filename)
-	if err != nil {
-		return fmt.Errorf("zip: Failed to create file in zip writer for %s: %s", filename, err)
-	}
-	slog.Info("zip: Zip entry created", slog.String("filename", filename))
+func (z *Compressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, z.Logger)
 
-	// Copy the ctx.Reader to the zip archive
-	written, err := harvester.AuditCopy(zipEntryWriter, r)
+	// Get the password before anything is read
+	password, err := z.password(ctx)
 	if err != nil {
 		return err
 	}
-	slog.Info("zip: Copied data to zip writer", slog.Int64("bytes", written))
 
-	// Close the zip archive
-	zipWriter.Close()
-	slog.Debug("zip: Closed zip writer")
+	if z.Bundle != nil {
+		return z.bundle().Add(ctx, filename, r)
+	}
 
 	// Rename the filename
 	extension := filepath.Ext(filename)
 	withoutExtension := strings.TrimSuffix(filename, extension)
 	withZipExtension := withoutExtension + ".zip"
-	slog.Info("zip: Renamed the context", slog.String("newname", withZipExtension))
+	logger.Info("zip: Renamed the context", slog.String("newname", withZipExtension))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Create a zip writer
+			zipWriter, err := z.newWriter(w)
+			if err != nil {
+				return err
+			}
+
+			// Create a file in the zip archive
+			zipEntryWriter, err := z.createEntry(zipWriter, z.header(filename, time.Now(), sourceModTime(ctx)), password)
+			if err != nil {
+				zipWriter.Close()
+				return fmt.Errorf("zip: Failed to create file in zip writer for %s: %w", filename, err)
+			}
+			logger.Info("zip: Zip entry created", slog.String("filename", filename))
+
+			// Copy the input to the zip archive
+			written, err := harvester.AuditCopy(ctx, "zip.Compressor", zipEntryWriter, r)
+			if err == nil {
+				err = zipEntryWriter.Close()
+			}
+			if err != nil {
+				zipWriter.Close()
+				return err
+			}
+			logger.Info("zip: Copied data to zip writer", slog.Int64("bytes", written))
+
+			// Close the zip archive, which writes the central directory
+			err = zipWriter.Close()
+			if err != nil {
+				return fmt.Errorf("zip: Failed to close zip writer: %w", err)
+			}
+			logger.Debug("zip: Closed zip writer")
+			return nil
+		},
+		func(r io.Reader) error {
+			return z.NextProcessor.Process(ctx, withZipExtension, r)
+		},
+	)
+}
+
+// newWriter creates a zip writer with the compression level and comment of the compressor
+func (z *Compressor) newWriter(w io.Writer) (*zip.Writer, error) {
+	level, err := z.level()
+	if err != nil {
+		return nil, err
+	}
+
+	zipWriter := zip.NewWriter(w)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	if z.Comment != "" {
+		if err := zipWriter.SetComment(z.Comment); err != nil {
+			return nil, fmt.Errorf("zip: Failed to set comment: %s", err)
+		}
+	}
+	return zipWriter, nil
+}
+
+// level returns the compression level of Deflate
+func (z *Compressor) level() (int, error) {
+	if z.Level == 0 {
+		return flate.DefaultCompression, nil
+	}
+	if z.Level < flate.BestSpeed || z.Level > flate.BestCompression {
+		return 0, fmt.Errorf("zip: Invalid compression level %d, use 1 to 9", z.Level)
+	}
+	return z.Level, nil
+}
+
+// password returns the password to encrypt with, or an empty string if the files are not encrypted
+func (z *Compressor) password(ctx context.Context) (string, error) {
+	if z.Password == nil {
+		return "", nil
+	}
+	password, err := z.Password.Secret(ctx)
+	if err != nil {
+		return "", fmt.Errorf("zip: Failed to get password: %s", err)
+	}
+	if password == "" {
+		return "", fmt.Errorf("zip: Password is empty")
+	}
+	return password, nil
+}
+
+// createEntry adds a file to the archive, encrypted if there is a password.
+// The returned writer must be closed before the next file is added.
+func (z *Compressor) createEntry(zipWriter *zip.Writer, header *zip.FileHeader, password string) (io.WriteCloser, error) {
+	if password == "" {
+		w, err := zipWriter.CreateHeader(header)
+		return nopWriteCloser{w}, err
+	}
+	level, err := z.level()
+	if err != nil {
+		return nil, err
+	}
+	return createEncrypted(zipWriter, header, z.Encryption, password, level)
+}
 
-	r = bytes.NewReader(buf.Bytes())
-	return z.NextProcessor.Process(withZipExtension, r)
+// header describes a file in the archive, with the compression method of the compressor. The file is dated now,
+// or with the modification time of the source file if the compressor preserves it and it is known.
+func (z *Compressor) header(filename string, now time.Time, modTime time.Time) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     filename,
+		Method:   zip.Deflate,
+		Modified: now,
+	}
+	if z.Store {
+		header.Method = zip.Store
+	}
+	if z.PreserveModTime && !modTime.IsZero() {
+		header.Modified = modTime
+	}
+	return header
+}
+
+// sourceModTime returns the modification time of the source file of the transfer, zero if it is not known
+func sourceModTime(ctx context.Context) time.Time {
+	if t := harvester.TransferFromContext(ctx); t != nil {
+		return t.SourceModTime()
+	}
+	return time.Time{}
 }
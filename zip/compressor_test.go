@@ -0,0 +1,100 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gwijnja/harvester"
+)
+
+func TestCompressorOptions(t *testing.T) {
+	modTime := time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+	input := strings.Repeat("order;customer;amount\n", 5000)
+	tests := []struct {
+		name        string
+		compressor  *Compressor
+		wantMethod  uint16
+		wantModTime bool
+		wantErr     bool
+	}{
+		{"default", &Compressor{}, zip.Deflate, false, false},
+		{"fastest", &Compressor{Level: 1}, zip.Deflate, false, false},
+		{"smallest", &Compressor{Level: 9}, zip.Deflate, false, false},
+		{"store", &Compressor{Store: true}, zip.Store, false, false},
+		{"comment", &Compressor{Comment: "orders of today"}, zip.Deflate, false, false},
+		{"modification time", &Compressor{PreserveModTime: true}, zip.Deflate, true, false},
+		{"invalid level", &Compressor{Level: 10}, zip.Deflate, false, true},
+		{"negative level", &Compressor{Level: -2}, zip.Deflate, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := harvester.StartTransfer(context.Background(), "local", "/in/orders.csv")
+			harvester.SetSourceModTime(ctx, modTime)
+			archives := &recorder{}
+			z := tt.compressor
+			z.SetNext(archives)
+
+			err := z.Process(ctx, "orders.csv", strings.NewReader(input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process returned %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			data, ok := archives.files["orders.zip"]
+			if !ok || len(archives.files) != 1 {
+				t.Fatalf("delivered %d files, want orders.zip", len(archives.files))
+			}
+
+			r, err := zip.NewReader(strings.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("reading archive: %s", err)
+			}
+			if r.Comment != tt.compressor.Comment {
+				t.Errorf("comment is %q, want %q", r.Comment, tt.compressor.Comment)
+			}
+			if len(r.File) != 1 {
+				t.Fatalf("archive has %d files, want 1", len(r.File))
+			}
+			file := r.File[0]
+			if file.Name != "orders.csv" || file.Method != tt.wantMethod {
+				t.Errorf("archive has %s with method %d, want orders.csv with method %d", file.Name, file.Method, tt.wantMethod)
+			}
+			if file.Modified.Equal(modTime) != tt.wantModTime {
+				t.Errorf("file is dated %s, source was modified at %s", file.Modified, modTime)
+			}
+			f, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var got bytes.Buffer
+			if _, err := io.Copy(&got, f); err != nil || got.String() != input {
+				t.Errorf("extracted %d bytes, %v, want %d bytes", got.Len(), err, len(input))
+			}
+		})
+	}
+}
+
+func TestCompressorLevels(t *testing.T) {
+	// A higher level gives a smaller archive for data that compresses well
+	input := strings.Repeat("order;customer;amount\n", 5000) + strings.Repeat("1234;5678;90.00\n", 5000)
+	sizes := map[int]int{}
+	for _, level := range []int{1, 9} {
+		archives := &recorder{}
+		z := &Compressor{Level: level}
+		z.SetNext(archives)
+		if err := z.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+			t.Fatalf("level %d: %s", level, err)
+		}
+		sizes[level] = len(archives.files["orders.zip"])
+	}
+	if sizes[9] >= sizes[1] {
+		t.Errorf("level 9 gives %d bytes, level 1 gives %d bytes", sizes[9], sizes[1])
+	}
+}
@@ -0,0 +1,494 @@
+package zip
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encryption is the method used to encrypt the files in an archive.
+type Encryption int
+
+const (
+	AES256    Encryption = iota // WinZip AES encryption with a 256 bit key, the default
+	AES192                      // WinZip AES encryption with a 192 bit key
+	AES128                      // WinZip AES encryption with a 128 bit key
+	ZipCrypto                   // Traditional PKWARE encryption, which is weak, but supported by every zip tool
+)
+
+// ErrWrongPassword is returned when a file in an archive cannot be decrypted with the password.
+var ErrWrongPassword = errors.New("zip: Wrong password")
+
+const (
+	flagEncrypted      = 0x1    // General purpose flag: the file is encrypted
+	flagDataDescriptor = 0x8    // General purpose flag: sizes and CRC follow the data
+	flagStrong         = 0x40   // General purpose flag: strong encryption, not supported
+	flagUTF8           = 0x800  // General purpose flag: the name is UTF-8
+	methodAES          = 99     // Compression method of WinZip AES encrypted files
+	extraAES           = 0x9901 // Extra field with the WinZip AES parameters
+	extraTime          = 0x5455 // Extra field with the extended timestamp
+	aesVersion2        = 2      // AE-2, without CRC, the recommended version
+	aesIterations      = 1000   // PBKDF2 iterations of WinZip AES
+	aesAuthLen         = 10     // Length of the authentication code of WinZip AES
+	zipCryptoHeaderLen = 12     // Length of the encryption header of ZipCrypto
+)
+
+// aesStrength returns the strength byte of the extra field, and the key length in bytes
+func (e Encryption) aesStrength() (byte, int) {
+	switch e {
+	case AES128:
+		return 1, 16
+	case AES192:
+		return 2, 24
+	default:
+		return 3, 32
+	}
+}
+
+// createEncrypted adds an encrypted file to the archive. The file is compressed with the method of the header,
+// and the level for Deflate. The returned writer must be closed before the next file is added.
+func createEncrypted(zipWriter *zip.Writer, header *zip.FileHeader, encryption Encryption, password string, level int) (io.WriteCloser, error) {
+	method := header.Method
+	prepareRawHeader(header)
+	header.Flags |= flagEncrypted | flagDataDescriptor
+
+	// Prepare the encryption, and the data that precedes the encrypted data
+	var preamble []byte
+	var encrypt func(dst, src []byte)
+	var mac hash.Hash
+	switch encryption {
+	case AES256, AES192, AES128:
+		strength, keyLen := encryption.aesStrength()
+		salt := make([]byte, keyLen/2)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("zip: Failed to generate salt: %s", err)
+		}
+		encKey, authKey, verifier := aesKeys(password, salt, keyLen)
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("zip: Failed to create cipher: %s", err)
+		}
+		stream := newWinZipCTR(block)
+		encrypt = stream.XORKeyStream
+		mac = hmac.New(sha1.New, authKey)
+		preamble = append(salt, verifier...)
+
+		header.Method = methodAES
+		header.ReaderVersion = 51
+		header.Extra = append(header.Extra, aesExtra(strength, method)...)
+	case ZipCrypto:
+		keys := newZipCryptoKeys(password)
+		preamble = make([]byte, zipCryptoHeaderLen)
+		if _, err := rand.Read(preamble); err != nil {
+			return nil, fmt.Errorf("zip: Failed to generate encryption header: %s", err)
+		}
+		preamble[zipCryptoHeaderLen-1] = byte(header.ModifiedTime >> 8) // check byte, the CRC is not known yet
+		keys.encrypt(preamble, preamble)
+		encrypt = keys.encrypt
+	default:
+		return nil, fmt.Errorf("zip: Unknown encryption %d", encryption)
+	}
+
+	// Write the header and the preamble
+	raw, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return nil, err
+	}
+	counted := &countWriter{w: raw}
+	if _, err := counted.Write(preamble); err != nil {
+		return nil, err
+	}
+
+	// Compress, then encrypt
+	w := &encryptWriter{
+		header:    header,
+		raw:       counted,
+		encrypted: &cipherWriter{w: counted, encrypt: encrypt, mac: mac},
+		crc:       crc32.NewIEEE(),
+		aes:       mac != nil,
+	}
+	switch method {
+	case zip.Store:
+		w.compressor = nopWriteCloser{w.encrypted}
+	case zip.Deflate:
+		w.compressor, err = flate.NewWriter(w.encrypted, level)
+		if err != nil {
+			return nil, fmt.Errorf("zip: Failed to create compressor: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("zip: Unsupported compression method %d", method)
+	}
+	return w, nil
+}
+
+// openEncrypted opens an encrypted file in an archive for reading. The data is decrypted, decompressed and verified.
+func openEncrypted(file *zip.File, password string) (io.ReadCloser, error) {
+	if file.Flags&flagStrong != 0 {
+		return nil, fmt.Errorf("zip: File %s uses strong encryption, which is not supported", file.Name)
+	}
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, fmt.Errorf("zip: Failed to open file %s: %s", file.Name, err)
+	}
+
+	r := &verifyReader{crc: crc32.NewIEEE(), wantCRC: file.CRC32, checkCRC: true}
+	method := file.Method
+	var decrypted io.Reader
+	if file.Method == methodAES {
+
+		// Read the parameters from the extra field
+		version, strength, actualMethod, ok := parseAESExtra(file.Extra)
+		if !ok {
+			return nil, fmt.Errorf("zip: File %s has no valid AES extra field", file.Name)
+		}
+		method = actualMethod
+		r.checkCRC = version != aesVersion2
+		keyLen := map[byte]int{1: 16, 2: 24, 3: 32}[strength]
+		if keyLen == 0 {
+			return nil, fmt.Errorf("zip: File %s has unknown AES strength %d", file.Name, strength)
+		}
+
+		// Check the password
+		preamble := make([]byte, keyLen/2+2)
+		if _, err := io.ReadFull(raw, preamble); err != nil {
+			return nil, fmt.Errorf("zip: Failed to read encryption header of %s: %s", file.Name, err)
+		}
+		encKey, authKey, verifier := aesKeys(password, preamble[:keyLen/2], keyLen)
+		if !hmac.Equal(verifier, preamble[keyLen/2:]) {
+			return nil, fmt.Errorf("%w for %s", ErrWrongPassword, file.Name)
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("zip: Failed to create cipher: %s", err)
+		}
+
+		// Decrypt, and check the authentication code at the end
+		dataLen := int64(file.CompressedSize64) - int64(len(preamble)) - aesAuthLen
+		if dataLen < 0 {
+			return nil, fmt.Errorf("zip: File %s is too short", file.Name)
+		}
+		mac := hmac.New(sha1.New, authKey)
+		cr := &cipherReader{r: io.LimitReader(raw, dataLen), decrypt: newWinZipCTR(block).XORKeyStream, mac: mac}
+		decrypted = cr
+		r.remaining = cr
+		r.verify = func() error {
+			code := make([]byte, aesAuthLen)
+			if _, err := io.ReadFull(raw, code); err != nil {
+				return fmt.Errorf("zip: Failed to read authentication code of %s: %s", file.Name, err)
+			}
+			if !hmac.Equal(code, mac.Sum(nil)[:aesAuthLen]) {
+				return fmt.Errorf("zip: Authentication of %s failed, the file is corrupt or tampered with", file.Name)
+			}
+			return nil
+		}
+	} else {
+
+		// Check the password with the last byte of the encryption header
+		keys := newZipCryptoKeys(password)
+		preamble := make([]byte, zipCryptoHeaderLen)
+		if _, err := io.ReadFull(raw, preamble); err != nil {
+			return nil, fmt.Errorf("zip: Failed to read encryption header of %s: %s", file.Name, err)
+		}
+		keys.decrypt(preamble, preamble)
+		check := byte(file.CRC32 >> 24)
+		if file.Flags&flagDataDescriptor != 0 {
+			check = byte(file.ModifiedTime >> 8)
+		}
+		if preamble[zipCryptoHeaderLen-1] != check {
+			return nil, fmt.Errorf("%w for %s", ErrWrongPassword, file.Name)
+		}
+		cr := &cipherReader{r: raw, decrypt: keys.decrypt}
+		decrypted = cr
+		r.remaining = cr
+	}
+
+	// Decompress
+	switch method {
+	case zip.Store:
+		r.rc = io.NopCloser(decrypted)
+	case zip.Deflate:
+		r.rc = flate.NewReader(decrypted)
+	default:
+		return nil, fmt.Errorf("zip: File %s uses unsupported compression method %d", file.Name, method)
+	}
+	return r, nil
+}
+
+// prepareRawHeader sets the fields that archive/zip sets for files that are not added raw
+func prepareRawHeader(header *zip.FileHeader) {
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+	if utf8.ValidString(header.Name) && !isASCII(header.Name) {
+		header.Flags |= flagUTF8
+	}
+	if !header.Modified.IsZero() {
+		t := header.Modified
+		header.ModifiedDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+		header.ModifiedTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+		extra := make([]byte, 9)
+		binary.LittleEndian.PutUint16(extra[0:], extraTime)
+		binary.LittleEndian.PutUint16(extra[2:], 5)
+		extra[4] = 1 // modification time only
+		binary.LittleEndian.PutUint32(extra[5:], uint32(t.Unix()))
+		header.Extra = append(header.Extra, extra...)
+	}
+}
+
+// isASCII reports whether the string has only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// aesKeys derives the encryption key, authentication key and password verifier of WinZip AES
+func aesKeys(password string, salt []byte, keyLen int) (encKey, authKey, verifier []byte) {
+	key := pbkdf2.Key([]byte(password), salt, aesIterations, 2*keyLen+2, sha1.New)
+	return key[:keyLen], key[keyLen : 2*keyLen], key[2*keyLen:]
+}
+
+// aesExtra returns the extra field of WinZip AES
+func aesExtra(strength byte, method uint16) []byte {
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], extraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], aesVersion2)
+	copy(extra[6:], "AE")
+	extra[8] = strength
+	binary.LittleEndian.PutUint16(extra[9:], method)
+	return extra
+}
+
+// parseAESExtra finds the WinZip AES parameters in the extra fields
+func parseAESExtra(extra []byte) (version uint16, strength byte, method uint16, ok bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return 0, 0, 0, false
+		}
+		if id == extraAES && size >= 7 {
+			data := extra[4 : 4+size]
+			return binary.LittleEndian.Uint16(data[0:]), data[4], binary.LittleEndian.Uint16(data[5:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, 0, false
+}
+
+// winZipCTR is AES in counter mode as WinZip uses it: a little-endian counter that starts at 1.
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newWinZipCTR(block cipher.Block) *winZipCTR {
+	return &winZipCTR{block: block, pos: aes.BlockSize}
+}
+
+// XORKeyStream encrypts or decrypts src into dst
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// zipCryptoKeys is the state of the traditional PKWARE encryption.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) streamByte() byte {
+	t := k[2] | 2
+	return byte((t * (t ^ 1)) >> 8)
+}
+
+func (k *zipCryptoKeys) encrypt(dst, src []byte) {
+	for i, b := range src {
+		dst[i] = b ^ k.streamByte()
+		k.update(b)
+	}
+}
+
+func (k *zipCryptoKeys) decrypt(dst, src []byte) {
+	for i, b := range src {
+		dst[i] = b ^ k.streamByte()
+		k.update(dst[i])
+	}
+}
+
+// encryptWriter compresses and encrypts a file, and completes its header when it is closed.
+type encryptWriter struct {
+	header     *zip.FileHeader
+	raw        *countWriter
+	encrypted  *cipherWriter
+	compressor io.WriteCloser
+	crc        hash.Hash32
+	size       uint64
+	aes        bool
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	w.crc.Write(p)
+	w.size += uint64(len(p))
+	return w.compressor.Write(p)
+}
+
+// Close flushes the compressor, writes the authentication code and sets the sizes and CRC in the header,
+// which archive/zip writes in the data descriptor and the central directory.
+func (w *encryptWriter) Close() error {
+	if err := w.compressor.Close(); err != nil {
+		return err
+	}
+	if w.aes {
+		if _, err := w.raw.Write(w.encrypted.mac.Sum(nil)[:aesAuthLen]); err != nil {
+			return err
+		}
+	} else {
+		w.header.CRC32 = w.crc.Sum32() // AE-2 leaves the CRC empty
+	}
+	w.header.UncompressedSize64 = w.size
+	w.header.CompressedSize64 = w.raw.count
+	w.header.UncompressedSize = uint32(min(w.size, 0xffffffff))
+	w.header.CompressedSize = uint32(min(w.raw.count, 0xffffffff))
+	return nil
+}
+
+// cipherWriter encrypts the data, and authenticates the encrypted data if there is a MAC.
+type cipherWriter struct {
+	w       io.Writer
+	encrypt func(dst, src []byte)
+	mac     hash.Hash
+	buf     []byte
+}
+
+func (c *cipherWriter) Write(p []byte) (int, error) {
+	if cap(c.buf) < len(p) {
+		c.buf = make([]byte, len(p))
+	}
+	buf := c.buf[:len(p)]
+	c.encrypt(buf, p)
+	if c.mac != nil {
+		c.mac.Write(buf)
+	}
+	return c.w.Write(buf)
+}
+
+// cipherReader decrypts the data, and authenticates the encrypted data if there is a MAC.
+type cipherReader struct {
+	r       io.Reader
+	decrypt func(dst, src []byte)
+	mac     hash.Hash
+}
+
+func (c *cipherReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.mac != nil {
+		c.mac.Write(p[:n])
+	}
+	c.decrypt(p[:n], p[:n])
+	return n, err
+}
+
+// verifyReader checks the CRC and the authentication code when the file has been read completely.
+type verifyReader struct {
+	rc        io.ReadCloser
+	remaining io.Reader // the decrypted data, which is read to the end before verifying
+	verify    func() error
+	crc       hash.Hash32
+	wantCRC   uint32
+	checkCRC  bool
+	done      bool
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	n, err := r.rc.Read(p)
+	r.crc.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	r.done = true
+
+	// The decompressor may stop before the end of the data, the authentication code covers all of it
+	if _, err := io.Copy(io.Discard, r.remaining); err != nil {
+		return n, err
+	}
+	if r.verify != nil {
+		if err := r.verify(); err != nil {
+			return n, err
+		}
+	}
+	if r.checkCRC && r.crc.Sum32() != r.wantCRC {
+		return n, zip.ErrChecksum
+	}
+	return n, io.EOF
+}
+
+func (r *verifyReader) Close() error {
+	return r.rc.Close()
+}
+
+// countWriter counts the bytes written.
+type countWriter struct {
+	w     io.Writer
+	count uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += uint64(n)
+	return n, err
+}
+
+// nopWriteCloser adds a Close method that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
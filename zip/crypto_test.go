@@ -0,0 +1,192 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// secret returns the password as a harvester.Secret
+func secret(password string) harvester.Secret {
+	return harvester.SecretFunc(func(ctx context.Context) (string, error) { return password, nil })
+}
+
+// extractAll extracts all files from the archive with the password
+func extractAll(archive []byte, password string) (map[string]string, error) {
+	files := &recorder{}
+	u := &Decompressor{MultipleFiles: true, Password: secret(password)}
+	u.SetNext(files)
+	err := u.Process(context.Background(), "archive.zip", bytes.NewReader(archive))
+	return files.files, err
+}
+
+// The archives in testdata were made by other tools: hello-aes.zip and world-aes.zip with 7-Zip, and taken from
+// github.com/alexmullins/zip (MIT license), and the zipcrypto archives with Info-ZIP 3.0.
+func TestDecryptKnownArchives(t *testing.T) {
+	witches := strings.Repeat("ACT I. SCENE I. A desert place. Thunder and lightning. Enter three Witches.\n", 40)
+	tests := []struct {
+		file     string
+		password string
+		want     map[string]string
+	}{
+		{"hello-aes.zip", "golang", map[string]string{"hello.txt": "Hello World\r\n"}},
+		{"world-aes.zip", "golang", map[string]string{"hello.txt": "hello", "world.txt": "world"}},
+		{"zipcrypto-store.zip", "harvester", map[string]string{"fox.txt": "The quick brown fox jumps over the lazy dog.\n"}},
+		{"zipcrypto-deflate.zip", "harvester", map[string]string{"witches.txt": witches}},
+		{"zipcrypto-stream.zip", "harvester", map[string]string{"-": "streamed from standard input\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			archive, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := extractAll(archive, tt.password)
+			if err != nil {
+				t.Fatalf("extracting with the right password: %s", err)
+			}
+			if !reflect.DeepEqual(files, tt.want) {
+				t.Errorf("extracted %q, want %q", files, tt.want)
+			}
+
+			// The check bytes of these archives reject this password
+			if _, err := extractAll(archive, "wrong"); !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("extracting with a wrong password returned %v, want ErrWrongPassword", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	data := strings.Repeat("order;customer;amount\n", 500)
+	for _, encryption := range []Encryption{AES256, AES192, AES128, ZipCrypto} {
+		for _, store := range []bool{false, true} {
+			name := map[Encryption]string{AES256: "AES256", AES192: "AES192", AES128: "AES128", ZipCrypto: "ZipCrypto"}[encryption]
+			name += map[bool]string{false: " deflate", true: " store"}[store]
+			t.Run(name, func(t *testing.T) {
+				archives := &recorder{}
+				z := &Compressor{Password: secret("s3cret"), Encryption: encryption, Store: store}
+				z.SetNext(archives)
+				if err := z.Process(context.Background(), "orders.csv", strings.NewReader(data)); err != nil {
+					t.Fatalf("Process: %s", err)
+				}
+				archive := []byte(archives.files["orders.zip"])
+
+				// The archive describes the encryption as other tools expect
+				zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+				if err != nil {
+					t.Fatalf("archive/zip cannot read the archive: %s", err)
+				}
+				file := zipReader.File[0]
+				if file.Flags&flagEncrypted == 0 {
+					t.Errorf("file is not marked as encrypted")
+				}
+				if encryption != ZipCrypto {
+					_, strength, method, ok := parseAESExtra(file.Extra)
+					wantStrength, _ := encryption.aesStrength()
+					wantMethod := map[bool]uint16{false: zip.Deflate, true: zip.Store}[store]
+					if file.Method != methodAES || !ok || strength != wantStrength || method != wantMethod {
+						t.Errorf("method %d, AES extra field %v with strength %d and method %d, want method %d with strength %d and method %d",
+							file.Method, ok, strength, method, methodAES, wantStrength, wantMethod)
+					}
+				}
+
+				files, err := extractAll(archive, "s3cret")
+				if err != nil {
+					t.Fatalf("extracting: %s", err)
+				}
+				if files["orders.csv"] != data {
+					t.Errorf("extracted %d bytes, want the %d bytes that were compressed", len(files["orders.csv"]), len(data))
+				}
+
+				// A wrong password fails. The check byte of ZipCrypto lets one in 256 wrong passwords through, and then
+				// the checksum fails, so only the error is certain.
+				if _, err := extractAll(archive, "wrong"); err == nil {
+					t.Errorf("extracting with a wrong password succeeded")
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	for _, encryption := range []Encryption{AES256, ZipCrypto} {
+		archives := &recorder{}
+		z := &Compressor{Password: secret("s3cret"), Encryption: encryption, Store: true}
+		z.SetNext(archives)
+		if err := z.Process(context.Background(), "orders.csv", strings.NewReader("order;customer;amount\n")); err != nil {
+			t.Fatalf("Process: %s", err)
+		}
+
+		// Change a byte of the encrypted data, after the local header, the name and the extra fields
+		archive := []byte(archives.files["orders.zip"])
+		i := bytes.Index(archive, []byte("orders.csv")) + len("orders.csv") + 40
+		archive[i] ^= 0x01
+		if files, err := extractAll(archive, "s3cret"); err == nil {
+			t.Errorf("encryption %d: extracting a changed archive succeeded with %q", encryption, files)
+		}
+	}
+}
+
+func TestWinZipCTR(t *testing.T) {
+	// WinZip counts in little-endian from 1, so the key stream is the encryption of 1, 2, ... as little-endian blocks
+	key := bytes.Repeat([]byte{0x42}, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for i := 1; i <= 300; i++ {
+		counter := make([]byte, aes.BlockSize)
+		counter[0], counter[1] = byte(i), byte(i>>8)
+		stream := make([]byte, aes.BlockSize)
+		block.Encrypt(stream, counter)
+		want = append(want, stream...)
+	}
+
+	// In pieces of odd sizes, to cross the blocks
+	got := make([]byte, len(want))
+	ctr := newWinZipCTR(block)
+	for start := 0; start < len(got); start += 7 {
+		end := min(start+7, len(got))
+		ctr.XORKeyStream(got[start:end], make([]byte, end-start))
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("key stream differs from AES of a little-endian counter")
+	}
+}
+
+func TestUnzipReadsZipCrypto(t *testing.T) {
+	if _, err := exec.LookPath("unzip"); err != nil {
+		t.Skip("unzip is not installed")
+	}
+	for _, store := range []bool{false, true} {
+		archives := &recorder{}
+		z := &Compressor{Password: secret("s3cret"), Encryption: ZipCrypto, Store: store}
+		z.SetNext(archives)
+		data := strings.Repeat("order;customer;amount\n", 100)
+		if err := z.Process(context.Background(), "orders.csv", strings.NewReader(data)); err != nil {
+			t.Fatalf("Process: %s", err)
+		}
+
+		path := filepath.Join(t.TempDir(), "orders.zip")
+		if err := os.WriteFile(path, []byte(archives.files["orders.zip"]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command("unzip", "-P", "s3cret", "-p", path).Output()
+		if err != nil || string(out) != data {
+			t.Errorf("store %v: unzip returned %d bytes and error %v, want the %d bytes that were compressed", store, len(out), err, len(data))
+		}
+	}
+}
@@ -2,43 +2,85 @@ package zip
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"path"
+	"path/filepath"
+	"regexp"
 
 	"github.com/gwijnja/harvester"
 )
 
-// Decompressor extracts a file from a zip archive and presents it to the next processor in the chain.
+// PartialFailure decides what happens to an archive when only some of its entries were processed successfully.
+type PartialFailure int
+
+const (
+	// FailArchive stops at the first entry that fails, and fails the archive. The reader leaves the archive where it is,
+	// so all entries are extracted again on the next run, including the ones that succeeded.
+	FailArchive PartialFailure = iota
+
+	// AcceptArchive continues with the other entries when an entry fails, and the archive succeeds if at least one entry
+	// succeeded. The failed entries are only recorded in the log and the audit trail.
+	AcceptArchive
+)
+
+// Decompressor extracts files from a zip archive and presents them to the next processor in the chain.
+// Zip archives need random access, so the archive is staged first: in memory up to MaxMemory bytes,
+// and in a temporary file in TempDir if it is larger. The extracted files are streamed to the next processor.
 type Decompressor struct {
 	harvester.NextProcessor
+	TempDir       string           // Directory for archives larger than MaxMemory, empty for the default directory of the OS
+	MaxMemory     int64            // Archives up to this size are kept in memory, 0 for harvester.DefaultMaxMemory, -1 to always use a temporary file
+	MultipleFiles bool             // Extract every file in the archive as a separate transfer, instead of expecting exactly one file
+	Regex         string           // Only extract files whose path in the archive matches, empty for all files (MultipleFiles only)
+	Flatten       bool             // Name the extracted files without the directories they are in within the archive (MultipleFiles only)
+	OnPartial     PartialFailure   // What happens to the archive when only some files succeed (MultipleFiles only)
+	Password      harvester.Secret // Password of encrypted files, nil if the files are not encrypted
+	Logger        *slog.Logger     // nil for the logger of the job
 }
 
-// Process reads a zip file and writes the contents of the first file to the next processor
-func (u *Decompressor) Process(_ string, r io.Reader) error {
+// Process reads a zip file and writes the contents of its files to the next processor
+func (u *Decompressor) Process(ctx context.Context, _ string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
 
-	// Copy the contents of the reader to a buffer
-	var buf bytes.Buffer
-	written, err := harvester.AuditCopy(&buf, r)
+	// Get the password before anything is read
+	password := ""
+	if u.Password != nil {
+		var err error
+		password, err = u.Password.Secret(ctx)
+		if err != nil {
+			return fmt.Errorf("zip: Failed to get password: %s", err)
+		}
+	}
+
+	// Stage the archive, and always remove the temporary file afterwards
+	staged := &harvester.SpillBuffer{TempDir: u.TempDir, MaxMemory: u.MaxMemory, Logger: logger}
+	defer staged.Close()
+
+	written, err := harvester.AuditCopy(ctx, "zip.Decompressor (archive)", staged, r)
 	if err != nil {
-		return fmt.Errorf("zip: Failed to copy reader to buffer: %s", err)
+		return fmt.Errorf("zip: Failed to stage archive: %s", err)
 	}
-	slog.Debug("zip: Copied contents to a buffer", slog.Int64("bytes", written))
+	logger.Debug("zip: Staged archive", slog.Int64("bytes", written), slog.Bool("spilled", staged.Spilled()))
 
 	// Initialize a zip reader
-	reader := bytes.NewReader(buf.Bytes())
-	zipReader, err := zip.NewReader(reader, int64(reader.Len()))
+	zipReader, err := zip.NewReader(staged.ReaderAt(), staged.Size())
 	if err != nil {
 		return fmt.Errorf("zip: Failed to initialize zip reader: %s", err)
 	}
-	slog.Info("zip: Initialized zip reader")
+	logger.Info("zip: Initialized zip reader")
+
+	if u.MultipleFiles {
+		return u.extractAll(ctx, zipReader, password)
+	}
 
 	// Check if the zip reader contains exactly one file
 	if len(zipReader.File) != 1 {
 		return fmt.Errorf("zip: Expected only one file in the zip reader, found %d", len(zipReader.File))
 	}
-	slog.Info("zip: Found one entry in the zip reader", slog.String("filename", zipReader.File[0].Name))
+	logger.Info("zip: Found one entry in the zip reader", slog.String("filename", zipReader.File[0].Name))
 
 	// Check if the entry is a file, not a directory
 	file := zipReader.File[0]
@@ -46,28 +88,119 @@ func (u *Decompressor) Process(_ string, r io.Reader) error {
 		return fmt.Errorf("zip: Expected a file, got a directory: %s", file.Name)
 	}
 
-	// Open the file in the zip reader
-	readCloser, err := file.Open()
+	return u.extract(ctx, file, file.Name, password)
+}
+
+// extractAll presents every matching file in the archive to the next processor, each as a transfer of its own
+func (u *Decompressor) extractAll(ctx context.Context, zipReader *zip.Reader, password string) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
+
+	// Prepare the regex
+	re, err := regexp.Compile(u.Regex)
+	if err != nil {
+		return fmt.Errorf("zip: Failed to compile regex %s: %s", u.Regex, err)
+	}
+
+	succeeded, failed := 0, 0
+	var firstErr error
+	for _, file := range zipReader.File {
+
+		// Skip directories and files that do not match the regex
+		if file.FileInfo().IsDir() {
+			logger.Debug("zip: Skipping directory", slog.String("filename", file.Name))
+			continue
+		}
+		if !re.MatchString(file.Name) {
+			logger.Warn("zip: Skipping non-matching file", slog.String("filename", file.Name))
+			continue
+		}
+
+		// Stop when the job is cancelled
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Extract the file as a transfer of its own
+		filename := file.Name
+		if u.Flatten {
+			filename = path.Base(file.Name)
+		}
+		entryCtx := harvester.StartEntryTransfer(ctx, file.Name)
+		harvester.SetSourceModTime(entryCtx, file.Modified)
+		harvester.SetSourceMode(entryCtx, file.Mode().Perm())
+		err := u.extract(entryCtx, file, filename, password)
+		harvester.FinishTransfer(entryCtx, err)
+		if err != nil {
+			failed++
+			harvester.ContextLogger(entryCtx, u.Logger).Error("zip: Failed to process file", slog.String("filename", file.Name), slog.Any("error", err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			if u.OnPartial == FailArchive {
+				return fmt.Errorf("zip: Failed to process %s from the archive: %w", file.Name, err)
+			}
+			continue
+		}
+		succeeded++
+	}
+	logger.Info("zip: Extracted archive", slog.Int("succeeded", succeeded), slog.Int("failed", failed))
+
+	// The archive fails if nothing was delivered
+	if succeeded == 0 {
+		if firstErr != nil {
+			return fmt.Errorf("zip: All %d files in the archive failed, first error: %w", failed, firstErr)
+		}
+		return fmt.Errorf("zip: No matching files in the archive")
+	}
+	if failed > 0 {
+		logger.Warn("zip: Accepted archive with failed files", slog.Int("failed", failed))
+	}
+	return nil
+}
+
+// extract streams a single file from the archive to the next processor, under the given filename.
+// Encrypted files are decrypted with the password.
+func (u *Decompressor) extract(ctx context.Context, file *zip.File, filename string, password string) error {
+	logger := harvester.ContextLogger(ctx, u.Logger)
+
+	// Do not let a file escape from the directory of a writer, for example with a name like ../../etc/passwd
+	if !filepath.IsLocal(filepath.FromSlash(filename)) {
+		return fmt.Errorf("zip: Refusing to extract %s, the path is not inside the archive", file.Name)
+	}
+
+	// Open the file in the zip reader, and decrypt it if needed
+	var readCloser io.ReadCloser
+	var err error
+	if file.Flags&flagEncrypted != 0 {
+		if password == "" {
+			return fmt.Errorf("zip: File %s is encrypted, but there is no Password", file.Name)
+		}
+		readCloser, err = openEncrypted(file, password)
+	} else {
+		readCloser, err = file.Open()
+	}
 	if err != nil {
-		return fmt.Errorf("zip: Failed to open the file in the zip reader: %s", err)
+		return fmt.Errorf("zip: Failed to open the file in the zip reader: %w", err)
 	}
-	slog.Debug("zip: Opened the file in the zip reader")
+	logger.Debug("zip: Opened the file in the zip reader", slog.String("filename", file.Name))
 
 	defer func() {
 		readCloser.Close()
-		slog.Info("zip: Closed the file in the zip reader", slog.String("filename", file.Name))
+		logger.Info("zip: Closed the file in the zip reader", slog.String("filename", file.Name))
 	}()
 
-	fileBuf := new(bytes.Buffer)
-	if _, err := harvester.AuditCopy(fileBuf, readCloser); err != nil {
-		return fmt.Errorf("zip: Failed copying the file into a buffer: %s", err)
-	}
-	slog.Debug(
-		"zip: Extracted the file into a buffer",
-		slog.String("filename", file.Name),
-		slog.Int64("bytes", int64(fileBuf.Len())),
+	// Stream the file to the next processor, the zip reader verifies the checksum at the end
+	return harvester.Stream(
+		func(w io.Writer) error {
+			written, err := harvester.AuditCopy(ctx, "zip.Decompressor (entry)", w, readCloser)
+			if err != nil {
+				return fmt.Errorf("zip: Failed extracting the file: %w", err)
+			}
+			logger.Debug("zip: Extracted the file", slog.String("filename", file.Name), slog.Int64("bytes", written))
+			return nil
+		},
+		func(r io.Reader) error {
+			return u.NextProcessor.Process(ctx, filename, r)
+		},
 	)
-
-	r = bytes.NewReader(fileBuf.Bytes())
-	return u.NextProcessor.Process(file.Name, r)
 }
@@ -0,0 +1,232 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+	"github.com/gwijnja/harvester/local"
+)
+
+// recorder is the next processor in the tests, and keeps the files it receives
+type recorder struct {
+	files map[string]string
+	fail  map[string]bool // files that fail
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	if r.fail[filename] {
+		return errors.New("disk full")
+	}
+	if r.files == nil {
+		r.files = map[string]string{}
+	}
+	r.files[filename] = string(b)
+	return nil
+}
+
+// archive returns a zip archive with the files, in order
+func archive(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, "content of "+name)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressorRejectsPathsOutsideArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		multiple bool
+		flatten  bool
+		wantErr  bool
+		wantFile string
+	}{
+		{"single local", "orders.csv", false, false, false, "orders.csv"},
+		{"single in directory", "dir/orders.csv", false, false, false, "dir/orders.csv"},
+		{"single parent", "../../etc/x", false, false, true, ""},
+		{"single absolute", "/etc/x", false, false, true, ""},
+		{"multiple parent", "../../etc/x", true, false, true, ""},
+		{"multiple hidden parent", "dir/../../x", true, false, true, ""},
+		{"multiple absolute", "/etc/x", true, false, true, ""},
+		{"multiple flattened parent", "../../etc/x", true, true, false, "x"},
+		{"multiple local", "dir/orders.csv", true, false, false, "dir/orders.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recorder{}
+			d := &Decompressor{MultipleFiles: tt.multiple, Flatten: tt.flatten}
+			d.SetNext(next)
+
+			err := d.Process(context.Background(), "archive.zip", bytes.NewReader(archive(t, tt.entry)))
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "not inside the archive") {
+					t.Fatalf("want an error about the path, got %v", err)
+				}
+				if len(next.files) != 0 {
+					t.Fatalf("want no files delivered, got %v", next.files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, ok := next.files[tt.wantFile]; !ok || len(next.files) != 1 {
+				t.Fatalf("want only %s, got %v", tt.wantFile, next.files)
+			}
+		})
+	}
+}
+
+func TestDecompressorAcceptsOtherEntriesAfterUnsafePath(t *testing.T) {
+	next := &recorder{}
+	d := &Decompressor{MultipleFiles: true, OnPartial: AcceptArchive}
+	d.SetNext(next)
+
+	err := d.Process(context.Background(), "archive.zip", bytes.NewReader(archive(t, "../evil", "good.txt")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(next.files) != 1 || next.files["good.txt"] != "content of good.txt" {
+		t.Fatalf("want only good.txt, got %v", next.files)
+	}
+}
+
+// auditStore keeps the audit records of the tests
+type auditStore struct {
+	records []harvester.AuditRecord
+}
+
+func (s *auditStore) Save(ctx context.Context, record harvester.AuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestDecompressorMultipleFiles(t *testing.T) {
+	entries := []string{"orders/", "orders/a.csv", "orders/b.csv", "readme.txt"}
+	tests := []struct {
+		name         string
+		decompressor Decompressor
+		fail         map[string]bool
+		wantErr      bool
+		wantFiles    []string
+		wantFailed   []string // entries with a failed audit record
+	}{
+		{"all files", Decompressor{}, nil, false, []string{"orders/a.csv", "orders/b.csv", "readme.txt"}, nil},
+		{"regex", Decompressor{Regex: `\.csv$`}, nil, false, []string{"orders/a.csv", "orders/b.csv"}, nil},
+		{"flatten", Decompressor{Flatten: true}, nil, false, []string{"a.csv", "b.csv", "readme.txt"}, nil},
+		{"no matching files", Decompressor{Regex: `\.xml$`}, nil, true, nil, nil},
+		{"invalid regex", Decompressor{Regex: "("}, nil, true, nil, nil},
+		{"fail archive", Decompressor{OnPartial: FailArchive}, map[string]bool{"orders/b.csv": true}, true, []string{"orders/a.csv"}, []string{"orders/b.csv"}},
+		{"accept archive", Decompressor{OnPartial: AcceptArchive}, map[string]bool{"orders/a.csv": true}, false, []string{"orders/b.csv", "readme.txt"}, []string{"orders/a.csv"}},
+		{"all files fail", Decompressor{OnPartial: AcceptArchive, Regex: `\.csv$`}, map[string]bool{"orders/a.csv": true, "orders/b.csv": true}, true, nil, []string{"orders/a.csv", "orders/b.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &auditStore{}
+			ctx := harvester.StartTransfer(harvester.WithAuditStore(harvester.StartRun(context.Background()), store), "local", "/in/archive.zip")
+			next := &recorder{fail: tt.fail}
+			d := tt.decompressor
+			d.MultipleFiles = true
+			d.SetNext(next)
+
+			err := d.Process(ctx, "archive.zip", bytes.NewReader(archive(t, entries...)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process returned %v, want error %v", err, tt.wantErr)
+			}
+			var files []string
+			for filename := range next.files {
+				files = append(files, filename)
+			}
+			sort.Strings(files)
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("delivered %v, want %v", files, tt.wantFiles)
+			}
+
+			// Every extracted file is a transfer of its own, part of the transfer of the archive
+			var failed []string
+			archiveID := harvester.TransferFromContext(ctx).TransferID
+			for _, record := range store.records {
+				if record.ParentID != archiveID || record.SourcePath != "/in/archive.zip" || len(record.Hops) != 2 {
+					t.Errorf("record of %s has parent %s, source %s and %d hops", record.SourceEntry, record.ParentID, record.SourcePath, len(record.Hops))
+				}
+				if record.Status == harvester.StatusFailed {
+					failed = append(failed, record.SourceEntry)
+				}
+			}
+			if len(store.records) != len(tt.wantFiles)+len(tt.wantFailed) {
+				t.Errorf("saved %d records, want %d", len(store.records), len(tt.wantFiles)+len(tt.wantFailed))
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("failed entries are %v, want %v", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestDecompressorNestedFilesToFileWriter(t *testing.T) {
+	transmit, toLoad := t.TempDir(), t.TempDir()
+	d := &Decompressor{MultipleFiles: true}
+	d.SetNext(&local.FileWriter{Transmit: transmit, ToLoad: toLoad})
+
+	err := d.Process(context.Background(), "archive.zip", bytes.NewReader(archive(t, "orders/", "orders/2024/a.csv", "readme.txt")))
+	if err != nil {
+		t.Fatalf("Process returned %v", err)
+	}
+	for _, name := range []string{"orders/2024/a.csv", "readme.txt"} {
+		b, err := os.ReadFile(filepath.Join(toLoad, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "content of "+name {
+			t.Errorf("%s contains %q, want %q", name, b, "content of "+name)
+		}
+	}
+}
+
+func TestDecompressorExpectsOneFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{"one file", []string{"orders.csv"}, false},
+		{"two files", []string{"orders.csv", "customers.csv"}, true},
+		{"empty", nil, true},
+		{"directory", []string{"orders/"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decompressor{}
+			d.SetNext(&recorder{})
+			if err := d.Process(context.Background(), "archive.zip", bytes.NewReader(archive(t, tt.entries...))); (err != nil) != tt.wantErr {
+				t.Errorf("Process returned %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
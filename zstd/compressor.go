@@ -0,0 +1,76 @@
+package zstd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/gwijnja/harvester"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compresses a file using Zstandard and presents it to the next processor in the chain.
+// The compressed data is streamed to the next processor while it is being compressed, so it is never held in memory.
+//
+// The encoder has four levels instead of the 22 of the zstd tool. The levels of the tool are mapped onto them:
+// 1 and 2 are the fastest, 3 to 5 the default, 6 to 9 compress better, and 10 to 22 compress best. Within a range,
+// all levels give the same result.
+type Compressor struct {
+	harvester.NextProcessor
+	Level  int          // Compression level from 1 (fastest) to 22 (smallest) as in the zstd tool, 0 for 3, the default of zstd
+	Logger *slog.Logger // nil for the logger of the job
+}
+
+// Process reads a file and writes the compressed contents to the next processor
+func (c *Compressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, c.Logger)
+
+	level, err := encoderLevel(c.Level)
+	if err != nil {
+		return err
+	}
+
+	newname := filename + ".zst"
+	logger.Info("zstd: Renamed context filename", slog.String("newname", newname))
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Compress the input into the pipe
+			zstdWriter, err := zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+			if err != nil {
+				return fmt.Errorf("zstd: Failed to create zstd writer: %w", err)
+			}
+			logger.Debug("zstd: Created zstd writer", slog.Int("level", c.Level), slog.String("encoder", level.String()))
+			written, err := harvester.AuditCopy(ctx, "zstd.Compressor", zstdWriter, r)
+			if err != nil {
+				zstdWriter.Close()
+				return fmt.Errorf("zstd: Failed to copy input to zstd writer: %w", err)
+			}
+			logger.Info("zstd: Copied input to zstd writer", slog.String("filename", filename), slog.Int64("bytes", written))
+
+			// Write the last block of the frame
+			err = zstdWriter.Close()
+			if err != nil {
+				return fmt.Errorf("zstd: Failed to close zstd writer: %w", err)
+			}
+			logger.Info("zstd: Closed zstd writer", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("zstd: Calling the next processor")
+			return c.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
+
+// encoderLevel returns the level of the encoder for a level of the zstd tool
+func encoderLevel(level int) (zstd.EncoderLevel, error) {
+	if level == 0 {
+		level = 3
+	}
+	if level < 1 || level > 22 {
+		return 0, fmt.Errorf("zstd: Invalid compression level %d, use 1 to 22", level)
+	}
+	return zstd.EncoderLevelFromZstd(level), nil
+}
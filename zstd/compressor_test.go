@@ -0,0 +1,38 @@
+package zstd
+
+import (
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestEncoderLevel(t *testing.T) {
+	tests := []struct {
+		level   int
+		want    zstd.EncoderLevel
+		wantErr bool
+	}{
+		{-1, 0, true},
+		{0, zstd.SpeedDefault, false},
+		{1, zstd.SpeedFastest, false},
+		{2, zstd.SpeedFastest, false},
+		{3, zstd.SpeedDefault, false},
+		{5, zstd.SpeedDefault, false},
+		{6, zstd.SpeedBetterCompression, false},
+		{9, zstd.SpeedBetterCompression, false},
+		{10, zstd.SpeedBestCompression, false},
+		{19, zstd.SpeedBestCompression, false},
+		{22, zstd.SpeedBestCompression, false},
+		{23, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := encoderLevel(tt.level)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("level %d: error %v, want error %v", tt.level, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("level %d: encoder level %s, want %s", tt.level, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package zstd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/gwijnja/harvester"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Decompressor decompresses a Zstandard file and presents it to the next processor in the chain.
+// The uncompressed data is streamed to the next processor while it is being decompressed, so it is never held in memory.
+type Decompressor struct {
+	harvester.NextProcessor
+	Logger *slog.Logger // nil for the logger of the job
+}
+
+// Process reads a zstd file and writes the uncompressed contents to the next processor
+func (d *Decompressor) Process(ctx context.Context, filename string, r io.Reader) error {
+	logger := harvester.ContextLogger(ctx, d.Logger)
+
+	// Create a zstd reader, decoding in the same goroutine, as the data is streamed anyway
+	zstdReader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return fmt.Errorf("zstd: Failed to create zstd reader for %s: %s", filename, err)
+	}
+	defer zstdReader.Close()
+	logger.Debug("zstd: Created zstd reader", slog.String("filename", filename))
+
+	// Remove the .zst suffix from the filename
+	newname := filename
+	if strings.HasSuffix(filename, ".zst") {
+		newname = strings.TrimSuffix(filename, ".zst")
+		logger.Info("zstd: Removed .zst suffix", slog.String("newname", newname))
+	}
+
+	return harvester.Stream(
+		func(w io.Writer) error {
+			// Decompress the input into the pipe, the zstd reader verifies the checksum of every frame
+			_, err := harvester.AuditCopy(ctx, "zstd.Decompressor", w, zstdReader)
+			if err != nil {
+				return fmt.Errorf("zstd: Failed to decompress %s: %w", filename, err)
+			}
+			logger.Info("zstd: Decompressed file", slog.String("filename", filename))
+			return nil
+		},
+		func(r io.Reader) error {
+			logger.Debug("zstd: Calling the next processor")
+			return d.NextProcessor.Process(ctx, newname, r)
+		},
+	)
+}
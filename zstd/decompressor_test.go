@@ -0,0 +1,52 @@
+package zstd
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gwijnja/harvester"
+)
+
+// recorder is the next processor in the tests, and keeps the last file it received
+type recorder struct {
+	filename string
+	data     string
+}
+
+func (r *recorder) SetNext(next harvester.FileWriter) {}
+
+func (r *recorder) Process(ctx context.Context, filename string, rd io.Reader) error {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.filename, r.data = filename, string(b)
+	return nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	input := strings.Repeat("order;customer;amount\n", 1000)
+	for _, level := range []int{0, 1, 3, 6, 10, 22} {
+		files := &recorder{}
+		d := &Decompressor{}
+		d.SetNext(files)
+		c := &Compressor{Level: level}
+		c.SetNext(d)
+		if err := c.Process(context.Background(), "orders.csv", strings.NewReader(input)); err != nil {
+			t.Errorf("level %d: %s", level, err)
+			continue
+		}
+		if files.filename != "orders.csv" || files.data != input {
+			t.Errorf("level %d: got %s with %d bytes, want orders.csv with the %d bytes that were compressed", level, files.filename, len(files.data), len(input))
+		}
+	}
+
+	// A file that is not zstd fails
+	d := &Decompressor{}
+	d.SetNext(&recorder{})
+	if err := d.Process(context.Background(), "orders.csv.zst", strings.NewReader(input)); err == nil {
+		t.Errorf("decompressing a file that is not zstd succeeded")
+	}
+}